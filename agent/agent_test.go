@@ -0,0 +1,209 @@
+package agent_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/agent"
+	"github.com/wearefair/okta-auth/factors"
+	"github.com/wearefair/okta-auth/oktatest"
+)
+
+const (
+	login    = "first@example.com"
+	password = "correct-horse-battery-staple"
+)
+
+// Scripted prompts that also answer ReadLine and ReadSecret, in order.
+type prompts struct {
+	*oktatest.Prompts
+
+	mu    sync.Mutex
+	lines []string
+	asked []string
+}
+
+func (p *prompts) ReadLine(prompt string) (string, error) {
+	return p.read(prompt)
+}
+
+func (p *prompts) ReadSecret(prompt string) (string, error) {
+	return p.read(prompt)
+}
+
+func (p *prompts) read(prompt string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.asked = append(p.asked, prompt)
+	if len(p.lines) == 0 {
+		return "", errors.New("unexpected prompt " + prompt)
+	}
+	line := p.lines[0]
+	p.lines = p.lines[1:]
+	return line, nil
+}
+
+func newPrompts(t *testing.T, script ...oktatest.Interaction) *prompts {
+	return &prompts{Prompts: oktatest.NewPrompts(t, script...)}
+}
+
+// Starts an agent for a user with an SMS factor, returning the fake org and the
+// agent's socket.
+func startAgent(t *testing.T, conf agent.Config) (*oktatest.Server, string) {
+	server := oktatest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddUser(&oktatest.User{
+		Login:    login,
+		Password: password,
+		Factors:  []*oktatest.Factor{oktatest.SMSFactor("+1 XXX-XXX-5555", "123456")},
+	})
+	server.AddClient(&oktatest.Client{Id: "cli", RedirectURIs: []string{"http://localhost:8080/callback"}})
+
+	conf.Client.OktaDomain = server.URL
+	a, err := agent.NewServer(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := agent.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go a.Serve(listener)
+	return server, path
+}
+
+func TestAgent(t *testing.T) {
+	server, path := startAgent(t, agent.Config{Username: login, Password: password})
+	appURL := server.AddSAMLApp(&oktatest.SAMLApp{Name: "amazon_aws", ACSURL: "https://signin.aws.amazon.com/saml"})
+	ctx := context.Background()
+
+	// The first client's user verifies a factor
+	first := agent.NewClient(path, newPrompts(t,
+		oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+		oktatest.ExpectVerifyCode("123456"),
+	))
+	session, err := first.Session(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Login != login || server.Session(session.Id) == nil {
+		t.Errorf("unexpected session %#+v", session)
+	}
+
+	// Other clients share the session without prompting
+	second := agent.NewClient(path, newPrompts(t))
+	tokens, err := second.Tokens(ctx, okta.TokenRequest{ClientId: "cli", RedirectURI: "http://localhost:8080/callback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.IdToken == "" {
+		t.Errorf("expected an id token, got %#+v", tokens)
+	}
+	assertion, err := second.SAMLAssertion(ctx, okta.SAMLRequest{AppURL: appURL})
+	if err != nil || assertion == "" {
+		t.Errorf("expected an assertion, got %q, %v", assertion, err)
+	}
+	if again, err := second.Session(ctx); err != nil || again.Id != session.Id {
+		t.Errorf("expected the same session, got %#+v, %v", again, err)
+	}
+
+	t.Run("closed sessions are replaced", func(t *testing.T) {
+		client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: oktatest.NewPrompts(t)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.CloseSession(ctx, session.Id); err != nil {
+			t.Fatal(err)
+		}
+
+		// The prompts are forwarded to the client that made the request
+		third := agent.NewClient(path, newPrompts(t,
+			oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+			oktatest.ExpectVerifyCode("000000"),
+			oktatest.ExpectUserError("Invalid Passcode/Answer"),
+			oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+			oktatest.ExpectVerifyCode("123456"),
+		))
+		replaced, err := third.Session(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replaced.Id == session.Id {
+			t.Error("expected a new session")
+		}
+	})
+}
+
+func TestAgentCredentials(t *testing.T) {
+	_, path := startAgent(t, agent.Config{})
+
+	p := newPrompts(t,
+		oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+		oktatest.ExpectVerifyCode("123456"),
+	)
+	p.lines = []string{login, password}
+	session, err := agent.NewClient(path, p).Session(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Login != login {
+		t.Errorf("unexpected session %#+v", session)
+	}
+	if len(p.asked) != 2 || p.asked[0] != "Username: " || !strings.Contains(p.asked[1], "Password for "+login) {
+		t.Errorf("expected the username and password to be asked for, got %q", p.asked)
+	}
+
+	t.Run("wrong password", func(t *testing.T) {
+		_, path := startAgent(t, agent.Config{Username: login})
+		p := newPrompts(t)
+		p.lines = []string{"wrong"}
+		if _, err := agent.NewClient(path, p).Session(context.Background()); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestAgentPeer(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("peer credentials aren't supported")
+	}
+	peers := make(chan agent.Peer, 1)
+	_, path := startAgent(t, agent.Config{
+		Username: login,
+		Password: password,
+		Authorize: func(peer agent.Peer) bool {
+			peers <- peer
+			return false
+		},
+	})
+
+	_, err := agent.NewClient(path, newPrompts(t)).Session(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected permission denied, got %v", err)
+	}
+	if peer := <-peers; peer.Uid != os.Getuid() || peer.Pid != os.Getpid() {
+		t.Errorf("expected this process's credentials, got %#+v", peer)
+	}
+}
+
+func TestListen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := agent.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if _, err := agent.Listen(path); err == nil || !strings.Contains(err.Error(), "already listening") {
+		t.Errorf("expected the running agent to be detected, got %v", err)
+	}
+}
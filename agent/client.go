@@ -0,0 +1,105 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+
+	okta "github.com/wearefair/okta-auth"
+)
+
+// A client of the agent listening on a Unix socket. Each request connects to
+// the agent, and answers its prompts with the client's Prompts if the user
+// needs to authenticate.
+type Client struct {
+	path    string
+	prompts Prompts
+}
+
+// Returns a client of the agent listening at the path.
+func NewClient(path string, prompts Prompts) *Client {
+	return &Client{path: path, prompts: prompts}
+}
+
+// Returns the agent's session.
+func (c *Client) Session(ctx context.Context) (*okta.Session, error) {
+	result, err := c.do(ctx, message{Type: typeSession})
+	if err != nil {
+		return nil, err
+	}
+	if result.Session == nil {
+		return nil, errors.New("agent: no session in the result")
+	}
+	return result.Session, nil
+}
+
+// Returns OIDC tokens for the agent's session. The request's SessionToken and
+// SessionId are ignored.
+func (c *Client) Tokens(ctx context.Context, request okta.TokenRequest) (*okta.Tokens, error) {
+	result, err := c.do(ctx, message{Type: typeTokens, TokenRequest: &request})
+	if err != nil {
+		return nil, err
+	}
+	if result.Tokens == nil {
+		return nil, errors.New("agent: no tokens in the result")
+	}
+	return result.Tokens, nil
+}
+
+// Returns a SAML assertion for the agent's session. The request's SessionToken
+// and SessionId are ignored.
+func (c *Client) SAMLAssertion(ctx context.Context, request okta.SAMLRequest) (string, error) {
+	result, err := c.do(ctx, message{Type: typeSAMLAssertion, AppURL: request.AppURL})
+	if err != nil {
+		return "", err
+	}
+	return result.Assertion, nil
+}
+
+// Sends the request, and answers the agent's prompts until it returns the result.
+func (c *Client) do(ctx context.Context, request message) (message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.path)
+	if err != nil {
+		return message{}, err
+	}
+	defer conn.Close()
+	// Closing the connection interrupts the exchange, and the agent stops
+	// authenticating
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	enc := json.NewEncoder(conn)
+	dec := json.NewDecoder(conn)
+	request.Version = protocolVersion
+	if err := enc.Encode(request); err != nil {
+		return message{}, c.connError(ctx, err)
+	}
+	for {
+		var m message
+		if err := dec.Decode(&m); err != nil {
+			return message{}, c.connError(ctx, err)
+		}
+		switch m.Type {
+		case typeResult:
+			return m, nil
+		case typeError:
+			return message{}, errors.New(m.Error)
+		case typeFactorResult, typeAuthenticationFinished:
+			notify(c.prompts, m)
+		default:
+			if err := enc.Encode(answer(ctx, c.prompts, m)); err != nil {
+				return message{}, c.connError(ctx, err)
+			}
+		}
+	}
+}
+
+// Returns the context's error if the connection failed because it was done.
+func (c *Client) connError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
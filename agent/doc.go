@@ -0,0 +1,32 @@
+// Shares an Okta session between processes, like ssh-agent shares keys.
+//
+// A Server is a long-lived process that holds the user's session, and hands
+// out the session, OIDC tokens and SAML assertions to clients connecting over a
+// Unix socket. The user verifies a factor once per session, instead of once
+// per tool. Session tokens are single use, so clients are given the session id
+// instead, which can be used as the SessionId of okta.TokenRequest and
+// okta.SAMLRequest.
+//
+// Only processes running as the same user as the agent may connect, which is
+// checked with the peer's credentials from the socket. Peer credentials are
+// supported on Linux and macOS, and connections are refused on other platforms.
+//
+// When the session has expired or been closed, the agent authenticates again,
+// forwarding the prompts to the client that made the request, so the user
+// answers them in that client's terminal:
+//
+//	listener, err := agent.Listen(path)
+//	if err != nil {
+//		return err
+//	}
+//	server, err := agent.NewServer(agent.Config{Client: okta.ClientConfig{OktaDomain: "example.okta.com"}})
+//	if err != nil {
+//		return err
+//	}
+//	return server.Serve(listener)
+//
+// And in the client:
+//
+//	client := agent.NewClient(path, term.New(os.Stdin, os.Stderr))
+//	tokens, err := client.Tokens(ctx, okta.TokenRequest{ClientId: "0oa1abcdefghijklmnop", RedirectURI: redirectURI})
+package agent
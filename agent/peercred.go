@@ -0,0 +1,16 @@
+package agent
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Returns the raw connection of a Unix socket, to read its peer's credentials.
+func rawConn(conn net.Conn) (syscall.RawConn, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("agent: peer credentials require a unix socket")
+	}
+	return unixConn.SyscallConn()
+}
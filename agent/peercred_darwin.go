@@ -0,0 +1,35 @@
+//go:build darwin
+
+package agent
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func peerCredentials(conn net.Conn) (Peer, error) {
+	raw, err := rawConn(conn)
+	if err != nil {
+		return Peer{}, err
+	}
+	var cred *unix.Xucred
+	var pid int
+	controlErr := raw.Control(func(fd uintptr) {
+		if cred, err = unix.GetsockoptXucred(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERCRED); err != nil {
+			return
+		}
+		pid, err = unix.GetsockoptInt(int(fd), unix.SOL_LOCAL, unix.LOCAL_PEERPID)
+	})
+	if controlErr != nil {
+		return Peer{}, controlErr
+	}
+	if err != nil {
+		return Peer{}, err
+	}
+	peer := Peer{Uid: int(cred.Uid), Pid: pid}
+	if cred.Ngroups > 0 {
+		peer.Gid = int(cred.Groups[0])
+	}
+	return peer, nil
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package agent
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func peerCredentials(conn net.Conn) (Peer, error) {
+	raw, err := rawConn(conn)
+	if err != nil {
+		return Peer{}, err
+	}
+	var cred *unix.Ucred
+	controlErr := raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if controlErr != nil {
+		return Peer{}, controlErr
+	}
+	if err != nil {
+		return Peer{}, err
+	}
+	return Peer{Uid: int(cred.Uid), Gid: int(cred.Gid), Pid: int(cred.Pid)}, nil
+}
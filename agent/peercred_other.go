@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package agent
+
+import (
+	"errors"
+	"net"
+)
+
+// Peer credentials aren't read on this platform, so every connection is refused
+// rather than trusting whoever can reach the socket.
+func peerCredentials(conn net.Conn) (Peer, error) {
+	return Peer{}, errors.New("agent: peer credentials aren't supported on this platform")
+}
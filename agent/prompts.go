@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// Prompts the agent forwards to the client, which the client answers.
+// term.Prompts implements it.
+//
+// If the Prompts also implement okta.Observer, they are notified of the
+// FactorResult and AuthenticationFinished events, for example to stop a spinner.
+type Prompts interface {
+	okta.Prompts
+
+	// Reads a line, such as the username, showing the prompt.
+	ReadLine(prompt string) (string, error)
+
+	// Reads a secret, such as the password, without echoing it.
+	ReadSecret(prompt string) (string, error)
+}
+
+// Prompts that are forwarded over the connection to the client, and the events
+// its prompts may observe. Fails every prompt once the connection fails.
+type remotePrompts struct {
+	okta.NopObserver
+
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+	err  error
+}
+
+// Sends the prompt and returns the client's reply.
+func (p *remotePrompts) ask(prompt message) (message, error) {
+	if err := p.send(prompt); err != nil {
+		return message{}, err
+	}
+	var reply message
+	if err := p.dec.Decode(&reply); err != nil {
+		p.err = err
+		return message{}, err
+	}
+	if reply.Type != typeReply {
+		p.err = fmt.Errorf("agent: expected a reply to %s, got %q", prompt.Type, reply.Type)
+		return message{}, p.err
+	}
+	if reply.Error != "" {
+		return reply, errors.New(reply.Error)
+	}
+	return reply, nil
+}
+
+func (p *remotePrompts) send(m message) error {
+	if p.err != nil {
+		return p.err
+	}
+	if err := p.enc.Encode(m); err != nil {
+		p.err = err
+	}
+	return p.err
+}
+
+func (p *remotePrompts) CheckU2FPresence(request okta.VerifyU2FRequest) bool {
+	reply, err := p.ask(message{Type: typeCheckU2FPresence, U2FRequest: &request})
+	return err == nil && reply.Present
+}
+
+func (p *remotePrompts) ChooseFactor(choices []factors.Factor) (factors.Factor, error) {
+	reply, err := p.ask(message{Type: typeChooseFactor, Factors: choices})
+	if err != nil {
+		return factors.Factor{}, err
+	}
+	if reply.Factor == nil {
+		return factors.Factor{}, errors.New("agent: no factor was chosen")
+	}
+	return *reply.Factor, nil
+}
+
+func (p *remotePrompts) PresentUserError(msg string) {
+	p.ask(message{Type: typePresentUserError, Text: msg})
+}
+
+// The client is given the context's deadline, and the agent stops waiting for
+// its reply when the context is done.
+func (p *remotePrompts) VerifyU2F(ctx context.Context, request okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+	prompt := message{Type: typeVerifyU2F, U2FRequest: &request}
+	if deadline, ok := ctx.Deadline(); ok {
+		prompt.Deadline = &deadline
+		p.conn.SetReadDeadline(deadline)
+		defer p.conn.SetReadDeadline(time.Time{})
+	}
+	reply, err := p.ask(prompt)
+	if err != nil {
+		return okta.VerifyU2FResponse{}, err
+	}
+	if reply.U2FResponse == nil {
+		return okta.VerifyU2FResponse{}, errors.New("agent: no U2F response")
+	}
+	return *reply.U2FResponse, nil
+}
+
+func (p *remotePrompts) VerifyCode(factor factors.Factor) (string, error) {
+	reply, err := p.ask(message{Type: typeVerifyCode, Factor: &factor})
+	return reply.Value, err
+}
+
+// Forwarded to clients whose prompts implement okta.ContextPrompts.
+func (p *remotePrompts) PresentContext(promptContext okta.PromptContext) {
+	p.ask(message{Type: typePresentContext, Context: &promptContext})
+}
+
+func (p *remotePrompts) VerifyPush() {
+	p.ask(message{Type: typeVerifyPush})
+}
+
+func (p *remotePrompts) ReadLine(prompt string) (string, error) {
+	reply, err := p.ask(message{Type: typeReadLine, Text: prompt})
+	return reply.Value, err
+}
+
+func (p *remotePrompts) ReadSecret(prompt string) (string, error) {
+	reply, err := p.ask(message{Type: typeReadSecret, Text: prompt})
+	return reply.Value, err
+}
+
+func (p *remotePrompts) FactorResult(event okta.FactorResultEvent) {
+	p.send(message{Type: typeFactorResult, Factor: &event.Factor, Result: string(event.Result), Duration: event.Duration})
+}
+
+func (p *remotePrompts) AuthenticationFinished(event okta.AuthenticationFinishedEvent) {
+	m := message{Type: typeAuthenticationFinished, Text: event.Username, Duration: event.Duration}
+	if event.Err != nil {
+		m.Error = event.Err.Error()
+	}
+	p.send(m)
+}
+
+// Answers a prompt from the agent with the client's prompts.
+func answer(ctx context.Context, prompts Prompts, prompt message) message {
+	reply := message{Type: typeReply}
+	var err error
+	switch prompt.Type {
+	case typeCheckU2FPresence:
+		if prompt.U2FRequest != nil {
+			reply.Present = prompts.CheckU2FPresence(*prompt.U2FRequest)
+		}
+	case typeChooseFactor:
+		var factor factors.Factor
+		if factor, err = prompts.ChooseFactor(prompt.Factors); err == nil {
+			reply.Factor = &factor
+		}
+	case typePresentUserError:
+		prompts.PresentUserError(prompt.Text)
+	case typePresentContext:
+		if contextPrompts, ok := prompts.(okta.ContextPrompts); ok && prompt.Context != nil {
+			contextPrompts.PresentContext(*prompt.Context)
+		}
+	case typeVerifyU2F:
+		if prompt.U2FRequest == nil {
+			err = errors.New("agent: no U2F request")
+			break
+		}
+		if prompt.Deadline != nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, *prompt.Deadline)
+			defer cancel()
+		}
+		var response okta.VerifyU2FResponse
+		if response, err = prompts.VerifyU2F(ctx, *prompt.U2FRequest); err == nil {
+			reply.U2FResponse = &response
+		}
+	case typeVerifyCode:
+		if prompt.Factor == nil {
+			err = errors.New("agent: no factor to verify")
+			break
+		}
+		reply.Value, err = prompts.VerifyCode(*prompt.Factor)
+	case typeVerifyPush:
+		prompts.VerifyPush()
+	case typeReadLine:
+		reply.Value, err = prompts.ReadLine(prompt.Text)
+	case typeReadSecret:
+		reply.Value, err = prompts.ReadSecret(prompt.Text)
+	default:
+		err = fmt.Errorf("agent: unsupported prompt %q", prompt.Type)
+	}
+	if err != nil {
+		reply.Error = err.Error()
+	}
+	return reply
+}
+
+// Passes an event from the agent to the client's prompts, if they observe it.
+func notify(prompts Prompts, event message) {
+	observer, ok := prompts.(okta.Observer)
+	if !ok {
+		return
+	}
+	switch event.Type {
+	case typeFactorResult:
+		e := okta.FactorResultEvent{Result: api.FactorResult(event.Result), Duration: event.Duration}
+		if event.Factor != nil {
+			e.Factor = *event.Factor
+		}
+		observer.FactorResult(e)
+	case typeAuthenticationFinished:
+		e := okta.AuthenticationFinishedEvent{Username: event.Text, Duration: event.Duration}
+		if event.Error != "" {
+			e.Err = errors.New(event.Error)
+		}
+		observer.AuthenticationFinished(e)
+	}
+}
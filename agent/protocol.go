@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// The version of the protocol, sent with each request so the agent can reject
+// clients it doesn't understand.
+const protocolVersion = 1
+
+// Message types. The client sends a request, then the agent sends prompts while
+// authenticating, which the client replies to, and events, which it doesn't.
+// The agent ends the exchange with a result or an error.
+const (
+	typeSession       = "session"
+	typeTokens        = "tokens"
+	typeSAMLAssertion = "samlAssertion"
+
+	typeCheckU2FPresence = "checkU2FPresence"
+	typeChooseFactor     = "chooseFactor"
+	typePresentUserError = "presentUserError"
+	typePresentContext   = "presentContext"
+	typeVerifyU2F        = "verifyU2F"
+	typeVerifyCode       = "verifyCode"
+	typeVerifyPush       = "verifyPush"
+	typeReadLine         = "readLine"
+	typeReadSecret       = "readSecret"
+
+	typeFactorResult           = "factorResult"
+	typeAuthenticationFinished = "authenticationFinished"
+
+	typeReply  = "reply"
+	typeResult = "result"
+	typeError  = "error"
+)
+
+// A message on the connection, written as a line of JSON. Only the fields used
+// by its type are set.
+type message struct {
+	Type    string `json:"type"`
+	Version int    `json:"version,omitempty"`
+
+	// Requests
+	TokenRequest *okta.TokenRequest `json:"tokenRequest,omitempty"`
+	AppURL       string             `json:"appURL,omitempty"`
+
+	// Prompts and events
+	Text       string                 `json:"text,omitempty"`
+	Factors    []factors.Factor       `json:"factors,omitempty"`
+	Factor     *factors.Factor        `json:"factor,omitempty"`
+	U2FRequest *okta.VerifyU2FRequest `json:"u2fRequest,omitempty"`
+	Deadline   *time.Time             `json:"deadline,omitempty"`
+	Result     string                 `json:"result,omitempty"`
+	Duration   time.Duration          `json:"duration,omitempty"`
+	Context    *okta.PromptContext    `json:"context,omitempty"`
+
+	// Replies
+	Value       string                  `json:"value,omitempty"`
+	Present     bool                    `json:"present,omitempty"`
+	U2FResponse *okta.VerifyU2FResponse `json:"u2fResponse,omitempty"`
+
+	// Results
+	Session   *okta.Session `json:"session,omitempty"`
+	Tokens    *okta.Tokens  `json:"tokens,omitempty"`
+	Assertion string        `json:"assertion,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
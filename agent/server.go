@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	okta "github.com/wearefair/okta-auth"
+)
+
+// Returned to clients that aren't allowed to use the agent.
+var errPermissionDenied = errors.New("agent: permission denied")
+
+// The credentials of the process on the other end of a connection.
+type Peer struct {
+	Uid int
+	Gid int
+	// Zero if the platform doesn't report it.
+	Pid int
+}
+
+// Configures a Server.
+type Config struct {
+	// Configures the clients the agent uses. Prompts is ignored, as prompts are
+	// forwarded to the client that made the request.
+	Client okta.ClientConfig
+
+	// The user's credentials. The client that made the request is asked for any
+	// that are blank when the agent authenticates.
+	Username string
+	Password string
+
+	// Returns whether the peer may use the agent. Defaults to only allowing
+	// processes running as the same user as the agent.
+	Authorize func(Peer) bool
+}
+
+// An agent that holds a session, and serves clients connecting to it.
+type Server struct {
+	conf Config
+
+	// Held while the session is checked or created, so one client is prompted
+	// at a time and the others wait for its session.
+	mu      sync.Mutex
+	session *okta.Session
+}
+
+// Returns a server for the config. The user isn't authenticated until a client
+// first makes a request.
+func NewServer(conf Config) (*Server, error) {
+	if conf.Client.OktaDomain == "" {
+		return nil, fmt.Errorf("Config.Client.OktaDomain can't be blank")
+	}
+	if conf.Authorize == nil {
+		uid := os.Getuid()
+		conf.Authorize = func(peer Peer) bool { return peer.Uid == uid }
+	}
+	return &Server{conf: conf}, nil
+}
+
+// Listens on a Unix socket at the path, which only the user can connect to.
+// Its directory is created if it doesn't exist, and a socket left behind by an
+// agent that exited is replaced.
+func Listen(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("agent: an agent is already listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serves clients connecting to the listener, until it is closed.
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Handles the request on the connection, forwarding prompts to the client until
+// there is a result.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	prompts := &remotePrompts{conn: conn, enc: json.NewEncoder(conn), dec: json.NewDecoder(conn)}
+
+	peer, err := peerCredentials(conn)
+	if err != nil || !s.conf.Authorize(peer) {
+		prompts.send(message{Type: typeError, Error: errPermissionDenied.Error()})
+		return
+	}
+
+	var request message
+	if err := prompts.dec.Decode(&request); err != nil {
+		return
+	}
+	result, err := s.handle(context.Background(), prompts, request)
+	if err != nil {
+		prompts.send(message{Type: typeError, Error: err.Error()})
+		return
+	}
+	result.Type = typeResult
+	prompts.send(result)
+}
+
+func (s *Server) handle(ctx context.Context, prompts *remotePrompts, request message) (message, error) {
+	if request.Version != protocolVersion {
+		return message{}, fmt.Errorf("agent: unsupported protocol version %d, expected %d", request.Version, protocolVersion)
+	}
+	switch request.Type {
+	case typeSession, typeSAMLAssertion:
+	case typeTokens:
+		if request.TokenRequest == nil {
+			return message{}, errors.New("agent: a token request is required")
+		}
+	default:
+		return message{}, fmt.Errorf("agent: unknown request %q", request.Type)
+	}
+
+	conf := s.conf.Client
+	conf.Prompts = prompts
+	if conf.Observer == nil {
+		conf.Observer = prompts
+	} else {
+		conf.Observer = okta.MultiObserver(conf.Observer, prompts)
+	}
+	client, err := okta.New(conf)
+	if err != nil {
+		return message{}, err
+	}
+	session, err := s.currentSession(ctx, client, prompts)
+	if err != nil {
+		return message{}, err
+	}
+
+	switch request.Type {
+	case typeTokens:
+		tokenRequest := *request.TokenRequest
+		tokenRequest.SessionToken = ""
+		tokenRequest.SessionId = session.Id
+		tokens, err := client.Tokens(ctx, tokenRequest)
+		return message{Tokens: tokens}, err
+	case typeSAMLAssertion:
+		assertion, err := client.SAMLAssertion(ctx, okta.SAMLRequest{AppURL: request.AppURL, SessionId: session.Id})
+		return message{Assertion: assertion}, err
+	default:
+		return message{Session: session}, nil
+	}
+}
+
+// Returns the agent's session if Okta still has it. Otherwise the user
+// authenticates with the client's prompts, and a new session is created.
+func (s *Server) currentSession(ctx context.Context, client *okta.OktaClient, prompts *remotePrompts) (*okta.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.session != nil {
+		session, err := client.GetSession(ctx, s.session.Id)
+		if err != okta.ErrSessionNotFound {
+			if err == nil {
+				s.session = session
+			}
+			return session, err
+		}
+		s.session = nil
+	}
+
+	username := s.conf.Username
+	if username == "" {
+		var err error
+		if username, err = prompts.ReadLine("Username: "); err != nil {
+			return nil, err
+		}
+		if username == "" {
+			return nil, errors.New("agent: a username is required")
+		}
+	}
+	password := s.conf.Password
+	if password == "" {
+		var err error
+		if password, err = prompts.ReadSecret(fmt.Sprintf("Password for %s: ", username)); err != nil {
+			return nil, err
+		}
+	}
+
+	sessionToken, err := client.AuthenticateContext(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.CreateSession(ctx, sessionToken)
+	if err != nil {
+		return nil, err
+	}
+	s.session = session
+	return session, nil
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"encoding/json"
+	"reflect"
+	"time"
 
 	"github.com/wearefair/okta-auth/factors"
 )
@@ -12,7 +14,11 @@ type Factors []Factor
 func (f Factors) SupportedFactors() Factors {
 	supported := Factors{}
 	for i, factor := range f {
-		if indexOfFactorType(factor.FactorType) != -1 {
+		if indexOfFactorType(factor.FactorType) == -1 {
+			continue
+		}
+		// Web factors are only supported for Duo
+		if factor.FactorType != factors.FactorTypeWeb || factor.Provider == ProviderDuo {
 			supported = append(supported, f[i])
 		}
 	}
@@ -21,113 +27,198 @@ func (f Factors) SupportedFactors() Factors {
 
 // https://developer.okta.com/docs/api/resources/authn#factor-object
 type Factor struct {
-	Id         string
-	FactorType factors.FactorType
-	Provider   string
+	Id          string
+	FactorType  factors.FactorType
+	Provider    string
+	VendorName  string
+	Status      factors.FactorStatus
+	Enrollment  string
+	Created     time.Time
+	LastUpdated time.Time
+	// Nil if the factor has no profile.
 	// https://developer.okta.com/docs/api/resources/factors#factor-profile-object
-	Profile  interface{}
+	Profile  FactorProfile
 	Links    Links
 	Embedded FactorEmbedded
+	Unknown  Unknown
 }
 
-// Used for unmarshalin
-type factorHelper struct {
-	Id         string
-	FactorType factors.FactorType
-	Provider   string
-	Profile    json.RawMessage
-	Links      Links          `json:"_links,omitempty"`
-	Embedded   FactorEmbedded `json:"_embedded,omitempty"`
+// The JSON representation of a Factor.
+type factorJSON struct {
+	Id          string               `json:"id"`
+	FactorType  factors.FactorType   `json:"factorType"`
+	Provider    string               `json:"provider"`
+	VendorName  string               `json:"vendorName,omitempty"`
+	Status      factors.FactorStatus `json:"status,omitempty"`
+	Enrollment  string               `json:"enrollment,omitempty"`
+	Created     *time.Time           `json:"created,omitempty"`
+	LastUpdated *time.Time           `json:"lastUpdated,omitempty"`
+	Profile     json.RawMessage      `json:"profile,omitempty"`
+	Links       *Links               `json:"_links,omitempty"`
+	Embedded    *FactorEmbedded      `json:"_embedded,omitempty"`
 }
 
 func (f *Factor) UnmarshalJSON(data []byte) error {
-	factor := factorHelper{}
-	err := json.Unmarshal(data, &factor)
+	factor := factorJSON{}
+	unknown, err := unmarshalKnown(data, &factor)
 	if err != nil {
 		return err
 	}
-	f.Id = factor.Id
-	f.FactorType = factor.FactorType
-	f.Provider = factor.Provider
-	f.Links = factor.Links
-	f.Embedded = factor.Embedded
+	*f = Factor{
+		Id:         factor.Id,
+		FactorType: factor.FactorType,
+		Provider:   factor.Provider,
+		VendorName: factor.VendorName,
+		Status:     factor.Status,
+		Enrollment: factor.Enrollment,
+		Unknown:    unknown,
+	}
+	if factor.Links != nil {
+		f.Links = *factor.Links
+	}
+	if factor.Embedded != nil {
+		f.Embedded = *factor.Embedded
+	}
+	if factor.Created != nil {
+		f.Created = *factor.Created
+	}
+	if factor.LastUpdated != nil {
+		f.LastUpdated = *factor.LastUpdated
+	}
 
 	// Bail early if the profile is empty.
 	// This can be the case when the user has not enrolled any factors.
-	if len(factor.Profile) == 0 {
+	if len(factor.Profile) == 0 || string(factor.Profile) == "null" {
 		return nil
 	}
+	f.Profile, err = unmarshalFactorProfile(f.FactorType, factor.Profile)
+	return err
+}
 
-	switch f.FactorType {
-	case factors.FactorTypeQuestion:
-		profile := FactorProfileQuestion{}
-		err = json.Unmarshal([]byte(factor.Profile), &profile)
-		f.Profile = profile
-	case factors.FactorTypeSMS:
-		profile := FactorProfileSMS{}
-		err = json.Unmarshal([]byte(factor.Profile), &profile)
-		f.Profile = profile
-	case factors.FactorTypeCall:
-		profile := FactorProfileCall{}
-		err = json.Unmarshal([]byte(factor.Profile), &profile)
-		f.Profile = profile
-	case factors.FactorTypeToken, factors.FactorTypeTokenSoftwareTOTP, factors.FactorTypeTokenHardware:
-		profile := FactorProfileToken{}
-		err = json.Unmarshal([]byte(factor.Profile), &profile)
-		f.Profile = profile
-	case factors.FactorTypeU2F:
-		profile := FactorProfileU2F{}
-		err = json.Unmarshal([]byte(factor.Profile), &profile)
-		f.Profile = profile
-	case factors.FactorTypeWebAuthN:
-		profile := FactorProfileWebAuthN{}
-		err = json.Unmarshal([]byte(factor.Profile), &profile)
-		f.Profile = profile
-	default:
-		// Ignore any profile contents we don't understand
-		return nil
+func (f Factor) MarshalJSON() ([]byte, error) {
+	factor := factorJSON{
+		Id:         f.Id,
+		FactorType: f.FactorType,
+		Provider:   f.Provider,
+		VendorName: f.VendorName,
+		Status:     f.Status,
+		Enrollment: f.Enrollment,
+	}
+	if !reflect.ValueOf(f.Links).IsZero() {
+		factor.Links = &f.Links
+	}
+	if !reflect.ValueOf(f.Embedded).IsZero() {
+		factor.Embedded = &f.Embedded
+	}
+	if !f.Created.IsZero() {
+		factor.Created = &f.Created
+	}
+	if !f.LastUpdated.IsZero() {
+		factor.LastUpdated = &f.LastUpdated
+	}
+	if f.Profile != nil {
+		profile, err := json.Marshal(f.Profile)
+		if err != nil {
+			return nil, err
+		}
+		factor.Profile = profile
 	}
+	return marshalKnown(factor, f.Unknown)
+}
 
-	return err
+// Returns the factor as it is shown to Prompts.
+func (f Factor) Public() factors.Factor {
+	re := factors.Factor{
+		Id:          f.Id,
+		FactorType:  f.FactorType,
+		Provider:    f.Provider,
+		VendorName:  f.VendorName,
+		Status:      f.Status,
+		Enrollment:  f.Enrollment,
+		Created:     f.Created,
+		LastUpdated: f.LastUpdated,
+	}
+	if f.Profile != nil {
+		f.Profile.setPublicProfile(&re)
+	}
+	return re
 }
 
 type FactorEmbedded struct {
-	Challenge Challenge
+	Challenge Challenge `json:"challenge"`
+	// The Duo Security prompt of a DUO web factor's challenge.
+	Verification *Verification `json:"verification,omitempty"`
+	Unknown      Unknown       `json:"-"`
+}
+
+func (e *FactorEmbedded) UnmarshalJSON(data []byte) error {
+	type embedded FactorEmbedded
+	unknown, err := unmarshalKnown(data, (*embedded)(e))
+	e.Unknown = unknown
+	return err
+}
+
+// The challenge is left out when it is blank.
+func (e FactorEmbedded) MarshalJSON() ([]byte, error) {
+	type embedded FactorEmbedded
+	v := struct {
+		embedded
+		Challenge *Challenge `json:"challenge,omitempty"`
+	}{embedded: embedded(e)}
+	if !reflect.ValueOf(e.Challenge).IsZero() {
+		v.Challenge = &e.Challenge
+	}
+	return marshalKnown(v, e.Unknown)
 }
 
 type Challenge struct {
-	Challenge      string
-	Nonce          string
-	TimeoutSeconds int
+	Challenge      string  `json:"challenge,omitempty"`
+	Nonce          string  `json:"nonce,omitempty"`
+	TimeoutSeconds int     `json:"timeoutSeconds,omitempty"`
+	Unknown        Unknown `json:"-"`
 }
 
-type FactorProfileQuestion struct {
-	Question     string `json:"question,omitempty"`
-	QuestionText string `json:"questionText,omitempty"`
-	Answer       string `json:"answer,omitempty"`
+func (c *Challenge) UnmarshalJSON(data []byte) error {
+	type challenge Challenge
+	unknown, err := unmarshalKnown(data, (*challenge)(c))
+	c.Unknown = unknown
+	return err
 }
 
-type FactorProfileSMS struct {
-	PhoneNumber string `json:"phoneNumber,omitempty"`
+func (c Challenge) MarshalJSON() ([]byte, error) {
+	type challenge Challenge
+	return marshalKnown(challenge(c), c.Unknown)
 }
 
-type FactorProfileCall struct {
-	PhoneNumber    string `json:"phoneNumber,omitempty"`
-	PhoneExtension string `json:"phoneExtension,omitempty"`
+// The Duo Security prompt the user verifies a DUO web factor with. Once verified,
+// Duo's signed response is posted to the complete link.
+type Verification struct {
+	// The Duo API host, ex: api-1234abcd.duosecurity.com
+	Host string `json:"host"`
+	// Signs the request to Duo, in the form TX|...:APP|...
+	Signature    string            `json:"signature"`
+	FactorResult FactorResult      `json:"factorResult,omitempty"`
+	Links        VerificationLinks `json:"_links"`
+	Unknown      Unknown           `json:"-"`
 }
 
-type FactorProfileToken struct {
-	CredentialId string `json:"credentialId,omitempty"`
+func (v *Verification) UnmarshalJSON(data []byte) error {
+	type verification Verification
+	unknown, err := unmarshalKnown(data, (*verification)(v))
+	v.Unknown = unknown
+	return err
 }
 
-type FactorProfileU2F struct {
-	CredentialId string `json:"credentialId,omitempty"`
-	AppId        string `json:"appId,omitempty"`
-	Version      string `json:"version,omitempty"`
+func (v Verification) MarshalJSON() ([]byte, error) {
+	type verification Verification
+	return marshalKnown(verification(v), v.Unknown)
 }
 
-type FactorProfileWebAuthN struct {
-	CredentialId string `json:"credentialId,omitempty"`
+type VerificationLinks struct {
+	// Where Duo's signed response is posted.
+	Complete *Link `json:"complete,omitempty"`
+	// Duo's JavaScript SDK, which shows the prompt in a browser.
+	Script *Link `json:"script,omitempty"`
 }
 
 type FactorVerify struct {
@@ -176,4 +267,8 @@ var knownFactors = []factors.FactorType{
 	factors.FactorTypeSMS,
 	factors.FactorTypeCall,
 	factors.FactorTypeQuestion,
+	factors.FactorTypeWeb,
 }
+
+// The provider of Duo Security web factors.
+const ProviderDuo = "DUO"
@@ -1,9 +1,13 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/quick"
+	"time"
 
 	"github.com/wearefair/okta-auth/factors"
 )
@@ -19,12 +23,14 @@ func TestFactorUnmarshalJSON(t *testing.T) {
 				Id:         "uftpep6vfeujtcuPc1t6",
 				FactorType: factors.FactorTypeTokenSoftwareTOTP,
 				Provider:   "GOOGLE",
+				VendorName: "GOOGLE",
 				Profile: FactorProfileToken{
 					CredentialId: "first@example.com",
 				},
 				Links: Links{
-					Verify: Link{
-						HREF: "https://example.okta.com/api/v1/authn/factors/uftpep6vfeujtcuPc1t6/verify",
+					Verify: &Link{
+						HREF:  "https://example.okta.com/api/v1/authn/factors/uftpep6vfeujtcuPc1t6/verify",
+						Hints: LinkHints{Allow: []string{"POST"}},
 					},
 				},
 			},
@@ -35,14 +41,16 @@ func TestFactorUnmarshalJSON(t *testing.T) {
 				Id:         "fuf59d1ohqJZyOelX1t7",
 				FactorType: factors.FactorTypeU2F,
 				Provider:   "FIDO",
+				VendorName: "FIDO",
 				Profile: FactorProfileU2F{
 					CredentialId: "s94CdJnUd148p95PNq7AaY2Dv1QFrLJ12Vpkno-Q7WalmBTtB5TMnzDNL_yX84Ay49qnEiUXtSx0KK5I60ht2g",
 					AppId:        "https://example.okta.com",
 					Version:      "U2F_V2",
 				},
 				Links: Links{
-					Verify: Link{
-						HREF: "https://example.okta.com/api/v1/authn/factors/fuf59d1ohqJZyOelX1t7/verify",
+					Verify: &Link{
+						HREF:  "https://example.okta.com/api/v1/authn/factors/fuf59d1ohqJZyOelX1t7/verify",
+						Hints: LinkHints{Allow: []string{"POST"}},
 					},
 				},
 			},
@@ -50,15 +58,21 @@ func TestFactorUnmarshalJSON(t *testing.T) {
 		{
 			input: sampleWebAuthNFactor,
 			expected: Factor{
-				Id:         "fufb6rh45mUxtEJz61t7",
-				FactorType: factors.FactorTypeWebAuthN,
-				Provider:   "FIDO",
+				Id:          "fufb6rh45mUxtEJz61t7",
+				FactorType:  factors.FactorTypeWebAuthN,
+				Provider:    "FIDO",
+				VendorName:  "FIDO",
+				Status:      factors.FactorStatusActive,
+				Created:     time.Date(2018, 6, 21, 17, 14, 17, 0, time.UTC),
+				LastUpdated: time.Date(2018, 6, 21, 17, 14, 41, 0, time.UTC),
 				Profile: FactorProfileWebAuthN{
-					CredentialId: "s94CdJnUd148p95PNq7AaY2Dv1QFrLJ12Vpkno-Q7WalmBTtB5TMnzDNL_yX84Ay49qnEiUXtSx0KK5I60ht2g",
+					CredentialId:      "s94CdJnUd148p95PNq7AaY2Dv1QFrLJ12Vpkno-Q7WalmBTtB5TMnzDNL_yX84Ay49qnEiUXtSx0KK5I60ht2g",
+					AuthenticatorName: "YubiKey 5 NFC",
 				},
 				Links: Links{
-					Verify: Link{
-						HREF: "https://example.okta.com/api/v1/authn/factors/fufb6rh45mUxtEJz61t7/verify",
+					Verify: &Link{
+						HREF:  "https://example.okta.com/api/v1/authn/factors/fufb6rh45mUxtEJz61t7/verify",
+						Hints: LinkHints{Allow: []string{"POST"}},
 					},
 				},
 			},
@@ -130,8 +144,12 @@ var sampleWebAuthNFactor = `
   "factorType": "webauthn",
   "provider": "FIDO",
   "vendorName": "FIDO",
+  "status": "ACTIVE",
+  "created": "2018-06-21T17:14:17.000Z",
+  "lastUpdated": "2018-06-21T17:14:41.000Z",
   "profile": {
-    "credentialId": "s94CdJnUd148p95PNq7AaY2Dv1QFrLJ12Vpkno-Q7WalmBTtB5TMnzDNL_yX84Ay49qnEiUXtSx0KK5I60ht2g"
+    "credentialId": "s94CdJnUd148p95PNq7AaY2Dv1QFrLJ12Vpkno-Q7WalmBTtB5TMnzDNL_yX84Ay49qnEiUXtSx0KK5I60ht2g",
+    "authenticatorName": "YubiKey 5 NFC"
   },
   "_links": {
     "verify": {
@@ -145,3 +163,168 @@ var sampleWebAuthNFactor = `
   }
 }
 `
+
+var sampleUnknownFactor = `
+{
+  "id": "dsf2kd4ycSIfZkoeb0g4",
+  "factorType": "web",
+  "provider": "DUO",
+  "vendorName": "DUO",
+  "profile": {
+    "credentialId": "first@example.com",
+    "host": "api-abc123.duosecurity.com"
+  }
+}
+`
+
+func TestFactorUnknownProfile(t *testing.T) {
+	factor := Factor{}
+	if err := json.Unmarshal([]byte(sampleUnknownFactor), &factor); err != nil {
+		t.Fatal(err)
+	}
+	profile, ok := factor.Profile.(UnknownProfile)
+	if !ok {
+		t.Fatalf("expected an UnknownProfile, got %#+v", factor.Profile)
+	}
+	var raw map[string]string
+	if err := json.Unmarshal(profile.Raw, &raw); err != nil || raw["host"] != "api-abc123.duosecurity.com" {
+		t.Errorf("expected the raw profile, got %s, %v", profile.Raw, err)
+	}
+
+	public := factor.Public()
+	if public.Id != factor.Id || public.VendorName != "DUO" {
+		t.Errorf("unexpected public factor %#+v", public)
+	}
+}
+
+func TestFactorMarshalJSON(t *testing.T) {
+	samples := []string{sampleTokenFactor, sampleU2FFactor, sampleWebAuthNFactor, sampleUnknownFactor}
+	for i, sample := range samples {
+		expected := Factor{}
+		if err := json.Unmarshal([]byte(sample), &expected); err != nil {
+			t.Fatalf("%0d: %s", i, err)
+		}
+		b, err := json.Marshal(expected)
+		if err != nil {
+			t.Fatalf("%0d: %s", i, err)
+		}
+		actual := Factor{}
+		if err := json.Unmarshal(b, &actual); err != nil {
+			t.Fatalf("%0d: %s", i, err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%0d: Expected:\n    %#+v\nActual:\n    %#+v\n", i, expected, actual)
+		}
+	}
+
+	t.Run("uses Okta's field names", func(t *testing.T) {
+		b, err := json.Marshal(Factor{Id: "sms1", FactorType: factors.FactorTypeSMS, Provider: "OKTA", Profile: FactorProfileSMS{PhoneNumber: "+1 XXX-XXX-5555"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, field := range []string{`"id":"sms1"`, `"factorType":"sms"`, `"profile":{"phoneNumber":"+1 XXX-XXX-5555"}`} {
+			if !strings.Contains(string(b), field) {
+				t.Errorf("expected %s in %s", field, b)
+			}
+		}
+		if strings.Contains(string(b), "created") {
+			t.Errorf("expected zero times to be omitted, got %s", b)
+		}
+	})
+}
+
+// Anything that decodes can be shown to Prompts, and encodes to a factor that
+// decodes the same.
+func FuzzFactor(f *testing.F) {
+	for _, sample := range []string{sampleTokenFactor, sampleU2FFactor, sampleWebAuthNFactor, sampleUnknownFactor} {
+		f.Add([]byte(sample))
+	}
+	f.Add([]byte(`{"factorType":"u2f"}`))
+	f.Add([]byte(`{"factorType":"webauthn","profile":null}`))
+	f.Add([]byte(`{"factorType":"sms","profile":{"phoneNumber":5555}}`))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		factor := Factor{}
+		if err := json.Unmarshal(input, &factor); err != nil {
+			return
+		}
+		factor.Public()
+		first, err := json.Marshal(factor)
+		if err != nil {
+			t.Fatalf("decoded %q, but couldn't encode it: %v", input, err)
+		}
+		again := Factor{}
+		if err := json.Unmarshal(first, &again); err != nil {
+			t.Fatalf("couldn't decode %q, encoded from %q: %v", first, input, err)
+		}
+		second, err := json.Marshal(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("encoding isn't stable:\n%s\n%s", first, second)
+		}
+	})
+}
+
+// Every kind of factor decodes to what was encoded.
+func TestFactorRoundTrip(t *testing.T) {
+	roundTrip := func(kind uint8, id, provider, a, b, c string, created uint32, href string) bool {
+		factor := Factor{
+			Id:         id,
+			Provider:   provider,
+			VendorName: provider,
+			Status:     factors.FactorStatusActive,
+			Created:    time.Unix(int64(created), 0).UTC(),
+			Links:      Links{Verify: &Link{HREF: href, Hints: LinkHints{Allow: []string{"POST"}}}},
+		}
+		switch kind % 8 {
+		case 0:
+			factor.FactorType = factors.FactorTypeSMS
+			factor.Profile = FactorProfileSMS{PhoneNumber: a}
+		case 1:
+			factor.FactorType = factors.FactorTypeCall
+			factor.Profile = FactorProfileCall{PhoneNumber: a, PhoneExtension: b}
+		case 2:
+			factor.FactorType = factors.FactorTypeQuestion
+			factor.Profile = FactorProfileQuestion{Question: a, QuestionText: b, Answer: c}
+		case 3:
+			factor.FactorType = factors.FactorTypeTokenSoftwareTOTP
+			factor.Profile = FactorProfileToken{CredentialId: a}
+		case 4:
+			factor.FactorType = factors.FactorTypeU2F
+			factor.Profile = FactorProfileU2F{CredentialId: a, AppId: b, Version: c}
+			factor.Embedded = FactorEmbedded{Challenge: Challenge{Nonce: b, TimeoutSeconds: int(created % 60)}}
+		case 5:
+			factor.FactorType = factors.FactorTypeWebAuthN
+			factor.Profile = FactorProfileWebAuthN{CredentialId: a, AuthenticatorName: b}
+			factor.Embedded = FactorEmbedded{Challenge: Challenge{Challenge: c}}
+		case 6:
+			factor.FactorType = factors.FactorTypePush
+		default:
+			factor.FactorType = factors.FactorType("web")
+			raw, _ := json.Marshal(map[string]string{"host": a, "signature": b})
+			factor.Profile = UnknownProfile{Raw: raw}
+			factor.Unknown = Unknown{"mfaStateTokenId": json.RawMessage(`"aid1"`)}
+		}
+
+		data, err := json.Marshal(factor)
+		if err != nil {
+			t.Log(err)
+			return false
+		}
+		actual := Factor{}
+		if err := json.Unmarshal(data, &actual); err != nil {
+			t.Log(err)
+			return false
+		}
+		if !reflect.DeepEqual(actual, factor) {
+			t.Logf("Expected:\n    %#+v\nActual:\n    %#+v\n", factor, actual)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Error(err)
+	}
+}
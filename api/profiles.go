@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// The profile of a factor, which depends on its type. Implemented only by the
+// FactorProfile* types of this package, and UnknownProfile for factor types it
+// doesn't model.
+type FactorProfile interface {
+	// Sets the profile of the public factor. Each profile must be mapped, so
+	// adding a profile without its public mapping doesn't compile.
+	setPublicProfile(factor *factors.Factor)
+}
+
+// Returns the profile for the factor type, or an UnknownProfile holding the raw
+// JSON if the type isn't modeled.
+func unmarshalFactorProfile(factorType factors.FactorType, data json.RawMessage) (FactorProfile, error) {
+	var profile FactorProfile
+	var err error
+	switch factorType {
+	case factors.FactorTypeQuestion:
+		p := FactorProfileQuestion{}
+		err = json.Unmarshal(data, &p)
+		profile = p
+	case factors.FactorTypeSMS:
+		p := FactorProfileSMS{}
+		err = json.Unmarshal(data, &p)
+		profile = p
+	case factors.FactorTypeCall:
+		p := FactorProfileCall{}
+		err = json.Unmarshal(data, &p)
+		profile = p
+	case factors.FactorTypeToken, factors.FactorTypeTokenSoftwareTOTP, factors.FactorTypeTokenHardware:
+		p := FactorProfileToken{}
+		err = json.Unmarshal(data, &p)
+		profile = p
+	case factors.FactorTypeU2F:
+		p := FactorProfileU2F{}
+		err = json.Unmarshal(data, &p)
+		profile = p
+	case factors.FactorTypeWebAuthN:
+		p := FactorProfileWebAuthN{}
+		err = json.Unmarshal(data, &p)
+		profile = p
+	default:
+		// Compacted, as it is when marshaled, so the factor round trips
+		raw := &bytes.Buffer{}
+		err = json.Compact(raw, data)
+		profile = UnknownProfile{Raw: raw.Bytes()}
+	}
+	return profile, err
+}
+
+type FactorProfileQuestion struct {
+	Question     string `json:"question,omitempty"`
+	QuestionText string `json:"questionText,omitempty"`
+	Answer       string `json:"answer,omitempty"`
+}
+
+func (p FactorProfileQuestion) setPublicProfile(factor *factors.Factor) {
+	factor.ProfileQuestion = &factors.ProfileQuestion{
+		QuestionText: p.QuestionText,
+	}
+}
+
+type FactorProfileSMS struct {
+	PhoneNumber string `json:"phoneNumber,omitempty"`
+}
+
+func (p FactorProfileSMS) setPublicProfile(factor *factors.Factor) {
+	factor.ProfileSMS = &factors.ProfileSMS{
+		PhoneNumber: p.PhoneNumber,
+	}
+}
+
+type FactorProfileCall struct {
+	PhoneNumber    string `json:"phoneNumber,omitempty"`
+	PhoneExtension string `json:"phoneExtension,omitempty"`
+}
+
+func (p FactorProfileCall) setPublicProfile(factor *factors.Factor) {
+	factor.ProfileCall = &factors.ProfileCall{
+		PhoneNumber:    p.PhoneNumber,
+		PhoneExtension: p.PhoneExtension,
+	}
+}
+
+type FactorProfileToken struct {
+	CredentialId string `json:"credentialId,omitempty"`
+}
+
+func (p FactorProfileToken) setPublicProfile(factor *factors.Factor) {
+	factor.ProfileToken = &factors.ProfileToken{
+		CredentialId: p.CredentialId,
+	}
+}
+
+type FactorProfileU2F struct {
+	CredentialId string `json:"credentialId,omitempty"`
+	AppId        string `json:"appId,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+func (p FactorProfileU2F) setPublicProfile(factor *factors.Factor) {
+	factor.ProfileU2F = &factors.ProfileU2F{
+		CredentialId: p.CredentialId,
+		AppId:        p.AppId,
+		Version:      p.Version,
+	}
+}
+
+type FactorProfileWebAuthN struct {
+	CredentialId      string `json:"credentialId,omitempty"`
+	AuthenticatorName string `json:"authenticatorName,omitempty"`
+}
+
+func (p FactorProfileWebAuthN) setPublicProfile(factor *factors.Factor) {
+	factor.ProfileWebAuthN = &factors.ProfileWebAuthN{
+		CredentialId:      p.CredentialId,
+		AuthenticatorName: p.AuthenticatorName,
+	}
+}
+
+// The profile of a factor type this package doesn't model, kept as the raw JSON
+// so it isn't lost when the factor is marshaled again.
+type UnknownProfile struct {
+	Raw json.RawMessage
+}
+
+func (p UnknownProfile) MarshalJSON() ([]byte, error) {
+	if len(p.Raw) == 0 {
+		return []byte("null"), nil
+	}
+	return p.Raw, nil
+}
+
+// Unknown profiles aren't shown to Prompts.
+func (p UnknownProfile) setPublicProfile(*factors.Factor) {}
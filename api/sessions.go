@@ -0,0 +1,6 @@
+package api
+
+// https://developer.okta.com/docs/reference/api/sessions/#create-session-with-session-token
+type CreateSessionRequest struct {
+	SessionToken string `json:"sessionToken"`
+}
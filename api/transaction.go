@@ -1,7 +1,12 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
+	"reflect"
 	"time"
+
+	"github.com/wearefair/okta-auth/factors"
 )
 
 type FactorResult string
@@ -29,6 +34,16 @@ const (
 	StateMFAChallenge      = TransactionState("MFA_CHALLENGE")
 	StateMFAEnroll         = TransactionState("MFA_ENROLL")
 	StateMFAEnrollActivate = TransactionState("MFA_ENROLL_ACTIVATE")
+	StateRecoveryChallenge = TransactionState("RECOVERY_CHALLENGE")
+	StatePasswordReset     = TransactionState("PASSWORD_RESET")
+	StateUnauthenticated   = TransactionState("UNAUTHENTICATED")
+)
+
+// https://developer.okta.com/docs/reference/error-codes/
+const (
+	ErrorCodeAuthenticationFailed = "E0000004"
+	ErrorCodeTooManyRequests      = "E0000047"
+	ErrorCodePasscodeReplayed     = "E0000082"
 )
 
 type APIError struct {
@@ -58,41 +73,338 @@ type AuthenticationContext struct {
 	DeviceToken string `json:"deviceToken,omitempty"`
 }
 
+// https://developer.okta.com/docs/reference/api/authn/#transaction-model
 type AuthenticationTransaction struct {
 	StateToken   string           `json:"stateToken,omitempty"`
 	SessionToken string           `json:"sessionToken,omitempty"`
 	Status       TransactionState `json:"status,omitempty"`
-	ExpiresAt    time.Time        `json: "expiresAt,omitempty"`
-	RelayState   string           `json:"relayState,omitempty"`
-	FactorResult FactorResult     `json:"factorResult,omitempty"`
-	Embedded     Embedded         `json:"_embedded,omitempty"`
-	Links        Links            `json:"_links,omitempty"`
+	// The type of the transaction, ex: SESSION_STEP_UP. Blank for most sign ins.
+	Type string `json:"type,omitempty"`
+	// Zero if Okta didn't return it, in which case it isn't encoded either.
+	ExpiresAt    time.Time    `json:"expiresAt"`
+	RelayState   string       `json:"relayState,omitempty"`
+	FactorResult FactorResult `json:"factorResult,omitempty"`
+	// A one-time token for the user to complete a recovery, and whether they
+	// are recovering their PASSWORD or UNLOCKing their account.
+	RecoveryToken string   `json:"recoveryToken,omitempty"`
+	RecoveryType  string   `json:"recoveryType,omitempty"`
+	Embedded      Embedded `json:"_embedded,omitempty"`
+	Links         Links    `json:"_links,omitempty"`
+	Unknown       Unknown  `json:"-"`
+}
+
+func (t *AuthenticationTransaction) UnmarshalJSON(data []byte) error {
+	type transaction AuthenticationTransaction
+	unknown, err := unmarshalKnown(data, (*transaction)(t))
+	t.Unknown = unknown
+	return err
+}
+
+func (t AuthenticationTransaction) MarshalJSON() ([]byte, error) {
+	type transaction AuthenticationTransaction
+	v := struct {
+		transaction
+		ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	}{transaction: transaction(t)}
+	if !t.ExpiresAt.IsZero() {
+		v.ExpiresAt = &t.ExpiresAt
+	}
+	return marshalKnown(v, t.Unknown)
 }
 
+// The resources embedded in a transaction. Which are set depends on the
+// transaction's state.
+// https://developer.okta.com/docs/reference/api/authn/#embedded-resources
 type Embedded struct {
-	User    User
-	Factors Factors
-	Factor  Factor
+	User    User    `json:"user"`
+	Factors Factors `json:"factors,omitempty"`
+	Factor  Factor  `json:"factor"`
+	// The factor types the user can enroll, when enrolling a factor.
+	FactorTypes []FactorTypeInfo `json:"factorTypes,omitempty"`
+	// The password or MFA policy, depending on the state. Nil if there is none.
+	Policy *Policy `json:"policy,omitempty"`
+	// The app the user is signing in to, nil unless the transaction was
+	// started by an app.
+	Target *Target `json:"target,omitempty"`
+	// The protocol of the sign in to the target, nil unless there is a target.
+	Authentication *Authentication `json:"authentication,omitempty"`
+	Unknown        Unknown         `json:"-"`
+}
+
+func (e *Embedded) UnmarshalJSON(data []byte) error {
+	type embedded Embedded
+	unknown, err := unmarshalKnown(data, (*embedded)(e))
+	e.Unknown = unknown
+	return err
+}
+
+// The user and factor are left out when they are blank.
+func (e Embedded) MarshalJSON() ([]byte, error) {
+	type embedded Embedded
+	v := struct {
+		embedded
+		User   *User   `json:"user,omitempty"`
+		Factor *Factor `json:"factor,omitempty"`
+	}{embedded: embedded(e)}
+	if !reflect.ValueOf(e.User).IsZero() {
+		v.User = &e.User
+	}
+	if !reflect.ValueOf(e.Factor).IsZero() {
+		v.Factor = &e.Factor
+	}
+	return marshalKnown(v, e.Unknown)
+}
+
+// Okta returns either the password policy or the MFA policy, so only the
+// fields of one are set.
+type Policy struct {
+	// The MFA policy
+	AllowRememberDevice             bool                        `json:"allowRememberDevice,omitempty"`
+	RememberDeviceLifetimeInMinutes int                         `json:"rememberDeviceLifetimeInMinutes,omitempty"`
+	RememberDeviceByDefault         bool                        `json:"rememberDeviceByDefault,omitempty"`
+	FactorsPolicyInfo               map[string]FactorPolicyInfo `json:"factorsPolicyInfo,omitempty"`
+
+	// The password policy
+	Expiration *PasswordExpiration `json:"expiration,omitempty"`
+	Complexity *PasswordComplexity `json:"complexity,omitempty"`
+	Age        *PasswordAge        `json:"age,omitempty"`
+}
+
+// The policy for a factor, keyed by the factor's id.
+type FactorPolicyInfo struct {
+	AutoPushEnabled bool `json:"autoPushEnabled,omitempty"`
+}
+
+type PasswordExpiration struct {
+	PasswordExpireDays int `json:"passwordExpireDays"`
+}
+
+type PasswordComplexity struct {
+	MinLength       int  `json:"minLength"`
+	MinLowerCase    int  `json:"minLowerCase"`
+	MinUpperCase    int  `json:"minUpperCase"`
+	MinNumber       int  `json:"minNumber"`
+	MinSymbol       int  `json:"minSymbol"`
+	ExcludeUsername bool `json:"excludeUsername"`
+}
+
+type PasswordAge struct {
+	MinAgeMinutes int `json:"minAgeMinutes"`
+	HistoryCount  int `json:"historyCount"`
+}
+
+// https://developer.okta.com/docs/reference/api/authn/#target-object
+type Target struct {
+	// Only APP is documented.
+	Type string `json:"type"`
+	// The app's name, ex: amazon_aws, and the label the user sees, ex: AWS Prod.
+	Name  string      `json:"name"`
+	Label string      `json:"label"`
+	Links TargetLinks `json:"_links"`
+}
+
+type TargetLinks struct {
+	Logo *Link `json:"logo,omitempty"`
+}
+
+// https://developer.okta.com/docs/reference/api/authn/#authentication-object
+type Authentication struct {
+	// ex: SAML2.0, SAML1.1, WS-FED or OAUTH2.0
+	Protocol string               `json:"protocol"`
+	Client   AuthenticationClient `json:"client"`
+	Issuer   AuthenticationIssuer `json:"issuer"`
+}
+
+// The OAuth client, only set for OAUTH2.0.
+type AuthenticationClient struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type AuthenticationIssuer struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+	URI  string `json:"uri,omitempty"`
+}
+
+// A factor type that can be enrolled, and the links to enroll it.
+type FactorTypeInfo struct {
+	FactorType factors.FactorType `json:"factorType"`
+	Links      Links              `json:"_links"`
 }
 
 type User struct {
-	Id      string
-	Profile UserProfile
+	Id      string      `json:"id,omitempty"`
+	Profile UserProfile `json:"profile"`
+	Unknown Unknown     `json:"-"`
+}
+
+func (u *User) UnmarshalJSON(data []byte) error {
+	type user User
+	unknown, err := unmarshalKnown(data, (*user)(u))
+	u.Unknown = unknown
+	return err
+}
+
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	return marshalKnown(user(u), u.Unknown)
 }
 
 type UserProfile struct {
-	Login     string
-	FirstName string
-	LastName  string
+	Login     string  `json:"login,omitempty"`
+	FirstName string  `json:"firstName,omitempty"`
+	LastName  string  `json:"lastName,omitempty"`
+	Unknown   Unknown `json:"-"`
+}
+
+func (p *UserProfile) UnmarshalJSON(data []byte) error {
+	type profile UserProfile
+	unknown, err := unmarshalKnown(data, (*profile)(p))
+	p.Unknown = unknown
+	return err
 }
 
+func (p UserProfile) MarshalJSON() ([]byte, error) {
+	type profile UserProfile
+	return marshalKnown(profile(p), p.Unknown)
+}
+
+// The links to the operations available in the transaction's state, or on a
+// factor. Links that Okta didn't return are nil.
+// https://developer.okta.com/docs/reference/api/authn/#links-object
 type Links struct {
-	Verify Link
-	Cancel Link
-	Next   Link
-	Prev   Link
+	Next           *Link    `json:"next,omitempty"`
+	Prev           *Link    `json:"prev,omitempty"`
+	Cancel         *Link    `json:"cancel,omitempty"`
+	Skip           *Link    `json:"skip,omitempty"`
+	Resend         LinkList `json:"resend,omitempty"`
+	Poll           *Link    `json:"poll,omitempty"`
+	Verify         *Link    `json:"verify,omitempty"`
+	Unlock         *Link    `json:"unlock,omitempty"`
+	ChangePassword *Link    `json:"changePassword,omitempty"`
+	Activate       *Link    `json:"activate,omitempty"`
+	QRCode         *Link    `json:"qrcode,omitempty"`
+	Send           LinkList `json:"send,omitempty"`
+	Enroll         *Link    `json:"enroll,omitempty"`
+	Unknown        Unknown  `json:"-"`
+}
+
+func (l *Links) UnmarshalJSON(data []byte) error {
+	type links Links
+	unknown, err := unmarshalKnown(data, (*links)(l))
+	l.Unknown = unknown
+	return err
+}
+
+func (l Links) MarshalJSON() ([]byte, error) {
+	type links Links
+	return marshalKnown(links(l), l.Unknown)
+}
+
+// Returns the link for the relation, or the next link if Okta named it after
+// the relation, ex: the next link of a challenge is named verify, or poll for
+// a push. Returns nil if there is no such link.
+func (l Links) Lookup(rel string) *Link {
+	var link *Link
+	switch rel {
+	case "next":
+		link = l.Next
+	case "prev":
+		link = l.Prev
+	case "cancel":
+		link = l.Cancel
+	case "skip":
+		link = l.Skip
+	case "resend":
+		link = l.Resend.first()
+	case "poll":
+		link = l.Poll
+	case "verify":
+		link = l.Verify
+	case "unlock":
+		link = l.Unlock
+	case "changePassword":
+		link = l.ChangePassword
+	case "activate":
+		link = l.Activate
+	case "qrcode":
+		link = l.QRCode
+	case "send":
+		link = l.Send.first()
+	case "enroll":
+		link = l.Enroll
+	}
+	if link == nil && l.Next != nil && l.Next.Name == rel {
+		return l.Next
+	}
+	return link
 }
 
 type Link struct {
+	// Distinguishes links of the same relation, ex: the factor type of a
+	// resend link, or the action of a next link.
+	Name string `json:"name,omitempty"`
 	HREF string `json:"href"`
+	// The media type of the resource, ex: image/png for a QR code.
+	Type  string    `json:"type,omitempty"`
+	Hints LinkHints `json:"hints"`
+}
+
+// Returns true if the link accepts the HTTP method. Links without hints are
+// assumed to accept any method.
+func (l Link) Allows(method string) bool {
+	if len(l.Hints.Allow) == 0 {
+		return true
+	}
+	for _, allowed := range l.Hints.Allow {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+type LinkHints struct {
+	// The HTTP methods the link accepts.
+	Allow []string `json:"allow,omitempty"`
+}
+
+// A relation with several links, ex: one resend link per factor. Okta returns
+// these as an array, or as a single link when there is only one.
+type LinkList []Link
+
+func (l *LinkList) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		var link Link
+		if err := json.Unmarshal(data, &link); err != nil {
+			return err
+		}
+		*l = LinkList{link}
+		return nil
+	}
+	var links []Link
+	if err := json.Unmarshal(data, &links); err != nil {
+		return err
+	}
+	*l = links
+	return nil
+}
+
+// Returns the link with the name, or nil if there is none.
+func (l LinkList) Named(name string) *Link {
+	for i := range l {
+		if l[i].Name == name {
+			return &l[i]
+		}
+	}
+	return nil
+}
+
+func (l LinkList) first() *Link {
+	if len(l) == 0 {
+		return nil
+	}
+	return &l[0]
 }
@@ -1,8 +1,14 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -22,30 +28,42 @@ func TestAuthenticationTransactionUnmarshalJSON(t *testing.T) {
 				Status:     StateMFARequired,
 				ExpiresAt:  time.Date(2017, 12, 12, 18, 48, 04, 0, time.UTC),
 				Links: Links{
-					Cancel: Link{
-						HREF: "https://example.okta.com/api/v1/authn/cancel",
+					Cancel: &Link{
+						HREF:  "https://example.okta.com/api/v1/authn/cancel",
+						Hints: LinkHints{Allow: []string{"POST"}},
 					},
 				},
 				Embedded: Embedded{
+					Policy: &Policy{
+						AllowRememberDevice:             true,
+						RememberDeviceLifetimeInMinutes: 15,
+					},
 					User: User{
 						Id: "00u2k4zip5XnaVacd1t6",
 						Profile: UserProfile{
 							Login:     "first@example.com",
 							FirstName: "First",
 							LastName:  "Last",
+							Unknown: Unknown{
+								"locale":   json.RawMessage(`"en"`),
+								"timeZone": json.RawMessage(`"America/Los_Angeles"`),
+							},
 						},
+						Unknown: Unknown{"passwordChanged": json.RawMessage(`"2016-07-25T21:45:09.000Z"`)},
 					},
 					Factors: []Factor{
 						{
 							Id:         "sms59eptnqQ7XZ2xe1t7",
 							FactorType: factors.FactorTypeSMS,
 							Provider:   "OKTA",
+							VendorName: "OKTA",
 							Profile: FactorProfileSMS{
 								PhoneNumber: "+1 XXX-XXX-5555",
 							},
 							Links: Links{
-								Verify: Link{
-									HREF: "https://example.okta.com/api/v1/authn/factors/sms59eptnqQ7XZ2xe1t7/verify",
+								Verify: &Link{
+									HREF:  "https://example.okta.com/api/v1/authn/factors/sms59eptnqQ7XZ2xe1t7/verify",
+									Hints: LinkHints{Allow: []string{"POST"}},
 								},
 							},
 						},
@@ -53,14 +71,16 @@ func TestAuthenticationTransactionUnmarshalJSON(t *testing.T) {
 							Id:         "fuf59d1ohqJZyOelX1t7",
 							FactorType: factors.FactorTypeU2F,
 							Provider:   "FIDO",
+							VendorName: "FIDO",
 							Profile: FactorProfileU2F{
 								CredentialId: "s94CdJnUd148p95PNq7AaY2Dv1QFrLJ12Vpkno-Q7WalmBTtB5TMnzDNL_yX84Ay49qnEiUXtSx0KK5I60ht2g",
 								AppId:        "https://example.okta.com",
 								Version:      "U2F_V2",
 							},
 							Links: Links{
-								Verify: Link{
-									HREF: "https://example.okta.com/api/v1/authn/factors/fuf59d1ohqJZyOelX1t7/verify",
+								Verify: &Link{
+									HREF:  "https://example.okta.com/api/v1/authn/factors/fuf59d1ohqJZyOelX1t7/verify",
+									Hints: LinkHints{Allow: []string{"POST"}},
 								},
 							},
 						},
@@ -68,12 +88,14 @@ func TestAuthenticationTransactionUnmarshalJSON(t *testing.T) {
 							Id:         "uftpep6vfeujtcuPc1t6",
 							FactorType: factors.FactorTypeTokenSoftwareTOTP,
 							Provider:   "GOOGLE",
+							VendorName: "GOOGLE",
 							Profile: FactorProfileToken{
 								CredentialId: "first@example.com",
 							},
 							Links: Links{
-								Verify: Link{
-									HREF: "https://example.okta.com/api/v1/authn/factors/uftpep6vfeujtcuPc1t6/verify",
+								Verify: &Link{
+									HREF:  "https://example.okta.com/api/v1/authn/factors/uftpep6vfeujtcuPc1t6/verify",
+									Hints: LinkHints{Allow: []string{"POST"}},
 								},
 							},
 						},
@@ -90,29 +112,50 @@ func TestAuthenticationTransactionUnmarshalJSON(t *testing.T) {
 				Status:     StateMFAChallenge,
 				ExpiresAt:  time.Date(2017, 12, 12, 18, 50, 13, 0, time.UTC),
 				Links: Links{
-					Next: Link{
-						HREF: "https://example.okta.com/api/v1/authn/factors/sms59eptnqQ7XZ2xe1t7/verify",
+					Next: &Link{
+						Name:  "verify",
+						HREF:  "https://example.okta.com/api/v1/authn/factors/sms59eptnqQ7XZ2xe1t7/verify",
+						Hints: LinkHints{Allow: []string{"POST"}},
 					},
-					Cancel: Link{
-						HREF: "https://example.okta.com/api/v1/authn/cancel",
+					Cancel: &Link{
+						HREF:  "https://example.okta.com/api/v1/authn/cancel",
+						Hints: LinkHints{Allow: []string{"POST"}},
 					},
-					Prev: Link{
-						HREF: "https://example.okta.com/api/v1/authn/previous",
+					Prev: &Link{
+						HREF:  "https://example.okta.com/api/v1/authn/previous",
+						Hints: LinkHints{Allow: []string{"POST"}},
+					},
+					Resend: LinkList{
+						{
+							Name:  "sms",
+							HREF:  "https://example.okta.com/api/v1/authn/factors/sms59eptnqQ7XZ2xe1t7/verify/resend",
+							Hints: LinkHints{Allow: []string{"POST"}},
+						},
 					},
 				},
 				Embedded: Embedded{
+					Policy: &Policy{
+						AllowRememberDevice:             true,
+						RememberDeviceLifetimeInMinutes: 15,
+					},
 					User: User{
 						Id: "00u2k4zip5XnaVacd1t6",
 						Profile: UserProfile{
 							Login:     "first@example.com",
 							FirstName: "First",
 							LastName:  "Last",
+							Unknown: Unknown{
+								"locale":   json.RawMessage(`"en"`),
+								"timeZone": json.RawMessage(`"America/Los_Angeles"`),
+							},
 						},
+						Unknown: Unknown{"passwordChanged": json.RawMessage(`"2016-07-25T21:45:09.000Z"`)},
 					},
 					Factor: Factor{
 						Id:         "sms59eptnqQ7XZ2xe1t7",
 						FactorType: factors.FactorTypeSMS,
 						Provider:   "OKTA",
+						VendorName: "OKTA",
 						Profile: FactorProfileSMS{
 							PhoneNumber: "+1 XXX-XXX-5555",
 						},
@@ -120,6 +163,68 @@ func TestAuthenticationTransactionUnmarshalJSON(t *testing.T) {
 				},
 			},
 		},
+
+		// Signing in to an app
+		{
+			input: sampleStateMFARequiredTarget,
+			expected: AuthenticationTransaction{
+				StateToken: "007ucIX7PATyn94hsHfOLVaXAmOBkKHWnOOLG43bsb",
+				Status:     StateMFARequired,
+				ExpiresAt:  time.Date(2017, 12, 12, 18, 48, 04, 0, time.UTC),
+				Embedded: Embedded{
+					User: User{Id: "00u2k4zip5XnaVacd1t6"},
+					Target: &Target{
+						Type:  "APP",
+						Name:  "amazon_aws",
+						Label: "AWS Prod",
+						Links: TargetLinks{
+							Logo: &Link{
+								Name: "medium",
+								HREF: "https://example.okta.com/assets/img/logos/amazon-aws.png",
+								Type: "image/png",
+							},
+						},
+					},
+					Authentication: &Authentication{
+						Protocol: "SAML2.0",
+						Issuer: AuthenticationIssuer{
+							Id:   "0oa2k4zip5XnaVacd1t6",
+							Name: "AWS Prod",
+							URI:  "http://www.okta.com/exk2k4zip5XnaVacd1t6",
+						},
+					},
+					Policy: &Policy{
+						FactorsPolicyInfo: map[string]FactorPolicyInfo{
+							"opf2k4zip5XnaVacd1t6": {AutoPushEnabled: true},
+						},
+					},
+				},
+			},
+		},
+
+		// Password expired
+		{
+			input: sampleStatePasswordExpired,
+			expected: AuthenticationTransaction{
+				StateToken: "007ucIX7PATyn94hsHfOLVaXAmOBkKHWnOOLG43bsb",
+				Status:     StatePasswordExpired,
+				ExpiresAt:  time.Date(2017, 12, 12, 18, 48, 04, 0, time.UTC),
+				Embedded: Embedded{
+					User: User{Id: "00u2k4zip5XnaVacd1t6"},
+					Policy: &Policy{
+						Expiration: &PasswordExpiration{PasswordExpireDays: 90},
+						Complexity: &PasswordComplexity{
+							MinLength:       8,
+							MinLowerCase:    1,
+							MinUpperCase:    1,
+							MinNumber:       1,
+							ExcludeUsername: true,
+						},
+						Age: &PasswordAge{MinAgeMinutes: 60, HistoryCount: 4},
+					},
+				},
+			},
+		},
 	}
 
 	for i, testCase := range testCases {
@@ -135,6 +240,203 @@ func TestAuthenticationTransactionUnmarshalJSON(t *testing.T) {
 	}
 }
 
+var update = flag.Bool("update", false, "update the golden files")
+
+// Each documented transaction state is decoded from testdata/transactions, and
+// encoded again to the matching golden file, which must keep everything Okta sent.
+// Files are named after the state, and optionally a variant, ex: mfa_challenge.duo.json.
+func TestAuthenticationTransactionGolden(t *testing.T) {
+	paths, err := filepath.Glob("testdata/transactions/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			input, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			transaction := AuthenticationTransaction{}
+			if err := json.Unmarshal(input, &transaction); err != nil {
+				t.Fatal(err)
+			}
+			state, _, _ := strings.Cut(name, ".")
+			if transaction.Status != TransactionState(strings.ToUpper(state)) {
+				t.Errorf("expected status %s, got %s", strings.ToUpper(state), transaction.Status)
+			}
+			output, err := json.MarshalIndent(transaction, "", "  ")
+			if err != nil {
+				t.Fatal(err)
+			}
+			output = append(output, '\n')
+
+			golden := strings.TrimSuffix(path, ".json") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, output, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(output, expected) {
+				t.Errorf("%s doesn't match, run the tests with -update if expected:\n%s", golden, output)
+			}
+
+			var want, got interface{}
+			if err := json.Unmarshal(input, &want); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(output, &got); err != nil {
+				t.Fatal(err)
+			}
+			assertLossless(t, "", want, got)
+		})
+	}
+}
+
+// Fails if a value in want is missing from got. Zero values may be left out,
+// times are compared as instants, and single links may become a list.
+func assertLossless(t *testing.T, path string, want, got interface{}) {
+	t.Helper()
+	switch want := want.(type) {
+	case map[string]interface{}:
+		// Single links of array-valued relations are encoded as a list
+		if list, ok := got.([]interface{}); ok && len(list) == 1 {
+			got = list[0]
+		}
+		got, _ := got.(map[string]interface{})
+		for key, value := range want {
+			if gotValue, ok := got[key]; ok {
+				assertLossless(t, path+"."+key, value, gotValue)
+			} else if !isZeroJSON(value) {
+				t.Errorf("%s.%s was lost", path, key)
+			}
+		}
+	case []interface{}:
+		got, _ := got.([]interface{})
+		if len(got) != len(want) {
+			t.Errorf("%s has %d values, expected %d", path, len(got), len(want))
+			return
+		}
+		for i := range want {
+			assertLossless(t, fmt.Sprintf("%s[%d]", path, i), want[i], got[i])
+		}
+	case string:
+		if got, ok := got.(string); ok && got != want {
+			wantTime, wantErr := time.Parse(time.RFC3339, want)
+			gotTime, gotErr := time.Parse(time.RFC3339, got)
+			if wantErr != nil || gotErr != nil || !wantTime.Equal(gotTime) {
+				t.Errorf("%s is %q, expected %q", path, got, want)
+			}
+		} else if !ok {
+			t.Errorf("%s is %v, expected %q", path, got, want)
+		}
+	default:
+		if !reflect.DeepEqual(want, got) && !(got == nil && isZeroJSON(want)) {
+			t.Errorf("%s is %v, expected %v", path, got, want)
+		}
+	}
+}
+
+func isZeroJSON(v interface{}) bool {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, value := range v {
+			if !isZeroJSON(value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		return len(v) == 0
+	default:
+		return v == nil || v == false || v == 0.0 || v == ""
+	}
+}
+
+// Anything that decodes must encode, and decode again to the same transaction.
+func FuzzAuthenticationTransaction(f *testing.F) {
+	paths, err := filepath.Glob("testdata/transactions/*.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		input, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(input)
+	}
+	f.Add([]byte(sampleStateMFARequired))
+	f.Add([]byte(sampleStateMFAChallenge))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		transaction := AuthenticationTransaction{}
+		if err := json.Unmarshal(input, &transaction); err != nil {
+			return
+		}
+		first, err := json.Marshal(transaction)
+		if err != nil {
+			t.Fatalf("decoded %q, but couldn't encode it: %v", input, err)
+		}
+		again := AuthenticationTransaction{}
+		if err := json.Unmarshal(first, &again); err != nil {
+			t.Fatalf("couldn't decode %q, encoded from %q: %v", first, input, err)
+		}
+		second, err := json.Marshal(again)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first, second) {
+			t.Errorf("encoding isn't stable:\n%s\n%s", first, second)
+		}
+	})
+}
+
+func TestLinksUnmarshalJSON(t *testing.T) {
+	input := `{
+	  "next": {"name": "poll", "href": "https://example.okta.com/verify", "hints": {"allow": ["POST"]}},
+	  "resend": {"name": "push", "href": "https://example.okta.com/verify/resend"},
+	  "send": [
+	    {"name": "sms", "href": "https://example.okta.com/activate/sms"},
+	    {"name": "email", "href": "https://example.okta.com/activate/email"}
+	  ],
+	  "qrcode": {"href": "https://example.okta.com/qr", "type": "image/png", "hints": {"allow": ["GET"]}},
+	  "changePassword": {"href": "https://example.okta.com/password"}
+	}`
+	links := Links{}
+	if err := json.Unmarshal([]byte(input), &links); err != nil {
+		t.Fatal(err)
+	}
+
+	// Single links of array-valued relations are accepted
+	if len(links.Resend) != 1 || links.Resend[0].Name != "push" {
+		t.Errorf("unexpected resend links %#+v", links.Resend)
+	}
+	if link := links.Send.Named("email"); link == nil || link.HREF != "https://example.okta.com/activate/email" {
+		t.Errorf("unexpected email send link %#+v", link)
+	}
+	if links.QRCode == nil || links.QRCode.Type != "image/png" || links.QRCode.Allows("POST") || !links.QRCode.Allows("GET") {
+		t.Errorf("unexpected qrcode link %#+v", links.QRCode)
+	}
+	if links.ChangePassword == nil || !links.ChangePassword.Allows("POST") {
+		t.Errorf("expected links without hints to allow any method, got %#+v", links.ChangePassword)
+	}
+
+	// The next link is found by its name
+	if link := links.Lookup("poll"); link != links.Next {
+		t.Errorf("expected the next link for poll, got %#+v", link)
+	}
+	for _, rel := range []string{"verify", "prev", "cancel", "skip", "unlock"} {
+		if link := links.Lookup(rel); link != nil {
+			t.Errorf("expected no %s link, got %#+v", rel, link)
+		}
+	}
+}
+
 // Grabbed from a real API response
 var sampleStateMFARequired = `
 {
@@ -306,3 +608,62 @@ var sampleStateMFAChallenge = `
   }
 }
 `
+
+var sampleStateMFARequiredTarget = `
+{
+  "stateToken": "007ucIX7PATyn94hsHfOLVaXAmOBkKHWnOOLG43bsb",
+  "expiresAt": "2017-12-12T18:48:04.000Z",
+  "status": "MFA_REQUIRED",
+  "_embedded": {
+    "user": {"id": "00u2k4zip5XnaVacd1t6"},
+    "policy": {
+      "factorsPolicyInfo": {
+        "opf2k4zip5XnaVacd1t6": {"autoPushEnabled": true}
+      }
+    },
+    "target": {
+      "type": "APP",
+      "name": "amazon_aws",
+      "label": "AWS Prod",
+      "_links": {
+        "logo": {
+          "name": "medium",
+          "href": "https://example.okta.com/assets/img/logos/amazon-aws.png",
+          "type": "image/png"
+        }
+      }
+    },
+    "authentication": {
+      "protocol": "SAML2.0",
+      "issuer": {
+        "id": "0oa2k4zip5XnaVacd1t6",
+        "name": "AWS Prod",
+        "uri": "http://www.okta.com/exk2k4zip5XnaVacd1t6"
+      }
+    }
+  }
+}
+`
+
+var sampleStatePasswordExpired = `
+{
+  "stateToken": "007ucIX7PATyn94hsHfOLVaXAmOBkKHWnOOLG43bsb",
+  "expiresAt": "2017-12-12T18:48:04.000Z",
+  "status": "PASSWORD_EXPIRED",
+  "_embedded": {
+    "user": {"id": "00u2k4zip5XnaVacd1t6"},
+    "policy": {
+      "expiration": {"passwordExpireDays": 90},
+      "complexity": {
+        "minLength": 8,
+        "minLowerCase": 1,
+        "minUpperCase": 1,
+        "minNumber": 1,
+        "minSymbol": 0,
+        "excludeUsername": true
+      },
+      "age": {"minAgeMinutes": 60, "historyCount": 4}
+    }
+  }
+}
+`
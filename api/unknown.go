@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// The raw JSON of the fields of an object that aren't modeled, keyed by name.
+// Types that keep their unknown fields marshal them back out, so decoding and
+// encoding a response doesn't lose anything Okta sent.
+type Unknown map[string]json.RawMessage
+
+// Unmarshals data into v, a pointer to a struct without its own UnmarshalJSON,
+// and returns the fields of data that none of the struct's fields decode.
+func unmarshalKnown(data []byte, v interface{}) (Unknown, error) {
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	known := knownFields(reflect.TypeOf(v).Elem())
+	var unknown Unknown
+	for name, value := range fields {
+		if known.has(name) {
+			continue
+		}
+		if unknown == nil {
+			unknown = Unknown{}
+		}
+		unknown[name] = value
+	}
+	return unknown, nil
+}
+
+// Marshals v, a struct without its own MarshalJSON, adding the unknown fields
+// that don't collide with its own.
+func marshalKnown(v interface{}, unknown Unknown) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || len(unknown) == 0 {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	known := knownFields(reflect.TypeOf(v))
+	for name, value := range unknown {
+		if !known.has(name) {
+			fields[name] = value
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// The JSON names of a struct's fields.
+type fieldNames []string
+
+// Field names are matched case insensitively, like encoding/json.
+func (f fieldNames) has(name string) bool {
+	for _, field := range f {
+		if strings.EqualFold(field, name) {
+			return true
+		}
+	}
+	return false
+}
+
+var fieldNamesCache sync.Map
+
+func knownFields(t reflect.Type) fieldNames {
+	if names, ok := fieldNamesCache.Load(t); ok {
+		return names.(fieldNames)
+	}
+	var names fieldNames
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		// The fields of embedded structs are promoted
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			names = append(names, knownFields(field.Type)...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		names = append(names, name)
+	}
+	fieldNamesCache.Store(t, names)
+	return names
+}
@@ -30,17 +30,22 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	"github.com/wearefair/okta-auth/api"
 	"github.com/wearefair/okta-auth/factors"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const unexpectedErrorMessage = "Encountered an unexpected error."
 const timeoutErrorMessage = "Authentication Timed Out"
 
+// How often the result of a push is polled for.
+var pushPollInterval = 3 * time.Second
+
 // Custom error for handling auth timeout and rejection
 type NonFatalAuthError struct {
 	ErrorSummary string
@@ -56,21 +61,75 @@ func (nonFatalAuthError NonFatalAuthError) Error() string {
 //
 // If a second factor is required, the configured callbacks on the client will be invoked.
 func (c *OktaClient) Authenticate(username, password string) (string, error) {
-	url := c.rootURL + "/api/v1/authn"
-	c.log("Posting auth request to %q with username %q ", url, username)
+	return c.AuthenticateContext(context.Background(), username, password)
+}
+
+// Same as Authenticate, but requests to Okta are made with the given context.
+// Canceling the context aborts the flow, and any trace in the context is continued.
+func (c *OktaClient) AuthenticateContext(ctx context.Context, username, password string) (string, error) {
+	ctx, span := c.tracer.Start(ctx, "okta.Authenticate", trace.WithAttributes(traceKeyDomain.String(c.domain)))
+	flow := newAuthFlow(ctx, span, username)
+	sessionToken, err := c.authenticate(flow, password)
+	c.endFlowSpans(flow, err)
+	c.observer.AuthenticationFinished(AuthenticationFinishedEvent{
+		Username: username,
+		Err:      err,
+		Duration: time.Since(flow.start),
+	})
+	return sessionToken, err
+}
+
+// Returns the factors enrolled for the user, without verifying any of them.
+// The password is verified to start an authentication transaction, which is
+// cancelled once the factors are listed. Returns no factors if the user isn't
+// required to verify a second factor.
+func (c *OktaClient) ListFactors(ctx context.Context, username, password string) ([]factors.Factor, error) {
+	ctx, span := c.tracer.Start(ctx, "okta.ListFactors", trace.WithAttributes(traceKeyDomain.String(c.domain)))
+	defer span.End()
 
-	transaction, apiError, err := c.sendTransactionRequest(url, &api.AuthenticationRequest{
+	transaction, apiError, err := c.sendTransactionRequest(ctx, c.rootURL+"/api/v1/authn", &api.AuthenticationRequest{
 		Username: username,
 		Password: password,
 	})
+	if err != nil {
+		return nil, err
+	}
+	if apiError != nil {
+		c.logger.Warn("Primary authentication failed", slog.String(LogKeyError, apiError.ErrorSummary))
+		return nil, errors.New("Failed to authenticate")
+	}
+
+	switch transaction.Status {
+	case api.StateSuccess:
+		return nil, nil
+	case api.StateMFARequired:
+		_, apiError, err := c.sendLinkRequest(ctx, transaction.Links, "cancel", &api.FactorVerify{StateToken: transaction.StateToken})
+		if err == nil && apiError != nil {
+			c.transactionLogger(transaction).Warn("Got error trying to cancel transaction", slog.String(LogKeyError, apiError.ErrorSummary))
+		}
+		return apiFactorsToPublicFactors(transaction.Embedded.Factors), nil
+	default:
+		return nil, TerminalError(fmt.Sprintf("Can't list factors when the user is in state %s, login to %s to resolve.", transaction.Status, c.rootURL))
+	}
+}
+
+func (c *OktaClient) authenticate(flow *authFlow, password string) (string, error) {
+	url := c.rootURL + "/api/v1/authn"
+	c.logger.Debug("Posting primary authentication request")
+	c.observer.PrimaryAuthStarted(PrimaryAuthEvent{Username: flow.username})
+
+	transaction, apiError, err := c.sendTransactionRequest(flow.ctx, url, &api.AuthenticationRequest{
+		Username: flow.username,
+		Password: password,
+	})
 	if err != nil {
 		return "", err
 	}
 	if apiError != nil {
-		c.log(apiError.ErrorSummary)
+		c.logger.Warn("Primary authentication failed", slog.String(LogKeyError, apiError.ErrorSummary))
 		return "", errors.New("Failed to authenticate")
 	}
-	return c.handleAuthUserFlow(transaction, true)
+	return c.handleAuthUserFlow(flow, transaction, true)
 }
 
 // Given an AuthenticationTransaction executes the state machine, and eventually returns
@@ -78,8 +137,9 @@ func (c *OktaClient) Authenticate(username, password string) (string, error) {
 //
 // This is the entrypoint to the main recursive loop.
 // All methods will eventually call this method, or return the session token or error.
-func (c *OktaClient) handleAuthUserFlow(transaction api.AuthenticationTransaction, autoAttemptU2F bool) (string, error) {
-	c.log("Handling auth user flow: status %q", transaction.Status)
+func (c *OktaClient) handleAuthUserFlow(flow *authFlow, transaction api.AuthenticationTransaction, autoSelect bool) (string, error) {
+	c.transactionLogger(transaction).Debug("Handling auth user flow")
+	c.observeTransition(flow, transaction)
 
 	switch transaction.Status {
 	case api.StateSuccess:
@@ -93,46 +153,54 @@ func (c *OktaClient) handleAuthUserFlow(transaction api.AuthenticationTransactio
 	case api.StateMFAEnroll, api.StateMFAEnrollActivate:
 		return "", TerminalError(fmt.Sprintf("You are required to enroll an MFA method, login to %s to resolve.", c.rootURL))
 	case api.StateMFARequired:
-		return c.handleMFARequired(transaction, autoAttemptU2F)
+		return c.handleMFARequired(flow, transaction, autoSelect)
 	case api.StateMFAChallenge:
-		return c.handleMFAChallenge(transaction)
+		return c.handleMFAChallenge(flow, transaction)
 	default:
 		return "", TerminalError(fmt.Sprintf("Unknown user state %s, contact your administrator for assistance.", transaction.Status))
 	}
 }
 
-// If autoAttemptU2F is true, calls the user provided U2F callback to check if the device is present,
-// and if so will start the U2F flow for that factor.
-// Otherwise calls the user provided callback with the list of factors, which should return the user specified factor
-// or an error which will cancel the flow.
-func (c *OktaClient) handleMFARequired(transaction api.AuthenticationTransaction, autoAttemptU2F bool) (string, error) {
+// If autoSelect is true, starts the factor chosen by the FactorPolicy, calling the user provided U2F callback
+// to check if security keys are present.
+// Otherwise calls the user provided callback with the list of allowed factors, which should return the user
+// specified factor or an error which will cancel the flow.
+func (c *OktaClient) handleMFARequired(flow *authFlow, transaction api.AuthenticationTransaction, autoSelect bool) (string, error) {
 	supported := transaction.Embedded.Factors.SupportedFactors()
 	if len(supported) == 0 {
 		return "", TerminalError("No supported MFA types found")
 	}
-
-	// Start the mfa factor automatically if it is present, and the u2f token is connected.
-	for _, factor := range supported {
-		if factor.FactorType == factors.FactorTypeU2F && autoAttemptU2F &&
-			c.prompts.CheckU2FPresence(u2fProfileToChallenge(c.domain, "", factor.Profile.(api.FactorProfileU2F))) {
-			return c.startMFA(transaction, factor)
-		}
-
-		if factor.FactorType == factors.FactorTypeWebAuthN && autoAttemptU2F &&
-			c.prompts.CheckU2FPresence(webAuthNProfileToChallenge(c.domain, "", factor.Profile.(api.FactorProfileWebAuthN))) {
-			return c.startMFA(transaction, factor)
+	allowed := c.factorPolicy.allowed(supported)
+	if len(allowed) == 0 {
+		return "", TerminalError("No allowed MFA types found")
+	}
+	c.loadLastFactor(flow)
+	c.presentContext(flow, transaction)
+
+	// Start a factor automatically the first time through, so a failed attempt
+	// falls back to asking the user.
+	if autoSelect {
+		if factor, ok := c.autoSelectFactor(flow, allowed); ok {
+			c.observeFactorChosen(factor, true)
+			flow.autoSelected = true
+			flow.raceFactor = c.raceFactorFor(factor, allowed)
+			return c.startMFA(flow, transaction, factor)
 		}
 	}
 
-	publicFactors := apiFactorsToPublicFactors(supported)
-	factor, err := c.prompts.ChooseFactor(publicFactors)
+	// The last factor is the default choice, the rest are in order of preference
+	publicFactors := apiFactorsToPublicFactors(withLastFactorFirst(allowed, flow.lastFactorId))
+	factor, err := c.chooseFactor(flow, publicFactors)
 	if err != nil {
 		return "", err
 	}
 
-	for _, apiFactor := range supported {
+	for _, apiFactor := range allowed {
 		if apiFactor.Id == factor.Id {
-			return c.startMFA(transaction, apiFactor)
+			c.observeFactorChosen(apiFactor, false)
+			flow.autoSelected = false
+			flow.raceFactor = c.raceFactorFor(apiFactor, allowed)
+			return c.startMFA(flow, transaction, apiFactor)
 		}
 	}
 
@@ -140,71 +208,77 @@ func (c *OktaClient) handleMFARequired(transaction api.AuthenticationTransaction
 }
 
 // Starts the verification flow for the given factor.
-func (c *OktaClient) startMFA(transaction api.AuthenticationTransaction, factor api.Factor) (string, error) {
-	newTransaction, apiError, err := c.sendTransactionRequest(factor.Links.Verify.HREF, api.FactorVerify{
+func (c *OktaClient) startMFA(flow *authFlow, transaction api.AuthenticationTransaction, factor api.Factor) (string, error) {
+	c.transactionLogger(transaction).Debug("Starting MFA", factorLogAttrs(factor)...)
+
+	newTransaction, apiError, err := c.sendLinkRequest(flow.ctx, factor.Links, "verify", api.FactorVerify{
 		StateToken: transaction.StateToken,
 	})
 	if err != nil {
 		return "", err
 	}
 	if apiError != nil {
-		c.prompts.PresentUserError(fmt.Sprintf("Got error trying to use MFA %s: %s", factor.FactorType, apiError.ErrorSummary))
+		c.presentUserError(flow, fmt.Sprintf("Got error trying to use MFA %s: %s", factor.FactorType, apiError.ErrorSummary))
 	}
 
-	return c.handleAuthUserFlow(newTransaction, false)
+	return c.handleAuthUserFlow(flow, newTransaction, false)
 }
 
 // Captures user input (if required) to verify the active factor challenge.
-func (c *OktaClient) handleMFAChallenge(transaction api.AuthenticationTransaction) (string, error) {
+func (c *OktaClient) handleMFAChallenge(flow *authFlow, transaction api.AuthenticationTransaction) (string, error) {
 	switch transaction.Embedded.Factor.FactorType {
 	case factors.FactorTypeU2F:
-		return c.handleFactorTypeU2F(transaction)
+		return c.handleFactorTypeU2F(flow, transaction)
 
 	case factors.FactorTypeWebAuthN:
-		return c.handleFactorTypeWebAuthn(transaction)
+		return c.handleFactorTypeWebAuthn(flow, transaction)
 
 	case factors.FactorTypeTokenSoftwareTOTP, factors.FactorTypeSMS, factors.FactorTypeCall:
-		return c.handleFactorTypeCode(transaction)
+		return c.handleFactorTypeCode(flow, transaction)
 
 	case factors.FactorTypePush:
-		return c.handleFactorTypePush(transaction)
+		return c.handleFactorTypePush(flow, transaction)
+
+	case factors.FactorTypeWeb:
+		return c.handleFactorTypeDuo(flow, transaction)
 
 	default:
-		return c.cancelCurrentFactorWithErrorMessage(transaction, "Sorry, that factor is not supported yet.")
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, "Sorry, that factor is not supported yet.")
 	}
 }
 
 // Presents the user with the error message, and then cancels the current factor.
-func (c *OktaClient) cancelCurrentFactorWithErrorMessage(transaction api.AuthenticationTransaction, msg string) (string, error) {
-	c.prompts.PresentUserError(msg)
-	return c.cancelCurrentFactor(transaction)
+func (c *OktaClient) cancelCurrentFactorWithErrorMessage(flow *authFlow, transaction api.AuthenticationTransaction, msg string) (string, error) {
+	c.presentUserError(flow, msg)
+	return c.cancelCurrentFactor(flow, transaction)
 }
 
 // Cancels the current factor, and goes back into the authentication transaction loop.
-func (c *OktaClient) cancelCurrentFactor(transaction api.AuthenticationTransaction) (string, error) {
-	request := &api.FactorVerify{transaction.StateToken}
-	newTransaction, apiError, err := c.sendTransactionRequest(transaction.Links.Prev.HREF, request)
+func (c *OktaClient) cancelCurrentFactor(flow *authFlow, transaction api.AuthenticationTransaction) (string, error) {
+	request := &api.FactorVerify{StateToken: transaction.StateToken}
+	newTransaction, apiError, err := c.sendLinkRequest(flow.ctx, transaction.Links, "prev", request)
 	if err != nil {
 		return "", err
 	}
 	if apiError != nil {
-		c.log("Got error trying to cancel MFA factor: uri %q, error: %q", transaction.Links.Prev.HREF, apiError.ErrorSummary)
+		c.transactionLogger(transaction).Error("Got error trying to cancel MFA factor", slog.String(LogKeyError, apiError.ErrorSummary))
 		return "", TerminalError(unexpectedErrorMessage)
 	}
 
-	return c.handleAuthUserFlow(newTransaction, false)
+	return c.handleAuthUserFlow(flow, newTransaction, false)
 }
 
-func (c *OktaClient) handleFactorTypeWebAuthn(transaction api.AuthenticationTransaction) (string, error) {
+func (c *OktaClient) handleFactorTypeWebAuthn(flow *authFlow, transaction api.AuthenticationTransaction) (string, error) {
 	profile, ok := transaction.Embedded.Factor.Profile.(api.FactorProfileWebAuthN)
 	if !ok {
-		c.log("Profile was not of type FactorProfileWebAuthN: %s", transaction.Embedded.Factor.Profile)
-		return c.cancelCurrentFactorWithErrorMessage(transaction, unexpectedErrorMessage)
+		c.transactionLogger(transaction).Error("Profile was not of type FactorProfileWebAuthN", slog.Any("profile", transaction.Embedded.Factor.Profile))
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, unexpectedErrorMessage)
 	}
 
 	// Setup a context with the timeout set to the value provided by Okta
 	timeoutSeconds := 30
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
+	ctx, cancel := context.WithTimeout(flow.ctx, time.Second*time.Duration(timeoutSeconds))
+	defer cancel()
 
 	req := VerifyU2FRequest{
 		Facet:     "https://" + c.domain,
@@ -213,10 +287,12 @@ func (c *OktaClient) handleFactorTypeWebAuthn(transaction api.AuthenticationTran
 		Challenge: transaction.Embedded.Factor.Embedded.Challenge.Challenge,
 		WebAuthn:  true,
 	}
-	authResp, err := c.prompts.VerifyU2F(ctx, req)
+	c.observeChallengeIssued(flow, transaction.Embedded.Factor)
+	authResp, err := c.verifyU2F(flow, ctx, req)
 	if err != nil {
-		c.prompts.PresentUserError(fmt.Sprintf("Failed to authenticate: %s\n", err))
-		return c.cancelCurrentFactor(transaction)
+		c.observeFactorResult(flow, api.FactorResultCancelled)
+		c.presentUserError(flow, fmt.Sprintf("Failed to authenticate: %s\n", err))
+		return c.cancelCurrentFactor(flow, transaction)
 	}
 
 	verifyReq := api.FactorVerifyWebAuthN{
@@ -227,37 +303,42 @@ func (c *OktaClient) handleFactorTypeWebAuthn(transaction api.AuthenticationTran
 		SignatureData:     authResp.SignatureData,
 		AuthenticatorData: authResp.AuthenticatorData,
 	}
-	newTransaction, apiError, err := c.sendTransactionRequest(transaction.Links.Next.HREF, &verifyReq)
+	newTransaction, apiError, err := c.sendLinkRequest(flow.ctx, transaction.Links, "verify", &verifyReq)
 	if err != nil {
 		return "", err
 	}
 	if apiError != nil {
-		return c.cancelCurrentFactorWithErrorMessage(transaction, apiError.ErrorSummary)
+		c.observeVerifyError(flow, apiError)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, apiError.ErrorSummary)
 	}
-	return c.handleAuthUserFlow(newTransaction, false)
+	c.observeVerifiedTransaction(flow, newTransaction)
+	return c.handleAuthUserFlow(flow, newTransaction, false)
 
 }
 
-func (c *OktaClient) handleFactorTypeU2F(transaction api.AuthenticationTransaction) (string, error) {
+func (c *OktaClient) handleFactorTypeU2F(flow *authFlow, transaction api.AuthenticationTransaction) (string, error) {
 	profile, ok := transaction.Embedded.Factor.Profile.(api.FactorProfileU2F)
 	if !ok {
-		c.log("Profile was not of type FactorProfileU2F: %s", transaction.Embedded.Factor.Profile)
-		return c.cancelCurrentFactorWithErrorMessage(transaction, unexpectedErrorMessage)
+		c.transactionLogger(transaction).Error("Profile was not of type FactorProfileU2F", slog.Any("profile", transaction.Embedded.Factor.Profile))
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, unexpectedErrorMessage)
 	}
 
 	// Setup a context with the timeout set to the value provided by Okta
 	timeoutSeconds := transaction.Embedded.Factor.Embedded.Challenge.TimeoutSeconds
-	ctx, _ := context.WithTimeout(context.Background(), time.Second*time.Duration(timeoutSeconds))
+	ctx, cancel := context.WithTimeout(flow.ctx, time.Second*time.Duration(timeoutSeconds))
+	defer cancel()
 
-	authResp, err := c.prompts.VerifyU2F(ctx, VerifyU2FRequest{
+	c.observeChallengeIssued(flow, transaction.Embedded.Factor)
+	authResp, err := c.verifyU2F(flow, ctx, VerifyU2FRequest{
 		Facet:     "https://" + c.domain,
 		AppId:     profile.AppId,
 		KeyHandle: profile.CredentialId,
 		Challenge: transaction.Embedded.Factor.Embedded.Challenge.Nonce,
 	})
 	if err != nil {
-		c.prompts.PresentUserError(fmt.Sprintf("Failed to authenticate: %s\n", err))
-		return c.cancelCurrentFactor(transaction)
+		c.observeFactorResult(flow, api.FactorResultCancelled)
+		c.presentUserError(flow, fmt.Sprintf("Failed to authenticate: %s\n", err))
+		return c.cancelCurrentFactor(flow, transaction)
 	}
 
 	verifyReq := api.FactorVerifyU2F{
@@ -267,20 +348,24 @@ func (c *OktaClient) handleFactorTypeU2F(transaction api.AuthenticationTransacti
 		ClientData:    authResp.ClientData,
 		SignatureData: authResp.SignatureData,
 	}
-	newTransaction, apiError, err := c.sendTransactionRequest(transaction.Links.Next.HREF, &verifyReq)
+	newTransaction, apiError, err := c.sendLinkRequest(flow.ctx, transaction.Links, "verify", &verifyReq)
 	if err != nil {
 		return "", err
 	}
 	if apiError != nil {
-		return c.cancelCurrentFactorWithErrorMessage(transaction, apiError.ErrorSummary)
+		c.observeVerifyError(flow, apiError)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, apiError.ErrorSummary)
 	}
-	return c.handleAuthUserFlow(newTransaction, false)
+	c.observeVerifiedTransaction(flow, newTransaction)
+	return c.handleAuthUserFlow(flow, newTransaction, false)
 }
 
-func (c *OktaClient) handleFactorTypeCode(transaction api.AuthenticationTransaction) (string, error) {
-	code, err := c.prompts.VerifyCode(apiFactorToPublicFactor(transaction.Embedded.Factor))
+func (c *OktaClient) handleFactorTypeCode(flow *authFlow, transaction api.AuthenticationTransaction) (string, error) {
+	c.observeChallengeIssued(flow, transaction.Embedded.Factor)
+	code, err := c.verifyCode(flow, transaction.Embedded.Factor.Public())
 	if err != nil {
-		return c.cancelCurrentFactorWithErrorMessage(transaction, "Cancelled")
+		c.observeFactorResult(flow, api.FactorResultCancelled)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, "Cancelled")
 	}
 
 	verifyReq := api.FactorVerifyCode{
@@ -289,14 +374,16 @@ func (c *OktaClient) handleFactorTypeCode(transaction api.AuthenticationTransact
 		},
 		PassCode: code,
 	}
-	newTransaction, apiError, err := c.sendTransactionRequest(transaction.Links.Next.HREF, &verifyReq)
+	newTransaction, apiError, err := c.sendLinkRequest(flow.ctx, transaction.Links, "verify", &verifyReq)
 	if err != nil {
 		return "", err
 	}
 	if apiError != nil {
-		return c.cancelCurrentFactorWithErrorMessage(transaction, apiError.ErrorSummary)
+		c.observeVerifyError(flow, apiError)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, apiError.ErrorSummary)
 	}
-	return c.handleAuthUserFlow(newTransaction, false)
+	c.observeVerifiedTransaction(flow, newTransaction)
+	return c.handleAuthUserFlow(flow, newTransaction, false)
 }
 
 // Logic for handling Okta Verify Push. Given a Authentication Transaction, will make an initial call to send a push notification
@@ -304,8 +391,8 @@ func (c *OktaClient) handleFactorTypeCode(transaction api.AuthenticationTransact
 // Important to note that if a user times out, the initial verify request will still be on their phone and they'll have to accept/reject it
 // before trying again.
 // TODO: Configurable timeouts
-func (c *OktaClient) handleFactorTypePush(transaction api.AuthenticationTransaction) (string, error) {
-	var newTransaction api.AuthenticationTransaction
+func (c *OktaClient) handleFactorTypePush(flow *authFlow, transaction api.AuthenticationTransaction) (string, error) {
+	factor := transaction.Embedded.Factor
 
 	// Sends a request to Okta to push a notification to user's device
 	verifyReq := api.FactorVerifyPush{
@@ -313,79 +400,118 @@ func (c *OktaClient) handleFactorTypePush(transaction api.AuthenticationTransact
 			StateToken: transaction.StateToken,
 		},
 	}
-	transaction, apiError, err := c.sendTransactionRequest(transaction.Links.Next.HREF, &verifyReq)
+	newTransaction, apiError, err := c.sendLinkRequest(flow.ctx, transaction.Links, "poll", &verifyReq)
 	if err != nil {
-		return c.cancelCurrentFactorWithErrorMessage(transaction, "Cancelled")
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, "Cancelled")
 	}
 	if apiError != nil {
-		return c.cancelCurrentFactorWithErrorMessage(transaction, apiError.ErrorSummary)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, apiError.ErrorSummary)
 	}
 
 	// Prompt user to check their device for an Okta Verify notification
-	c.prompts.VerifyPush()
+	c.observeChallengeIssued(flow, factor)
+	c.verifyPush(flow)
 
 	// Setup and begin constant backoff policy that retries every 3 seconds with a maximum of 10 attempts (timeout after 30 seconds)
-	backoffPolicy := backoff.WithMaxRetries(backoff.NewConstantBackOff(3*time.Second), 10)
-	operation := func() error {
-		newTransaction, apiError, err = c.sendTransactionRequest(transaction.Links.Next.HREF, &verifyReq)
-		if err != nil {
-			return backoff.Permanent(err)
-		}
-		if apiError != nil {
-			return backoff.Permanent(apiError)
+	poll := func(ctx context.Context) error {
+		var pollURL string
+		if link := newTransaction.Links.Lookup("poll"); link != nil {
+			pollURL = link.HREF
 		}
-		if newTransaction.Status == api.StateSuccess {
-			return nil
+		backoffPolicy := backoff.WithMaxRetries(backoff.NewConstantBackOff(pushPollInterval), 10)
+		operation := func() error {
+			polled, apiError, err := c.sendLinkRequest(ctx, newTransaction.Links, "poll", &verifyReq)
+			if err != nil {
+				return backoff.Permanent(err)
+			}
+			if apiError != nil {
+				return backoff.Permanent(apiError)
+			}
+			newTransaction = polled
+			if newTransaction.Status == api.StateSuccess {
+				return nil
+			}
+			if newTransaction.FactorResult == api.FactorResultRejected {
+				return backoff.Permanent(&NonFatalAuthError{"Authentication Rejected"})
+			}
+			if newTransaction.FactorResult == api.FactorResultTimeout {
+				return backoff.Permanent(&NonFatalAuthError{timeoutErrorMessage})
+			}
+			return &NonFatalAuthError{timeoutErrorMessage}
 		}
-		if newTransaction.FactorResult == api.FactorResultRejected {
-			fmt.Println("Authentication Request rejected")
-			return backoff.Permanent(&NonFatalAuthError{"Authentication Rejected"})
+		return backoff.RetryNotify(operation, backoff.WithContext(backoffPolicy, ctx), c.observeHTTPRetries(http.MethodPost, pollURL))
+	}
+
+	// The push may have been approved before the first poll. Otherwise wait for it,
+	// or for a code if the user can enter one instead.
+	if newTransaction.Status != api.StateSuccess {
+		if raceFactor := flow.raceFactor; raceFactor.Id != "" {
+			var code string
+			code, err = c.racePushWithCode(flow, raceFactor, poll)
+			if code != "" {
+				return c.verifyRacedCode(flow, newTransaction, raceFactor, code)
+			}
+		} else {
+			err = poll(flow.ctx)
 		}
-		return &NonFatalAuthError{timeoutErrorMessage}
 	}
-	err = backoff.Retry(operation, backoffPolicy)
 
-	// If error is a NonFatalAuthError (timeout or rejection) then cancel the transaction so we can go through the auth flow again
+	// If error is a NonFatalAuthError (timeout or rejection) then cancel the transaction so we can go through the auth flow again.
+	// A remembered or automatically chosen push goes back to the user choosing a factor instead.
 	if _, ok := err.(*NonFatalAuthError); ok {
+		fallback := flow.autoSelected || factor.Id == flow.lastFactorId
+		message := "Authentication Request rejected"
 		if err.Error() == timeoutErrorMessage {
-			fmt.Println("Authentication Timed Out - please reject the current Okta Auth Request on your phone then try again")
+			c.observeFactorResult(flow, api.FactorResultTimeout)
+			message = "Authentication Timed Out - please reject the current Okta Auth Request on your phone then try again"
+		} else {
+			c.observeFactorResult(flow, api.FactorResultRejected)
 		}
-		c.sendTransactionRequest(newTransaction.Links.Cancel.HREF, &verifyReq)
+		if fallback {
+			return c.cancelCurrentFactorWithErrorMessage(flow, newTransaction, message)
+		}
+		c.presentUserError(flow, message)
+		c.sendLinkRequest(flow.ctx, newTransaction.Links, "cancel", &verifyReq)
 		return "", err
 	}
+	if err == errRacedCodeCancelled {
+		c.observeFactorResult(flow, api.FactorResultCancelled)
+		return c.cancelCurrentFactorWithErrorMessage(flow, newTransaction, "Cancelled")
+	}
 	if err != nil {
-		return c.cancelCurrentFactorWithErrorMessage(transaction, err.Error())
+		c.observeFactorResult(flow, api.FactorResultError)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, err.Error())
 	}
-	return c.handleAuthUserFlow(newTransaction, false)
+	c.observeFactorResult(flow, api.FactorResultSuccess)
+	return c.handleAuthUserFlow(flow, newTransaction, false)
 }
 
 // Given a url and a pointer to a struct, serializes the request to JSON and POSTs it to the given url.
 // If the status code is 200, returns a new AuthenticationTransaction.
 // If the status code is 4xx returns an APIError.
 // For any other error condition (5xx, JSON marshaling, etc) returns a TerminalError
-func (c *OktaClient) sendTransactionRequest(url string, request interface{}) (api.AuthenticationTransaction, *api.APIError, error) {
+func (c *OktaClient) sendTransactionRequest(ctx context.Context, url string, request interface{}) (api.AuthenticationTransaction, *api.APIError, error) {
 	transaction := api.AuthenticationTransaction{}
-	status, body, err := c.sendRequest(http.MethodPost, url, request)
+	logger := c.requestLogger(http.MethodPost, url)
+
+	status, body, err := c.sendRequest(ctx, http.MethodPost, url, nil, request)
 	if err != nil {
-		// Don't log the AuthenticationRequest, as that will contain a password
-		if _, ok := request.(*api.AuthenticationRequest); ok {
-			c.log("Got error sending transaction request: error: %s", request)
-		} else {
-			c.log("Got error sending transaction request: request %#+v, error: %s", request, err)
-		}
+		logger.Error("Got error sending transaction request", slog.String("request", c.redactor.object(request)), errorLogAttr(err))
 		return transaction, nil, TerminalError(err.Error())
 	}
+	logger = logger.With(slog.Int(LogKeyStatusCode, status))
 
 	if status == http.StatusOK {
 		err = json.Unmarshal(body, &transaction)
 		if err != nil {
-			c.log("Got error unmarshaling authentication transaction: body %q, error %s", string(body), err)
+			logger.Error("Got error unmarshaling authentication transaction", slog.String("body", c.redactor.body(body)), errorLogAttr(err))
 			return transaction, nil, TerminalError(unexpectedErrorMessage)
 		}
 		return transaction, nil, nil
 	}
 
 	if status == http.StatusTooManyRequests {
+		logger.Warn("Rate limited by Okta")
 		return transaction, nil, TerminalError("Too many requests to Okta, try again later")
 	}
 
@@ -393,50 +519,137 @@ func (c *OktaClient) sendTransactionRequest(url string, request interface{}) (ap
 		apiError := api.APIError{}
 		err = json.Unmarshal(body, &apiError)
 		if err != nil {
-			c.log("Got error unmarshaling api error: body %q, error %s", string(body), err)
+			logger.Error("Got error unmarshaling api error", slog.String("body", c.redactor.body(body)), errorLogAttr(err))
 			return transaction, nil, TerminalError(unexpectedErrorMessage)
 		}
+		logger.Warn("Got api error", slog.String("error_code", apiError.ErrorCode), slog.String(LogKeyError, apiError.ErrorSummary))
 		return transaction, &apiError, nil
 	}
 
-	c.log("Got unexpected server status code: body %q, status %d", string(body), status)
+	logger.Error("Got unexpected server status code", slog.String("body", c.redactor.body(body)))
 	return transaction, nil, TerminalError(unexpectedErrorMessage)
 }
 
+// POSTs the request to the link for the relation, see api.Links.Lookup.
+// Returns a TerminalError without sending the request if Okta didn't return
+// the link, or it doesn't accept a POST.
+func (c *OktaClient) sendLinkRequest(ctx context.Context, links api.Links, rel string, request interface{}) (api.AuthenticationTransaction, *api.APIError, error) {
+	link := links.Lookup(rel)
+	if link == nil || link.HREF == "" {
+		c.logger.Error("Missing link in authentication transaction", slog.String("link", rel))
+		return api.AuthenticationTransaction{}, nil, TerminalError(fmt.Sprintf("Okta didn't return a %s link for the transaction.", rel))
+	}
+	if !link.Allows(http.MethodPost) {
+		c.logger.Error("Link doesn't allow POST", slog.String("link", rel), slog.Any("allow", link.Hints.Allow))
+		return api.AuthenticationTransaction{}, nil, TerminalError(fmt.Sprintf("Okta doesn't allow a POST to the %s link.", rel))
+	}
+	return c.sendTransactionRequest(ctx, link.HREF, request)
+}
+
 // Sends an http request to with the given method and url, serializing the body to json.
+// The body is empty if nil, and the header is added to the request if set.
 // Returns the resulting status code, the body, or an error if the request failed.
-func (c *OktaClient) sendRequest(method, url string, body interface{}) (int, []byte, error) {
-	c.log("Sending http request %s %s", method, url)
+func (c *OktaClient) sendRequest(ctx context.Context, method, url string, header http.Header, body interface{}) (int, []byte, error) {
+	logger := c.requestLogger(method, url)
 
-	requestBytes, err := json.Marshal(body)
-	if err != nil {
-		c.log("Error marshaling body for request %s %s: %s", method, url, err)
-		return 0, nil, err
+	var requestBytes []byte
+	if body != nil {
+		var err error
+		requestBytes, err = json.Marshal(body)
+		if err != nil {
+			logger.Error("Error marshaling request body", errorLogAttr(err))
+			return 0, nil, err
+		}
 	}
 
-	request, err := http.NewRequest(method, url, bytes.NewBuffer(requestBytes))
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(requestBytes))
 	if err != nil {
-		c.log("Error creating request %s %s: %s", method, url, err)
+		logger.Error("Error creating request", errorLogAttr(err))
 		return 0, nil, err
 	}
-	request.Header.Set("Content-Type", "application/json")
-	response, err := c.httpClient.Do(request)
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	request.Header.Set("Accept", "application/json")
+	if requestBytes != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	logger.Debug("Sending http request",
+		slog.Any("headers", c.redactor.header(request.Header)),
+		slog.String("body", c.redactor.body(requestBytes)))
+
+	ctx, span := c.startRequestSpan(ctx, request)
+	request = request.WithContext(ctx)
 
+	start := time.Now()
+	response, err := c.httpClient.Do(request)
 	if err != nil {
-		c.log("Error sending request %s %s: %s", method, url, err)
+		err = redactURLError(err)
+		endRequestSpan(span, nil, err)
+		logger.Error("Error sending request", latencyLogAttr(start), errorLogAttr(err))
 		return 0, nil, err
 	}
 
 	defer response.Body.Close()
 	bodyBytes, err := ioutil.ReadAll(response.Body)
+	endRequestSpan(span, response, err)
 	if err != nil {
+		logger.Error("Error reading response body", latencyLogAttr(start), errorLogAttr(err))
 		return 0, nil, err
 	}
 
-	c.log("Got http response: status %d, body %q", response.StatusCode, string(bodyBytes))
+	logger.Debug("Got http response",
+		slog.Int(LogKeyStatusCode, response.StatusCode),
+		latencyLogAttr(start),
+		slog.String(LogKeyRequestId, response.Header.Get(oktaRequestIdHeader)),
+		slog.Any("headers", c.redactor.header(response.Header)),
+		slog.String("body", c.redactor.body(bodyBytes)))
 	return response.StatusCode, bodyBytes, nil
 }
 
+// Returns the factor that can be started without asking the user, trying the
+// last factor first if FactorPolicy.AutoSelectLast is set, and then the preferred
+// factors in order. Security keys are only chosen when the device is present.
+func (c *OktaClient) autoSelectFactor(flow *authFlow, allowed api.Factors) (api.Factor, bool) {
+	candidates := allowed
+	if c.factorPolicy.AutoSelectLast {
+		candidates = withLastFactorFirst(allowed, flow.lastFactorId)
+	}
+	for _, factor := range candidates {
+		last := c.factorPolicy.AutoSelectLast && factor.Id == flow.lastFactorId
+		if !last && !c.factorPolicy.prefers(factor) {
+			continue
+		}
+		if factor.FactorType != factors.FactorTypeU2F && factor.FactorType != factors.FactorTypeWebAuthN {
+			return factor, true
+		}
+		request, ok := c.presenceRequest(factor)
+		if ok && c.checkU2FPresence(flow, request) {
+			return factor, true
+		}
+	}
+	if c.factorPolicy.AutoSelectSingle && len(allowed) == 1 {
+		return allowed[0], true
+	}
+	return api.Factor{}, false
+}
+
+// Returns the request to check whether a U2F or WebAuthn factor's device is
+// present. Returns false for other factors, and security keys Okta didn't
+// return a profile for, which can't be checked.
+func (c *OktaClient) presenceRequest(factor api.Factor) (VerifyU2FRequest, bool) {
+	switch profile := factor.Profile.(type) {
+	case api.FactorProfileU2F:
+		return u2fProfileToChallenge(c.domain, "", profile), factor.FactorType == factors.FactorTypeU2F
+	case api.FactorProfileWebAuthN:
+		return webAuthNProfileToChallenge(c.domain, "", profile), factor.FactorType == factors.FactorTypeWebAuthN
+	}
+	if factor.FactorType == factors.FactorTypeU2F || factor.FactorType == factors.FactorTypeWebAuthN {
+		c.logger.Warn("Security key factor has no profile", factorLogAttrs(factor)...)
+	}
+	return VerifyU2FRequest{}, false
+}
+
 func u2fProfileToChallenge(facet, challenge string, profile api.FactorProfileU2F) VerifyU2FRequest {
 	return VerifyU2FRequest{
 		AppId:     profile.AppId,
@@ -460,36 +673,7 @@ func apiFactorsToPublicFactors(facs []api.Factor) []factors.Factor {
 	re := make([]factors.Factor, 0, len(facs))
 
 	for _, f := range facs {
-		re = append(re, apiFactorToPublicFactor(f))
-	}
-	return re
-}
-
-func apiFactorToPublicFactor(factor api.Factor) factors.Factor {
-	re := factors.Factor{
-		Id:         factor.Id,
-		FactorType: factor.FactorType,
-		Provider:   factor.Provider,
-	}
-
-	switch profile := factor.Profile.(type) {
-	case api.FactorProfileQuestion:
-		re.ProfileQuestion = &factors.ProfileQuestion{
-			QuestionText: profile.QuestionText,
-		}
-	case api.FactorProfileSMS:
-		re.ProfileSMS = &factors.ProfileSMS{
-			PhoneNumber: profile.PhoneNumber,
-		}
-	case api.FactorProfileCall:
-		re.ProfileCall = &factors.ProfileCall{
-			PhoneNumber:    profile.PhoneNumber,
-			PhoneExtension: profile.PhoneExtension,
-		}
-	case api.FactorProfileToken:
-		re.ProfileToken = &factors.ProfileToken{
-			CredentialId: profile.CredentialId,
-		}
+		re = append(re, f.Public())
 	}
 	return re
 }
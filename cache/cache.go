@@ -0,0 +1,330 @@
+package cache
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version of the file format, which is authenticated with the encrypted data.
+const fileVersion = 1
+
+// Info for deriving the encryption key, so the secret can't be confused with a
+// key for any other purpose.
+const keyInfo = "okta-auth cache v1"
+
+// Identifies a cached value.
+type Key struct {
+	// The Okta domain the value was issued by.
+	Domain string
+	// The user the value was issued to.
+	User string
+	// What the value is for, ex: "session", or the OIDC client and scopes of tokens.
+	Audience string
+}
+
+func (k Key) String() string {
+	return k.Domain + "\n" + k.User + "\n" + k.Audience
+}
+
+// Configures a Cache.
+type Config struct {
+	// The cache file. Its directory is created if it doesn't exist.
+	Path string
+
+	// The source of the secret the encryption key is derived from.
+	Secret SecretSource
+
+	// Values are treated as expired this long before their expiry, so they aren't
+	// returned just before they expire. Defaults to no leeway.
+	Leeway time.Duration
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// A cache of values stored in an encrypted file.
+//
+// Cache is safe for concurrent use, by goroutines and processes. Values are
+// marshaled as JSON, so only their exported fields are cached.
+type Cache struct {
+	path   string
+	secret SecretSource
+	leeway time.Duration
+	now    func() time.Time
+}
+
+// A cached value and when it expires.
+type entry struct {
+	Value     json.RawMessage `json:"value"`
+	ExpiresAt time.Time       `json:"expiresAt"`
+}
+
+// The encrypted cache file.
+type file struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Returns a cache stored at the path. The file isn't read until it is used.
+func New(conf Config) *Cache {
+	now := conf.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &Cache{path: conf.Path, secret: conf.Secret, leeway: conf.Leeway, now: now}
+}
+
+// Unmarshals the value cached for the key into v, returning false if there isn't
+// one or it has expired.
+func (c *Cache) Get(key Key, v interface{}) (bool, error) {
+	unlock, err := lock(c.path, false)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	entries, err := c.read()
+	if err != nil {
+		return false, err
+	}
+	return c.lookup(entries, key, v)
+}
+
+// Caches v for the key until it expires.
+func (c *Cache) Put(key Key, v interface{}, expiresAt time.Time) error {
+	unlock, err := lock(c.path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := c.read()
+	if err != nil {
+		return err
+	}
+	if err := setEntry(entries, key, v, expiresAt); err != nil {
+		return err
+	}
+	return c.write(entries)
+}
+
+// Evicts the value cached for the key, for example when it is no longer valid.
+// Deleting a key that isn't cached isn't an error.
+func (c *Cache) Delete(key Key) error {
+	unlock, err := lock(c.path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := c.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := entries[key.String()]; !ok {
+		return nil
+	}
+	delete(entries, key.String())
+	return c.write(entries)
+}
+
+// Unmarshals the value cached for the key into v. If there isn't one, it has
+// expired, or valid returns false after it is unmarshaled, create is called to
+// set v and return when it expires, and v is cached.
+//
+// The key is locked until create returns, so concurrent processes fetching the
+// same value wait for the first to create it rather than each creating one.
+// Other keys can be used meanwhile, as the cache itself is only locked while it
+// is read and written. valid may be nil. If create returns an error, it is
+// returned and the key is evicted.
+func (c *Cache) Fetch(key Key, v interface{}, valid func() bool, create func() (time.Time, error)) error {
+	unlockKey, err := lock(c.keyLockPath(key), true)
+	if err != nil {
+		return err
+	}
+	defer unlockKey()
+
+	ok, err := c.Get(key, v)
+	if err != nil {
+		return err
+	}
+	if ok && (valid == nil || valid()) {
+		return nil
+	}
+
+	expiresAt, err := create()
+	if err != nil {
+		if ok {
+			c.Delete(key)
+		}
+		return err
+	}
+	return c.Put(key, v, expiresAt)
+}
+
+// Returns the path locked while a value is created for the key, which is beside
+// the cache and named after a hash of the key, so the key isn't revealed.
+func (c *Cache) keyLockPath(key Key) string {
+	hash := sha256.Sum256([]byte(key.String()))
+	return c.path + "." + hex.EncodeToString(hash[:8])
+}
+
+func (c *Cache) lookup(entries map[string]entry, key Key, v interface{}) (bool, error) {
+	e, ok := entries[key.String()]
+	if !ok || c.expired(e) {
+		return false, nil
+	}
+	if err := json.Unmarshal(e.Value, v); err != nil {
+		return false, fmt.Errorf("cache: unmarshaling value: %s", err)
+	}
+	return true, nil
+}
+
+func (c *Cache) expired(e entry) bool {
+	return !c.now().Add(c.leeway).Before(e.ExpiresAt)
+}
+
+func setEntry(entries map[string]entry, key Key, v interface{}, expiresAt time.Time) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cache: marshaling value: %s", err)
+	}
+	entries[key.String()] = entry{Value: value, ExpiresAt: expiresAt}
+	return nil
+}
+
+// Returns the entries in the cache file. A file that is missing, or can't be
+// decrypted because the secret changed, is treated as empty, and is replaced
+// when the cache is next written.
+func (c *Cache) read() (map[string]entry, error) {
+	entries := map[string]entry{}
+	b, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+	if err := json.Unmarshal(b, &f); err != nil || f.Version != fileVersion {
+		return entries, nil
+	}
+	aead, err := c.aead(f.Salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Nonce) != aead.NonceSize() {
+		return entries, nil
+	}
+	plaintext, err := aead.Open(nil, f.Nonce, f.Data, additionalData())
+	if err != nil {
+		return entries, nil
+	}
+	if err := json.Unmarshal(plaintext, &entries); err != nil {
+		return map[string]entry{}, nil
+	}
+	return entries, nil
+}
+
+// Encrypts the entries that haven't expired, and replaces the cache file with them.
+func (c *Cache) write(entries map[string]entry) error {
+	for key, e := range entries {
+		if c.expired(e) {
+			delete(entries, key)
+		}
+	}
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// A new salt and nonce are used each time the file is written
+	f := file{Version: fileVersion, Salt: make([]byte, 16)}
+	if _, err := rand.Read(f.Salt); err != nil {
+		return err
+	}
+	aead, err := c.aead(f.Salt)
+	if err != nil {
+		return err
+	}
+	f.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(f.Nonce); err != nil {
+		return err
+	}
+	f.Data = aead.Seal(nil, f.Nonce, plaintext, additionalData())
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFile(c.path, b)
+}
+
+func (c *Cache) aead(salt []byte) (cipher.AEAD, error) {
+	if c.secret == nil {
+		return nil, errors.New("cache: no secret source configured")
+	}
+	secret, err := c.secret.Secret()
+	if err != nil {
+		return nil, fmt.Errorf("cache: getting secret: %s", err)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("cache: the secret is empty")
+	}
+	block, err := aes.NewCipher(deriveKey(secret, salt))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func additionalData() []byte {
+	return []byte(fmt.Sprintf("%s %d", keyInfo, fileVersion))
+}
+
+// Derives a 256 bit key from the secret and salt with HKDF-SHA256 (RFC 5869).
+// One block of output is all that's needed, so expanding is a single HMAC.
+func deriveKey(secret, salt []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(keyInfo))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// Writes the file, readable only by the user, by renaming a temporary file so it
+// is never partially written.
+func writeFile(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
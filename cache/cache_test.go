@@ -0,0 +1,302 @@
+package cache_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wearefair/okta-auth/cache"
+)
+
+var (
+	sessionKey = cache.Key{Domain: "example.okta.com", User: "first@example.com", Audience: "session"}
+	tokensKey  = cache.Key{Domain: "example.okta.com", User: "first@example.com", Audience: "oidc:client"}
+)
+
+type session struct {
+	Id string
+}
+
+// A clock the test controls.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newCache(t *testing.T, secret string) (*cache.Cache, *clock, string) {
+	path := filepath.Join(t.TempDir(), "okta-auth", "cache")
+	clk := &clock{now: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	c := cache.New(cache.Config{Path: path, Secret: cache.StaticSecret([]byte(secret)), Leeway: time.Minute, Now: clk.Now})
+	return c, clk, path
+}
+
+func TestGetPut(t *testing.T) {
+	c, clk, path := newCache(t, "secret")
+
+	var s session
+	if ok, err := c.Get(sessionKey, &s); ok || err != nil {
+		t.Fatalf("expected a miss, got %v, %v", ok, err)
+	}
+	if err := c.Put(sessionKey, session{Id: "102abc"}, clk.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.Get(sessionKey, &s); !ok || err != nil || s.Id != "102abc" {
+		t.Fatalf("expected a hit, got %v, %v, %#+v", ok, err, s)
+	}
+	if ok, _ := c.Get(tokensKey, &s); ok {
+		t.Error("expected other keys to miss")
+	}
+
+	// The file is only readable by the user, and doesn't contain the value
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %s", info.Mode())
+	}
+	b, _ := ioutil.ReadFile(path)
+	if bytes.Contains(b, []byte("102abc")) || bytes.Contains(b, []byte("first@example.com")) {
+		t.Errorf("expected the file to be encrypted, got %s", b)
+	}
+
+	// Values expire, less the leeway
+	clk.Advance(time.Hour - time.Minute)
+	if ok, _ := c.Get(sessionKey, &s); ok {
+		t.Error("expected the value to have expired")
+	}
+
+	if err := c.Put(sessionKey, session{Id: "102def"}, clk.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete(sessionKey); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := c.Get(sessionKey, &s); ok {
+		t.Error("expected the value to be deleted")
+	}
+	if err := c.Delete(sessionKey); err != nil {
+		t.Errorf("expected deleting a missing key to succeed, got %v", err)
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	c, clk, path := newCache(t, "secret")
+	if err := c.Put(sessionKey, session{Id: "102abc"}, clk.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// A different secret can't read the cache, so it is treated as empty
+	other := cache.New(cache.Config{Path: path, Secret: cache.StaticSecret([]byte("other")), Now: clk.Now})
+	var s session
+	if ok, err := other.Get(sessionKey, &s); ok || err != nil {
+		t.Errorf("expected a miss, got %v, %v", ok, err)
+	}
+
+	failing := cache.New(cache.Config{Path: path, Secret: cache.SecretFunc(func() ([]byte, error) {
+		return nil, errors.New("locked")
+	})})
+	if _, err := failing.Get(sessionKey, &s); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("expected the secret source's error, got %v", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	c, clk, _ := newCache(t, "secret")
+
+	var creates int32
+	create := func(s *session, id string) func() (time.Time, error) {
+		return func() (time.Time, error) {
+			atomic.AddInt32(&creates, 1)
+			// Slow enough that concurrent fetches would overlap without the lock
+			time.Sleep(10 * time.Millisecond)
+			s.Id = id
+			return clk.Now().Add(time.Hour), nil
+		}
+	}
+
+	// Concurrent fetches wait for the first to create the value
+	var wg sync.WaitGroup
+	ids := make([]string, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var s session
+			if err := c.Fetch(sessionKey, &s, nil, create(&s, "102abc")); err != nil {
+				t.Error(err)
+			}
+			ids[i] = s.Id
+		}(i)
+	}
+	wg.Wait()
+	if creates != 1 {
+		t.Errorf("expected the value to be created once, got %d", creates)
+	}
+	for _, id := range ids {
+		if id != "102abc" {
+			t.Errorf("expected every fetch to get the value, got %v", ids)
+			break
+		}
+	}
+
+	t.Run("invalid values are created again", func(t *testing.T) {
+		var s session
+		valid := func() bool { return s.Id != "102abc" }
+		if err := c.Fetch(sessionKey, &s, valid, create(&s, "102def")); err != nil {
+			t.Fatal(err)
+		}
+		if s.Id != "102def" || creates != 2 {
+			t.Errorf("expected a new value, got %#+v after %d creates", s, creates)
+		}
+	})
+
+	t.Run("failing to create an invalid value evicts it", func(t *testing.T) {
+		var s session
+		err := c.Fetch(sessionKey, &s, func() bool { return false }, func() (time.Time, error) {
+			return time.Time{}, errors.New("failed")
+		})
+		if err == nil || err.Error() != "failed" {
+			t.Errorf("expected the error, got %v", err)
+		}
+		if ok, _ := c.Get(sessionKey, &s); ok {
+			t.Error("expected the value to be evicted")
+		}
+	})
+}
+
+// Creating a value only locks its key, so a slow create, like a user answering
+// an MFA prompt, doesn't hold up other keys.
+func TestFetchOtherKeys(t *testing.T) {
+	c, clk, _ := newCache(t, "secret")
+
+	creating := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		var s session
+		done <- c.Fetch(sessionKey, &s, nil, func() (time.Time, error) {
+			close(creating)
+			<-release
+			s.Id = "102abc"
+			return clk.Now().Add(time.Hour), nil
+		})
+	}()
+	<-creating
+
+	var other session
+	err := c.Fetch(tokensKey, &other, nil, func() (time.Time, error) {
+		other.Id = "102def"
+		return clk.Now().Add(time.Hour), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Both values were written, neither write replaced the other
+	for key, id := range map[cache.Key]string{sessionKey: "102abc", tokensKey: "102def"} {
+		var s session
+		if ok, err := c.Get(key, &s); !ok || err != nil || s.Id != id {
+			t.Errorf("expected %s for %s, got %#+v, %v", id, key.Audience, s, err)
+		}
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "cache.key")
+	source := cache.KeyFile(path)
+
+	first, err := source.Secret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 32 {
+		t.Errorf("expected a 256 bit secret, got %d bytes", len(first))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %s", info.Mode())
+	}
+
+	second, err := cache.KeyFile(path).Secret()
+	if err != nil || !bytes.Equal(first, second) {
+		t.Errorf("expected the same secret, got %x, %v", second, err)
+	}
+}
+
+// Runs the test binary as other processes fetching the same value, to check the
+// file lock is held across processes.
+func TestFetchProcesses(t *testing.T) {
+	if path := os.Getenv("CACHE_TEST_PATH"); path != "" {
+		c := cache.New(cache.Config{Path: path, Secret: cache.StaticSecret([]byte("secret"))})
+		var s session
+		err := c.Fetch(sessionKey, &s, nil, func() (time.Time, error) {
+			f, err := os.OpenFile(path+".creates", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				return time.Time{}, err
+			}
+			defer f.Close()
+			f.WriteString("x")
+			time.Sleep(50 * time.Millisecond)
+			s.Id = "102abc"
+			return time.Now().Add(time.Hour), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	if testing.Short() {
+		t.Skip("starts processes")
+	}
+
+	path := filepath.Join(t.TempDir(), "cache")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := exec.Command(os.Args[0], "-test.run=^TestFetchProcesses$")
+			cmd.Env = append(os.Environ(), "CACHE_TEST_PATH="+path)
+			if output, err := cmd.CombinedOutput(); err != nil {
+				t.Errorf("%s: %s", err, output)
+			}
+		}()
+	}
+	wg.Wait()
+
+	creates, err := ioutil.ReadFile(path + ".creates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creates) != 1 {
+		t.Errorf("expected one process to create the value, got %d", len(creates))
+	}
+}
@@ -0,0 +1,29 @@
+// Caches credentials on disk between processes, so each invocation of a tool
+// doesn't authenticate with Okta again.
+//
+// Values such as session ids, OIDC tokens and cloud credentials are stored per
+// Key, with an expiry after which they are evicted. The cache is a single file,
+// only readable by the user, that is encrypted with AES-256-GCM using a key
+// derived from a SecretSource. Processes take an advisory lock on the file, so
+// concurrent processes don't corrupt it, and Fetch locks the key while creating
+// a missing value so only one of them authenticates:
+//
+//	c := cache.New(cache.Config{
+//		Path:   filepath.Join(dir, "okta-auth", "cache"),
+//		Secret: cache.KeyFile(filepath.Join(dir, "okta-auth", "cache.key")),
+//	})
+//
+//	var tokens okta.Tokens
+//	key := cache.Key{Domain: "example.okta.com", User: "first@example.com", Audience: "oidc:0oa1abcdefghijklmnop"}
+//	err := c.Fetch(key, &tokens, nil, func() (time.Time, error) {
+//		t, err := client.Tokens(ctx, request)
+//		if err != nil {
+//			return time.Time{}, err
+//		}
+//		tokens = *t
+//		return t.Expiry, nil
+//	})
+//
+// Locking uses flock on Unix. On other platforms the file isn't locked, but is
+// still replaced atomically.
+package cache
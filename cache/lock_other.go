@@ -0,0 +1,9 @@
+//go:build !unix
+
+package cache
+
+// Files aren't locked on this platform. Writes are still atomic, but concurrent
+// processes may each create a value, and the last to write wins.
+func lock(path string, exclusive bool) (unlock func(), err error) {
+	return func() {}, nil
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Provides the secret the cache's encryption key is derived from. It must
+// return the same secret each time, or the cache is emptied.
+//
+// Implementations can fetch the secret from an OS keychain, a secrets manager, or
+// derive it from a hardware token.
+type SecretSource interface {
+	Secret() ([]byte, error)
+}
+
+// Adapts a function to a SecretSource.
+type SecretFunc func() ([]byte, error)
+
+func (f SecretFunc) Secret() ([]byte, error) {
+	return f()
+}
+
+// Returns a SecretSource that always returns the secret.
+func StaticSecret(secret []byte) SecretSource {
+	return SecretFunc(func() ([]byte, error) {
+		return secret, nil
+	})
+}
+
+// Returns a SecretSource that reads the secret from an environment variable.
+func EnvSecret(name string) SecretSource {
+	return SecretFunc(func() ([]byte, error) {
+		secret := os.Getenv(name)
+		if secret == "" {
+			return nil, errors.New(name + " isn't set")
+		}
+		return []byte(secret), nil
+	})
+}
+
+// Returns a SecretSource that reads a random secret from the file, creating it
+// only readable by the user if it doesn't exist.
+//
+// This protects the cache from being read if it is copied without the key file,
+// for example in a backup that excludes it, but not from other programs the user
+// runs.
+func KeyFile(path string) SecretSource {
+	return SecretFunc(func() ([]byte, error) {
+		if secret, err := readKeyFile(path); err == nil || !os.IsNotExist(err) {
+			return secret, err
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		dir := filepath.Dir(path)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, err
+		}
+		f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+"-*")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.WriteString(base64.StdEncoding.EncodeToString(secret) + "\n"); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+
+		// Linking fails if another process created the key first, in which case
+		// its key is used
+		if err := os.Link(f.Name(), path); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+		return readKeyFile(path)
+	})
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, errors.New("invalid key file " + path)
+	}
+	return secret, nil
+}
@@ -3,13 +3,18 @@ package okta
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/wearefair/okta-auth/factors"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Interface for logging debug logs.
+// For structured logs see ClientConfig.LogHandler.
 type DebugLogger interface {
 	Log(string)
 }
@@ -26,7 +31,45 @@ type ClientConfig struct {
 	RoundTripper http.RoundTripper
 
 	// Optional logger that when provided enables debug logs.
+	// Ignored when LogHandler is set.
 	DebugLogger DebugLogger
+
+	// Optional handler that when provided receives structured, leveled logs.
+	// Records carry attributes for the transaction status, factor, and HTTP
+	// request (see the LogKey constants).
+	LogHandler slog.Handler
+
+	// JSON field names whose values are scrubbed from request and response bodies
+	// before they are logged, in addition to DefaultRedactedFields.
+	RedactedFields []string
+
+	// HTTP headers whose values are scrubbed before they are logged, in addition
+	// to DefaultRedactedHeaders.
+	RedactedHeaders []string
+
+	// Default fields and headers that are logged as is, ex: "login" to log
+	// usernames. Only use this for values that are safe to write to the logs.
+	UnredactedFields  []string
+	UnredactedHeaders []string
+
+	// Optional observer that is notified as authentication flows progress.
+	Observer Observer
+
+	// Optional provider used to trace authentication flows.
+	// Defaults to the global provider, see otel.SetTracerProvider.
+	TracerProvider trace.TracerProvider
+
+	// Optional propagator used to add the trace context to requests to Okta.
+	// Defaults to the global propagator, see otel.SetTextMapPropagator.
+	Propagator propagation.TextMapPropagator
+
+	// Restricts which factors the user may verify, and which are chosen
+	// automatically. Defaults to allowing every supported factor.
+	FactorPolicy FactorPolicy
+
+	// Optional store of the factor each user last verified, which is listed first
+	// when the user chooses a factor.
+	FactorStore FactorStore
 }
 
 // Parameters used for authenticating with a U2F device.
@@ -58,6 +101,9 @@ type Prompts interface {
 	// Given a list of factors, should present the user with the choices and
 	// return the chosen factor. If an error is returned the authentication flow
 	// is aborted.
+	//
+	// The first factor is the default, which is the factor the user last verified
+	// when there is a FactorStore.
 	ChooseFactor(factors []factors.Factor) (factors.Factor, error)
 
 	// Called when there is a (retriable) error in the flow that should be presented to the user.
@@ -76,12 +122,59 @@ type Prompts interface {
 	VerifyPush()
 }
 
+// Prompts can optionally implement ContextPrompts to show the user what they are
+// authenticating for, ex: "Signing in to AWS Prod".
+type ContextPrompts interface {
+	// Called once per authentication, before the user is first asked to choose
+	// or verify a factor.
+	PresentContext(PromptContext)
+}
+
+// Prompts can optionally implement CancelableCodePrompts so the user can be asked
+// for a code while waiting for a push, see FactorPolicy.RacePushWithTOTP.
+type CancelableCodePrompts interface {
+	// Like VerifyCode, but is called concurrently with the flow, and must return
+	// promptly when the context is cancelled, ex: when the push is approved first.
+	// VerifyPush has already been called for the push.
+	VerifyCodeContext(ctx context.Context, factor factors.Factor) (string, error)
+}
+
+// Prompts can optionally implement DuoPrompts to let the user choose how to
+// verify a Duo Security factor. Otherwise a Duo Push is sent.
+type DuoPrompts interface {
+	// Returns DuoFactorPush or DuoFactorPasscode. For a passcode, VerifyCode is
+	// then called with the same factor. Other values fail the authentication.
+	ChooseDuoFactor(factor factors.Factor) (DuoFactor, error)
+}
+
+// What the user is authenticating for, and the policy that applies.
+type PromptContext struct {
+	// The name of the app the user is signing in to, ex: amazon_aws, and its
+	// label, ex: AWS Prod. Blank unless the authentication was started by an app.
+	TargetName  string
+	TargetLabel string
+
+	// Whether the MFA policy allows Okta to remember the device, so the user
+	// isn't asked to verify a factor again for the lifetime. Only offer to
+	// remember the device when it is allowed.
+	AllowRememberDevice     bool
+	RememberDeviceByDefault bool
+	RememberDeviceLifetime  time.Duration
+}
+
 type OktaClient struct {
 	domain     string
 	rootURL    string
 	httpClient *http.Client
-	logger     DebugLogger
+	logger     *slog.Logger
+	redactor   redactor
 	prompts    Prompts
+	observer   Observer
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
+	factorPolicy FactorPolicy
+	factorStore  FactorStore
 }
 
 // Constructs a new OktaClient with the given config.
@@ -107,19 +200,24 @@ func New(conf ClientConfig) (*OktaClient, error) {
 		rootURL.Host = rootURL.Path
 	}
 
+	observer := conf.Observer
+	if observer == nil {
+		observer = NopObserver{}
+	}
+
 	return &OktaClient{
-		domain:  rootURL.Host,
-		rootURL: fmt.Sprintf("%s://%s", rootURL.Scheme, rootURL.Host),
-		prompts: conf.Prompts,
-		logger:  conf.DebugLogger,
+		domain:     rootURL.Host,
+		rootURL:    fmt.Sprintf("%s://%s", rootURL.Scheme, rootURL.Host),
+		prompts:    conf.Prompts,
+		observer:   observer,
+		tracer:     newTracer(conf),
+		propagator: newPropagator(conf),
+		logger:     newLogger(conf),
+		redactor:   newRedactor(conf),
 		httpClient: &http.Client{
 			Transport: conf.RoundTripper,
 		},
+		factorPolicy: conf.FactorPolicy,
+		factorStore:  conf.FactorStore,
 	}, nil
 }
-
-func (c *OktaClient) log(formatString string, args ...interface{}) {
-	if c.logger != nil {
-		c.logger.Log(fmt.Sprintf(formatString, args...))
-	}
-}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/wearefair/okta-auth/agent"
+)
+
+func runAgent(e *env, args []string) error {
+	fs := e.newFlagSet("agent", "[-socket path]")
+	flags := addConfigFlags(fs, false)
+	fs.StringVar(&flags.AgentSocket, "socket", "", "path to listen on (default: okta-auth/agent.sock in the user's runtime directory)")
+	conf, err := e.parseConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	clientConf, err := e.clientConfig(conf)
+	if err != nil {
+		return err
+	}
+	server, err := agent.NewServer(agent.Config{Client: clientConf, Username: conf.Username, Password: conf.password})
+	if err != nil {
+		return err
+	}
+
+	path := conf.AgentSocket
+	if path == "" {
+		path = e.defaultAgentSocket()
+	}
+	listener, err := agent.Listen(path)
+	if err != nil {
+		return err
+	}
+	// Closing the listener removes the socket
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Fprintf(e.stderr, "Listening on %s, set OKTA_AUTH_SOCK=%s to use the agent.\n", path, path)
+	if err := server.Serve(listener); !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// Returns the default socket, in the user's runtime directory if there is one,
+// or a directory of the temp directory only the user can access.
+func (e *env) defaultAgentSocket() string {
+	if dir := e.getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "okta-auth", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("okta-auth-%d", os.Getuid()), "agent.sock")
+}
+
+// Returns the prompts the agent forwards to this command.
+func (e *env) agentPrompts() agent.Prompts {
+	if e.nonInteractive {
+		return nonInteractivePrompts{out: e.stderr}
+	}
+	return e.terminal()
+}
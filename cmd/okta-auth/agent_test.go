@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/agent"
+)
+
+func TestAgent(t *testing.T) {
+	h := newHarness(t)
+	server, err := agent.NewServer(agent.Config{Client: okta.ClientConfig{OktaDomain: h.server.URL}, Username: username, Password: password})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(h.dir, "agent.sock")
+	listener, err := agent.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go server.Serve(listener)
+	h.environ["OKTA_AUTH_SOCK"] = path
+
+	// The agent's prompts are answered in the command's terminal
+	status, stdout, stderr := h.run("1\n123456\n", "token", "-field", "id_token")
+	if status != 0 || strings.Count(stdout, ".") != 2 {
+		t.Fatalf("expected an id token, got %d: %q %s", status, stdout, stderr)
+	}
+	if !strings.Contains(stderr, "Choose a factor:") {
+		t.Errorf("expected the agent's prompts, got %q", stderr)
+	}
+
+	// Other tokens are issued for the agent's session without authenticating,
+	// even when the command can't prompt
+	h.environ["KUBERNETES_EXEC_INFO"] = execInfo(false, "https://prod.example.com")
+	status, stdout, stderr = h.run("", "kubernetes")
+	if status != 0 || !strings.Contains(stdout, `"token"`) {
+		t.Errorf("expected a credential, got %d: %q %s", status, stdout, stderr)
+	}
+}
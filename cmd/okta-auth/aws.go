@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+)
+
+const (
+	defaultSTSEndpoint = "https://sts.amazonaws.com/"
+
+	// SAML attributes Okta's AWS app sets.
+	// https://docs.aws.amazon.com/IAM/latest/UserGuide/id_roles_providers_create_saml_assertions.html
+	samlAttributeRole            = "https://aws.amazon.com/SAML/Attributes/Role"
+	samlAttributeSessionDuration = "https://aws.amazon.com/SAML/Attributes/SessionDuration"
+)
+
+// Credentials in the format the AWS SDKs expect from a credential_process.
+// https://docs.aws.amazon.com/cli/latest/userguide/cli-configure-sourcing-external.html
+type processCredentials struct {
+	Version         int
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Expiration      time.Time
+}
+
+// A role in the SAML assertion, and the identity provider that can assume it.
+type awsRole struct {
+	RoleArn      string
+	PrincipalArn string
+}
+
+func awsCredentials(e *env, args []string) error {
+	fs := e.newFlagSet("aws", "[-role arn]")
+	flags := addConfigFlags(fs, false)
+	fs.StringVar(&flags.AWSAppURL, "app-url", "", "embed link of the Okta AWS app")
+	fs.StringVar(&flags.AWSRole, "role", "", "ARN of the role to assume (default: asks if the app has several)")
+	fs.IntVar(&flags.AWSSessionDuration, "duration", 0, "seconds the credentials are valid for (default: the app's session duration)")
+	conf, err := e.parseConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	if conf.AWSAppURL == "" {
+		return usageError("the AWS app's embed link is required, set awsAppURL in the config file, OKTA_AWS_APP_URL, or -app-url")
+	}
+
+	// Only the credentials may be written to stdout
+	defer e.useTTY()()
+	client, err := e.newClient(conf)
+	if err != nil {
+		return err
+	}
+	session, err := e.loadSession(conf)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	credentials := &processCredentials{}
+	var stale bool
+	audience := strings.Join([]string{"aws", conf.AWSAppURL, conf.AWSRole}, "\n")
+	err = e.credentialCache(conf).Fetch(cacheKey(conf, audience), credentials, nil, func() (time.Time, error) {
+		var assertion string
+		stale, err = e.authorize(ctx, client, conf, session, func(sessionToken, sessionId string) error {
+			assertion, err = client.SAMLAssertion(ctx, okta.SAMLRequest{AppURL: conf.AWSAppURL, SessionToken: sessionToken, SessionId: sessionId})
+			return err
+		})
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		roles, duration, err := parseAWSAssertion(assertion)
+		if err != nil {
+			return time.Time{}, err
+		}
+		role, err := e.chooseRole(roles, conf.AWSRole)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if conf.AWSSessionDuration != 0 {
+			duration = conf.AWSSessionDuration
+		}
+
+		endpoint := conf.STSEndpoint
+		if endpoint == "" {
+			endpoint = defaultSTSEndpoint
+		}
+		assumed, err := assumeRoleWithSAML(ctx, endpoint, role, assertion, duration)
+		if err != nil {
+			return time.Time{}, err
+		}
+		*credentials = *assumed
+		return credentials.Expiration, nil
+	})
+	if stale {
+		e.evictSession(conf)
+	}
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(e.stdout).Encode(credentials)
+}
+
+// Returns the roles in the base64 encoded SAML response, and the session duration
+// in seconds if the app sets it.
+func parseAWSAssertion(assertion string) ([]awsRole, int, error) {
+	b, err := base64.StdEncoding.DecodeString(assertion)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid SAML response: %s", err)
+	}
+	var response struct {
+		Attributes []struct {
+			Name   string   `xml:"Name,attr"`
+			Values []string `xml:"AttributeValue"`
+		} `xml:"Assertion>AttributeStatement>Attribute"`
+	}
+	if err := xml.Unmarshal(b, &response); err != nil {
+		return nil, 0, fmt.Errorf("invalid SAML response: %s", err)
+	}
+
+	var roles []awsRole
+	var duration int
+	for _, attribute := range response.Attributes {
+		switch attribute.Name {
+		case samlAttributeRole:
+			// Each value is a role and provider ARN pair, in either order
+			for _, value := range attribute.Values {
+				var role awsRole
+				for _, arn := range strings.Split(strings.TrimSpace(value), ",") {
+					if strings.Contains(arn, ":role/") {
+						role.RoleArn = arn
+					} else if strings.Contains(arn, ":saml-provider/") {
+						role.PrincipalArn = arn
+					}
+				}
+				if role.RoleArn != "" && role.PrincipalArn != "" {
+					roles = append(roles, role)
+				}
+			}
+		case samlAttributeSessionDuration:
+			if len(attribute.Values) > 0 {
+				duration, _ = strconv.Atoi(strings.TrimSpace(attribute.Values[0]))
+			}
+		}
+	}
+	if len(roles) == 0 {
+		return nil, 0, fmt.Errorf("the SAML response has no AWS roles, check the user is assigned a role in the app")
+	}
+	return roles, duration, nil
+}
+
+// Returns the role with the ARN, or asks the user to choose one if the ARN is
+// blank and there are several.
+func (e *env) chooseRole(roles []awsRole, roleArn string) (awsRole, error) {
+	if roleArn != "" {
+		for _, role := range roles {
+			if role.RoleArn == roleArn {
+				return role, nil
+			}
+		}
+		return awsRole{}, fmt.Errorf("the user can't assume %s with the app", roleArn)
+	}
+	if len(roles) == 1 {
+		return roles[0], nil
+	}
+
+	out := e.promptOutput()
+	fmt.Fprintln(out, "Choose a role:")
+	for i, role := range roles {
+		fmt.Fprintf(out, "  %d. %s\n", i+1, role.RoleArn)
+	}
+	for {
+		line, err := e.terminal().ReadLine("Enter a number [1]: ")
+		if err != nil {
+			return awsRole{}, err
+		}
+		if line == "" {
+			return roles[0], nil
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(roles) {
+			return roles[n-1], nil
+		}
+		fmt.Fprintf(out, "Enter a number between 1 and %d.\n", len(roles))
+	}
+}
+
+// Exchanges the SAML response for temporary credentials for the role. The request
+// isn't signed, as the assertion authenticates it.
+// https://docs.aws.amazon.com/STS/latest/APIReference/API_AssumeRoleWithSAML.html
+func assumeRoleWithSAML(ctx context.Context, endpoint string, role awsRole, assertion string, duration int) (*processCredentials, error) {
+	form := url.Values{
+		"Action":        {"AssumeRoleWithSAML"},
+		"Version":       {"2011-06-15"},
+		"RoleArn":       {role.RoleArn},
+		"PrincipalArn":  {role.PrincipalArn},
+		"SAMLAssertion": {assertion},
+	}
+	if duration > 0 {
+		form.Set("DurationSeconds", strconv.Itoa(duration))
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var stsError struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if err := xml.Unmarshal(body, &stsError); err != nil || stsError.Code == "" {
+			return nil, fmt.Errorf("STS returned status %d", response.StatusCode)
+		}
+		return nil, fmt.Errorf("STS returned %s: %s", stsError.Code, stsError.Message)
+	}
+
+	var result struct {
+		Credentials struct {
+			AccessKeyId     string
+			SecretAccessKey string
+			SessionToken    string
+			Expiration      time.Time
+		} `xml:"AssumeRoleWithSAMLResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("invalid STS response: %s", err)
+	}
+	return &processCredentials{
+		Version:         1,
+		AccessKeyId:     result.Credentials.AccessKeyId,
+		SecretAccessKey: result.Credentials.SecretAccessKey,
+		SessionToken:    result.Credentials.SessionToken,
+		Expiration:      result.Credentials.Expiration.UTC(),
+	}, nil
+}
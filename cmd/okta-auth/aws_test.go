@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wearefair/okta-auth/oktatest"
+)
+
+const (
+	developerRole = "arn:aws:iam::123456789012:role/Developer"
+	adminRole     = "arn:aws:iam::123456789012:role/Admin"
+	samlProvider  = "arn:aws:iam::123456789012:saml-provider/Okta"
+)
+
+// A stand-in for STS's AssumeRoleWithSAML, recording the requests it receives.
+type fakeSTS struct {
+	*httptest.Server
+	expiration time.Time
+
+	mu       sync.Mutex
+	requests []map[string]string
+}
+
+func newFakeSTS(t *testing.T) *fakeSTS {
+	sts := &fakeSTS{expiration: time.Now().Add(time.Hour).UTC().Truncate(time.Second)}
+	sts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sts.mu.Lock()
+		sts.requests = append(sts.requests, map[string]string{
+			"RoleArn":         r.PostForm.Get("RoleArn"),
+			"PrincipalArn":    r.PostForm.Get("PrincipalArn"),
+			"DurationSeconds": r.PostForm.Get("DurationSeconds"),
+		})
+		sts.mu.Unlock()
+
+		if r.PostForm.Get("Action") != "AssumeRoleWithSAML" || r.PostForm.Get("SAMLAssertion") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>InvalidAction</Code><Message>Could not find operation</Message></Error></ErrorResponse>`)
+			return
+		}
+		if r.PostForm.Get("RoleArn") == adminRole {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>Not authorized to perform sts:AssumeRoleWithSAML</Message></Error></ErrorResponse>`)
+			return
+		}
+		fmt.Fprintf(w, `<AssumeRoleWithSAMLResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithSAMLResult>
+    <Credentials>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithSAMLResult>
+</AssumeRoleWithSAMLResponse>`, sts.expiration.Format(time.RFC3339))
+	}))
+	t.Cleanup(sts.Close)
+	return sts
+}
+
+func (s *fakeSTS) Requests() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]string(nil), s.requests...)
+}
+
+func newAWSHarness(t *testing.T, roles ...string) (*harness, *fakeSTS) {
+	h := newHarness(t)
+	sts := newFakeSTS(t)
+	appURL := h.server.AddSAMLApp(&oktatest.SAMLApp{
+		Name:   "amazon_aws",
+		ACSURL: "https://signin.aws.amazon.com/saml",
+		Attributes: map[string][]string{
+			"https://aws.amazon.com/SAML/Attributes/Role":            roles,
+			"https://aws.amazon.com/SAML/Attributes/SessionDuration": {"7200"},
+		},
+	})
+	h.writeConfig(map[string]interface{}{
+		"domain":       h.server.URL,
+		"awsAppURL":    appURL,
+		"stsEndpoint":  sts.URL,
+		"cacheFile":    filepath.Join(h.dir, "cache", "cache"),
+		"cacheKeyFile": filepath.Join(h.dir, "cache.key"),
+	})
+	h.environ["OKTA_USERNAME"] = username
+	h.environ["OKTA_PASSWORD"] = password
+	return h, sts
+}
+
+func TestAWS(t *testing.T) {
+	h, sts := newAWSHarness(t, samlProvider+","+developerRole)
+
+	status, stdout, stderr := h.run("1\n123456\n", "aws")
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+
+	// Exactly the document the AWS SDKs expect is printed
+	var document map[string]interface{}
+	if err := json.Unmarshal([]byte(stdout), &document); err != nil {
+		t.Fatalf("expected only JSON on stdout, got %q", stdout)
+	}
+	expected := map[string]interface{}{
+		"Version":         float64(1),
+		"AccessKeyId":     "ASIAEXAMPLE",
+		"SecretAccessKey": "secret",
+		"SessionToken":    "token",
+		"Expiration":      sts.expiration.Format(time.RFC3339),
+	}
+	if !reflect.DeepEqual(document, expected) {
+		t.Errorf("expected %v, got %v", expected, document)
+	}
+	if !strings.Contains(stderr, "Choose a factor:") {
+		t.Errorf("expected prompts on stderr, got %q", stderr)
+	}
+
+	requests := sts.Requests()
+	if len(requests) != 1 || requests[0]["RoleArn"] != developerRole || requests[0]["PrincipalArn"] != samlProvider || requests[0]["DurationSeconds"] != "7200" {
+		t.Errorf("unexpected STS requests %v", requests)
+	}
+
+	// The credentials are cached, so the user isn't asked again
+	status, cached, stderr := h.run("", "aws")
+	if status != 0 || cached != stdout || stderr != "" {
+		t.Errorf("expected the cached credentials, got %d: %q %q", status, cached, stderr)
+	}
+	if len(sts.Requests()) != 1 {
+		t.Error("expected the cached credentials not to be requested again")
+	}
+}
+
+func TestAWSExpiringCredentials(t *testing.T) {
+	h, sts := newAWSHarness(t, developerRole+","+samlProvider)
+	sts.expiration = time.Now().Add(expiryWindow / 2).UTC().Truncate(time.Second)
+
+	for i := 0; i < 2; i++ {
+		if status, _, stderr := h.run("1\n123456\n", "aws"); status != 0 {
+			t.Fatalf("expected success, got %d: %s", status, stderr)
+		}
+	}
+	if len(sts.Requests()) != 2 {
+		t.Errorf("expected credentials expiring soon to be refreshed, got %d requests", len(sts.Requests()))
+	}
+}
+
+func TestAWSChooseRole(t *testing.T) {
+	h, sts := newAWSHarness(t, samlProvider+","+adminRole, samlProvider+","+developerRole)
+
+	status, _, stderr := h.run("1\n123456\n3\n2\n", "aws", "-duration", "900")
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	expected := "Choose a role:\n" +
+		"  1. " + adminRole + "\n" +
+		"  2. " + developerRole + "\n" +
+		"Enter a number [1]: Enter a number between 1 and 2.\n" +
+		"Enter a number [1]: "
+	if !strings.HasSuffix(stderr, expected) {
+		t.Errorf("expected the roles to be listed, got %q", stderr)
+	}
+	requests := sts.Requests()
+	if len(requests) != 1 || requests[0]["RoleArn"] != developerRole || requests[0]["DurationSeconds"] != "900" {
+		t.Errorf("unexpected STS requests %v", requests)
+	}
+
+	t.Run("configured role the user can't assume", func(t *testing.T) {
+		status, stdout, stderr := h.run("1\n123456\n", "aws", "-role", "arn:aws:iam::123456789012:role/Other")
+		if status != 1 || stdout != "" || !strings.Contains(stderr, "can't assume") {
+			t.Errorf("expected an error, got %d: %q %q", status, stdout, stderr)
+		}
+	})
+
+	t.Run("STS error", func(t *testing.T) {
+		status, stdout, stderr := h.run("1\n123456\n", "aws", "-role", adminRole)
+		if status != 1 || stdout != "" || !strings.Contains(stderr, "AccessDenied") {
+			t.Errorf("expected an error, got %d: %q %q", status, stdout, stderr)
+		}
+	})
+}
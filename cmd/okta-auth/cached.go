@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/agent"
+	"github.com/wearefair/okta-auth/cache"
+)
+
+// Cached values are refreshed when they expire within this long, so the program
+// using them doesn't get credentials that expire mid-request.
+const expiryWindow = 5 * time.Minute
+
+// The audience sessions are cached under.
+const sessionAudience = "session"
+
+// A session cached by login -cache, so later commands don't authenticate again.
+type cachedSession struct {
+	Id        string    `json:"id"`
+	Login     string    `json:"login"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// The audience the factor each user last verified is cached under, and how long
+// it is remembered for.
+const (
+	lastFactorAudience = "lastFactor"
+	lastFactorLifetime = 90 * 24 * time.Hour
+)
+
+// Returns the cache of sessions and credentials.
+func (e *env) credentialCache(conf *config) *cache.Cache {
+	if e.cache == nil {
+		e.cache = cache.New(cache.Config{Path: conf.CacheFile, Secret: cacheSecret(conf), Leeway: expiryWindow})
+	}
+	return e.cache
+}
+
+func cacheSecret(conf *config) cache.SecretSource {
+	if conf.cacheSecret != "" {
+		return cache.StaticSecret([]byte(conf.cacheSecret))
+	}
+	return cache.KeyFile(conf.CacheKeyFile)
+}
+
+// Returns the store of the factor each user last verified, which is kept in the
+// credential cache.
+func factorStore(conf *config) okta.FactorStore {
+	return cachedFactorStore{cache.New(cache.Config{Path: conf.CacheFile, Secret: cacheSecret(conf)})}
+}
+
+type cachedFactorStore struct {
+	cache *cache.Cache
+}
+
+func (s cachedFactorStore) LastFactor(domain, username string) (string, error) {
+	var factorId string
+	_, err := s.cache.Get(cache.Key{Domain: domain, User: username, Audience: lastFactorAudience}, &factorId)
+	return factorId, err
+}
+
+func (s cachedFactorStore) SetLastFactor(domain, username, factorId string) error {
+	key := cache.Key{Domain: domain, User: username, Audience: lastFactorAudience}
+	return s.cache.Put(key, factorId, time.Now().Add(lastFactorLifetime))
+}
+
+// Returns the key values for the audience are cached under, for the user from
+// env.username. Okta logins aren't case sensitive, so neither is the key.
+func cacheKey(conf *config, audience string) cache.Key {
+	return cache.Key{Domain: normalizeDomain(conf.Domain), User: strings.ToLower(conf.Username), Audience: audience}
+}
+
+// Returns the audience of the configured OIDC client's tokens.
+func oidcAudience(conf *config) string {
+	return strings.Join([]string{"oidc", conf.Issuer, conf.ClientId, strings.Join(conf.Scopes, " ")}, "\n")
+}
+
+// Returns the user's cached session, or nil if there isn't one or it has expired.
+// The user is asked for their username first if it isn't configured.
+func (e *env) loadSession(conf *config) (*cachedSession, error) {
+	if _, err := e.username(conf); err != nil {
+		return nil, err
+	}
+	cached := &cachedSession{}
+	ok, err := e.credentialCache(conf).Get(cacheKey(conf, sessionAudience), cached)
+	if !ok || err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+func (e *env) saveSession(conf *config, session *okta.Session) error {
+	return e.credentialCache(conf).Put(cacheKey(conf, sessionAudience), cachedSession{
+		Id:        session.Id,
+		Login:     session.Login,
+		ExpiresAt: session.ExpiresAt,
+	}, session.ExpiresAt)
+}
+
+func (e *env) evictSession(conf *config) error {
+	return e.credentialCache(conf).Delete(cacheKey(conf, sessionAudience))
+}
+
+// Calls f with the cached session's id, or if there isn't one, a session token
+// from authenticating the user. If f fails with the cached session, it may have
+// been closed or expired in Okta, so the user authenticates and f is called again.
+//
+// If an agent is configured, f is called with its session instead, and the
+// agent authenticates the user if it needs to.
+//
+// f is called while the fetched key is locked, so the session is loaded before,
+// and the returned stale flag is set if it should be evicted afterwards.
+func (e *env) authorize(ctx context.Context, client *okta.OktaClient, conf *config, session *cachedSession, f func(sessionToken, sessionId string) error) (stale bool, err error) {
+	if conf.AgentSocket != "" {
+		session, err := agent.NewClient(conf.AgentSocket, e.agentPrompts()).Session(ctx)
+		if err != nil {
+			return false, err
+		}
+		return false, f("", session.Id)
+	}
+	if session != nil {
+		if err := f("", session.Id); err == nil {
+			return false, nil
+		}
+		stale = true
+	}
+	sessionToken, err := e.authenticate(ctx, client, conf)
+	if err != nil {
+		return stale, err
+	}
+	return stale, f(sessionToken, "")
+}
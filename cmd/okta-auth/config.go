@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	okta "github.com/wearefair/okta-auth"
+)
+
+// Settings for the command. Each is read from the config file, and overridden by
+// its environment variable and then its flag when they are set.
+//
+// The config file is JSON, at the path given by -config or OKTA_AUTH_CONFIG,
+// defaulting to okta-auth/config.json in the user's config directory:
+//
+//	{
+//	  "domain": "example.okta.com",
+//	  "username": "first@example.com",
+//	  "clientId": "0oa1abcdefghijklmnop",
+//	  "redirectURI": "http://localhost:8080/callback",
+//	  "scopes": ["openid", "profile"]
+//	}
+type config struct {
+	// OKTA_DOMAIN, -domain
+	Domain string `json:"domain"`
+	// OKTA_USERNAME, -username
+	Username string `json:"username"`
+	// The cache of sessions and credentials. Defaults to okta-auth/cache in the
+	// user's cache directory.
+	CacheFile string `json:"cacheFile"`
+	// The key file the cache's encryption key is derived from, unless
+	// OKTA_AUTH_CACHE_SECRET is set. Defaults to okta-auth/cache.key in the user's
+	// config directory, and is created if it doesn't exist.
+	CacheKeyFile string `json:"cacheKeyFile"`
+	// The socket of an agent to get the session from, instead of caching it.
+	// OKTA_AUTH_SOCK
+	AgentSocket string `json:"agentSocket"`
+	// The factors that are denied and chosen automatically, ex:
+	// {"deny": [{"factorType": "sms"}, {"factorType": "call"}], "autoSelectLast": true}
+	// The factor each user last verified is cached, and is the default choice.
+	FactorPolicy okta.FactorPolicy `json:"factorPolicy"`
+
+	// OIDC settings for the token command.
+	// OKTA_CLIENT_ID, -client-id
+	ClientId string `json:"clientId"`
+	// OKTA_ISSUER, -issuer
+	Issuer string `json:"issuer"`
+	// OKTA_REDIRECT_URI, -redirect-uri
+	RedirectURI string `json:"redirectURI"`
+	// OKTA_SCOPES, -scopes, separated by spaces or commas.
+	Scopes []string `json:"scopes"`
+
+	// AWS settings for the aws command.
+	// The AWS app's embed link. OKTA_AWS_APP_URL, -app-url
+	AWSAppURL string `json:"awsAppURL"`
+	// The ARN of the role to assume, which the user is asked to choose if blank
+	// and the app has several. OKTA_AWS_ROLE, -role
+	AWSRole string `json:"awsRole"`
+	// Seconds the credentials are valid for. Defaults to the app's session
+	// duration, or an hour. -duration
+	AWSSessionDuration int `json:"awsSessionDuration"`
+	// Defaults to https://sts.amazonaws.com/
+	STSEndpoint string `json:"stsEndpoint"`
+
+	// Only read from OKTA_PASSWORD, so passwords aren't written to config files
+	// or visible in the process list.
+	password string
+	// Only read from OKTA_AUTH_CACHE_SECRET, to derive the cache's key from a
+	// secret managed outside the file system.
+	cacheSecret string
+	// -debug
+	debug bool
+}
+
+// Flags shared by all commands, and the config they override.
+type configFlags struct {
+	path   string
+	scopes string
+	config
+}
+
+// Adds the flags for the config to the flag set. The OIDC flags are only added
+// when oidc is set.
+func addConfigFlags(fs *flag.FlagSet, oidc bool) *configFlags {
+	f := &configFlags{}
+	fs.StringVar(&f.path, "config", "", "path to the config file")
+	fs.StringVar(&f.Domain, "domain", "", "Okta domain, ex: example.okta.com")
+	fs.StringVar(&f.Username, "username", "", "username to authenticate as")
+	fs.BoolVar(&f.debug, "debug", false, "log requests to stderr")
+	if oidc {
+		fs.StringVar(&f.ClientId, "client-id", "", "OIDC client id")
+		fs.StringVar(&f.Issuer, "issuer", "", "authorization server issuer (default https://<domain>/oauth2/default)")
+		fs.StringVar(&f.RedirectURI, "redirect-uri", "", "redirect uri registered for the client")
+		fs.StringVar(&f.scopes, "scopes", "", "scopes to request (default openid)")
+	}
+	return f
+}
+
+// Returns a flag set for the command that writes its errors and usage to stderr.
+func (e *env) newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "Usage: okta-auth %s %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Parses the flags, and returns the config with the environment and flags applied.
+func (e *env) parseConfig(fs *flag.FlagSet, f *configFlags, args []string) (*config, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := f.path
+	if path == "" {
+		path = e.getenv("OKTA_AUTH_CONFIG")
+	}
+	conf, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf.merge(config{
+		Domain:      e.getenv("OKTA_DOMAIN"),
+		Username:    e.getenv("OKTA_USERNAME"),
+		ClientId:    e.getenv("OKTA_CLIENT_ID"),
+		Issuer:      e.getenv("OKTA_ISSUER"),
+		RedirectURI: e.getenv("OKTA_REDIRECT_URI"),
+		Scopes:      splitScopes(e.getenv("OKTA_SCOPES")),
+		AWSAppURL:   e.getenv("OKTA_AWS_APP_URL"),
+		AWSRole:     e.getenv("OKTA_AWS_ROLE"),
+		AgentSocket: e.getenv("OKTA_AUTH_SOCK"),
+		password:    e.getenv("OKTA_PASSWORD"),
+		cacheSecret: e.getenv("OKTA_AUTH_CACHE_SECRET"),
+	})
+	f.Scopes = splitScopes(f.scopes)
+	conf.merge(f.config)
+
+	if conf.CacheFile == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		conf.CacheFile = filepath.Join(dir, "okta-auth", "cache")
+	}
+	if conf.CacheKeyFile == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil, err
+		}
+		conf.CacheKeyFile = filepath.Join(dir, "okta-auth", "cache.key")
+	}
+	return conf, nil
+}
+
+// Returns a usage error if the OIDC client isn't configured.
+func (c *config) requireOIDC() error {
+	if c.ClientId == "" || c.RedirectURI == "" {
+		return usageError("a client id and redirect uri are required, set clientId and redirectURI in the config file, OKTA_CLIENT_ID and OKTA_REDIRECT_URI, or -client-id and -redirect-uri")
+	}
+	return nil
+}
+
+// Reads the config file at the path. If the path is blank the default config
+// file is read, if it exists.
+func loadConfigFile(path string) (*config, error) {
+	conf := &config{}
+	explicit := path != ""
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return conf, nil
+		}
+		path = filepath.Join(dir, "okta-auth", "config.json")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return conf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, conf); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+	return conf, nil
+}
+
+// Overrides the settings with those that are set in other.
+func (c *config) merge(other config) {
+	mergeString(&c.Domain, other.Domain)
+	mergeString(&c.Username, other.Username)
+	mergeString(&c.CacheFile, other.CacheFile)
+	mergeString(&c.CacheKeyFile, other.CacheKeyFile)
+	mergeString(&c.AgentSocket, other.AgentSocket)
+	mergeString(&c.ClientId, other.ClientId)
+	mergeString(&c.Issuer, other.Issuer)
+	mergeString(&c.RedirectURI, other.RedirectURI)
+	mergeString(&c.AWSAppURL, other.AWSAppURL)
+	mergeString(&c.AWSRole, other.AWSRole)
+	mergeString(&c.STSEndpoint, other.STSEndpoint)
+	mergeString(&c.password, other.password)
+	mergeString(&c.cacheSecret, other.cacheSecret)
+	if other.AWSSessionDuration != 0 {
+		c.AWSSessionDuration = other.AWSSessionDuration
+	}
+	if len(other.Scopes) > 0 {
+		c.Scopes = other.Scopes
+	}
+	c.debug = c.debug || other.debug
+}
+
+func mergeString(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+func splitScopes(scopes string) []string {
+	return strings.FieldsFunc(scopes, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
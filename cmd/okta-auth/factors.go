@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/wearefair/okta-auth/prompts/term"
+)
+
+func listFactors(e *env, args []string) error {
+	fs := e.newFlagSet("factors", "")
+	flags := addConfigFlags(fs, false)
+	conf, err := e.parseConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	client, err := e.newClient(conf)
+	if err != nil {
+		return err
+	}
+	username, password, err := e.credentials(conf)
+	if err != nil {
+		return err
+	}
+
+	enrolled, err := client.ListFactors(context.Background(), username, password)
+	if err != nil {
+		return err
+	}
+	if len(enrolled) == 0 {
+		fmt.Fprintf(e.stderr, "%s has no factors enrolled.\n", username)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTYPE\tPROVIDER\tDESCRIPTION")
+	for _, factor := range enrolled {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", factor.Id, factor.FactorType, factor.Provider, term.Describe(factor))
+	}
+	return tw.Flush()
+}
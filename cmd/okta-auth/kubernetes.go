@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// The version of the exec credential protocol that is supported.
+// https://kubernetes.io/docs/reference/access-authn-authz/authentication/#client-go-credential-plugins
+const execCredentialAPIVersion = "client.authentication.k8s.io/v1"
+
+// Returned instead of prompting when kubectl can't pass the terminal to the plugin.
+var errNotInteractive = errors.New("authentication requires prompting, but kubectl isn't running interactively; run okta-auth login -cache first")
+
+// The request and response of the exec credential protocol. The request is read
+// from KUBERNETES_EXEC_INFO, and the response is written to stdout.
+type execCredential struct {
+	APIVersion string                `json:"apiVersion"`
+	Kind       string                `json:"kind"`
+	Spec       *execCredentialSpec   `json:"spec,omitempty"`
+	Status     *execCredentialStatus `json:"status,omitempty"`
+}
+
+type execCredentialSpec struct {
+	Interactive bool `json:"interactive"`
+	// Only set if the kubeconfig sets provideClusterInfo.
+	Cluster *struct {
+		Server string `json:"server"`
+	} `json:"cluster,omitempty"`
+}
+
+type execCredentialStatus struct {
+	ExpirationTimestamp time.Time `json:"expirationTimestamp"`
+	Token               string    `json:"token"`
+}
+
+func kubernetes(e *env, args []string) error {
+	fs := e.newFlagSet("kubernetes", "[-cluster name]")
+	flags := addConfigFlags(fs, true)
+	cluster := fs.String("cluster", "", "name the token is cached under (default: the cluster's server, if kubectl provides it)")
+	conf, err := e.parseConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	if err := conf.requireOIDC(); err != nil {
+		return err
+	}
+
+	// kubectl always sets the exec info. When run by hand, the user is asked as usual.
+	spec := &execCredentialSpec{Interactive: true}
+	if info := e.getenv("KUBERNETES_EXEC_INFO"); info != "" {
+		request := execCredential{}
+		if err := json.Unmarshal([]byte(info), &request); err != nil {
+			return fmt.Errorf("invalid KUBERNETES_EXEC_INFO: %s", err)
+		}
+		if request.APIVersion != execCredentialAPIVersion {
+			return fmt.Errorf("unsupported exec credential version %q, set apiVersion: %s in the kubeconfig", request.APIVersion, execCredentialAPIVersion)
+		}
+		if request.Spec != nil {
+			spec = request.Spec
+		}
+	}
+	if *cluster == "" && spec.Cluster != nil {
+		*cluster = spec.Cluster.Server
+	}
+
+	e.nonInteractive = !spec.Interactive
+	client, err := e.newClient(conf)
+	if err != nil {
+		return err
+	}
+	// Tokens are cached per cluster
+	tokens, err := e.tokens(context.Background(), client, conf, oidcAudience(conf)+"\n"+*cluster)
+	if err != nil {
+		return err
+	}
+	if tokens.IdToken == "" {
+		return errors.New("no id token was issued, check the openid scope is requested")
+	}
+
+	return json.NewEncoder(e.stdout).Encode(execCredential{
+		APIVersion: execCredentialAPIVersion,
+		Kind:       "ExecCredential",
+		Status:     &execCredentialStatus{Token: tokens.IdToken, ExpirationTimestamp: idTokenExpiry(tokens)},
+	})
+}
+
+// Returns when the id token expires, from its exp claim. The token isn't verified,
+// as it was just received from the authorization server over TLS.
+func idTokenExpiry(tokens *okta.Tokens) time.Time {
+	parts := strings.Split(tokens.IdToken, ".")
+	if len(parts) == 3 {
+		var claims struct {
+			Exp int64 `json:"exp"`
+		}
+		if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil && json.Unmarshal(payload, &claims) == nil && claims.Exp > 0 {
+			return time.Unix(claims.Exp, 0).UTC()
+		}
+	}
+	return tokens.Expiry.UTC()
+}
+
+// Prompts that fail instead of asking the user, for when there's no terminal.
+// Errors are still shown, so the user can tell why authentication failed.
+type nonInteractivePrompts struct {
+	out io.Writer
+}
+
+func (p nonInteractivePrompts) CheckU2FPresence(okta.VerifyU2FRequest) bool {
+	return false
+}
+
+func (p nonInteractivePrompts) ChooseFactor([]factors.Factor) (factors.Factor, error) {
+	return factors.Factor{}, errNotInteractive
+}
+
+func (p nonInteractivePrompts) PresentUserError(message string) {
+	fmt.Fprintf(p.out, "Error: %s\n", strings.TrimSpace(message))
+}
+
+func (p nonInteractivePrompts) VerifyU2F(context.Context, okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+	return okta.VerifyU2FResponse{}, errNotInteractive
+}
+
+func (p nonInteractivePrompts) VerifyCode(factors.Factor) (string, error) {
+	return "", errNotInteractive
+}
+
+func (p nonInteractivePrompts) VerifyPush() {}
+
+func (p nonInteractivePrompts) ReadLine(string) (string, error) {
+	return "", errNotInteractive
+}
+
+func (p nonInteractivePrompts) ReadSecret(string) (string, error) {
+	return "", errNotInteractive
+}
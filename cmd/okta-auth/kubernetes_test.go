@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func execInfo(interactive bool, server string) string {
+	return `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential","spec":{"interactive":` +
+		strconv.FormatBool(interactive) + `,"cluster":{"server":"` + server + `"}}}`
+}
+
+func TestKubernetes(t *testing.T) {
+	h := newHarness(t)
+	h.environ["OKTA_USERNAME"] = username
+	h.environ["OKTA_PASSWORD"] = password
+	h.environ["KUBERNETES_EXEC_INFO"] = execInfo(true, "https://prod.example.com")
+
+	status, stdout, stderr := h.run("1\n123456\n", "kubernetes")
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	var credential execCredential
+	if err := json.Unmarshal([]byte(stdout), &credential); err != nil {
+		t.Fatalf("expected only JSON on stdout, got %q", stdout)
+	}
+	if credential.APIVersion != execCredentialAPIVersion || credential.Kind != "ExecCredential" || credential.Status == nil {
+		t.Fatalf("unexpected credential %s", stdout)
+	}
+	if strings.Count(credential.Status.Token, ".") != 2 {
+		t.Errorf("expected an id token, got %q", credential.Status.Token)
+	}
+	if expiry := time.Until(credential.Status.ExpirationTimestamp); expiry < 50*time.Minute || expiry > time.Hour {
+		t.Errorf("expected the id token's expiry, got %s", credential.Status.ExpirationTimestamp)
+	}
+
+	// The token is cached for the cluster, even when kubectl isn't interactive
+	h.environ["KUBERNETES_EXEC_INFO"] = execInfo(false, "https://prod.example.com")
+	status, cached, stderr := h.run("", "kubernetes")
+	if status != 0 || cached != stdout {
+		t.Errorf("expected the cached token, got %d: %q %s", status, cached, stderr)
+	}
+
+	t.Run("another cluster when not interactive", func(t *testing.T) {
+		h.environ["KUBERNETES_EXEC_INFO"] = execInfo(false, "https://staging.example.com")
+		status, stdout, stderr := h.run("1\n123456\n", "kubernetes")
+		if status != 1 || stdout != "" || !strings.Contains(stderr, errNotInteractive.Error()) {
+			t.Errorf("expected to fail without prompting, got %d: %q %q", status, stdout, stderr)
+		}
+		if strings.Contains(stderr, "Choose a factor") {
+			t.Errorf("expected no prompts, got %q", stderr)
+		}
+	})
+
+	t.Run("another cluster with a cached session", func(t *testing.T) {
+		delete(h.environ, "KUBERNETES_EXEC_INFO")
+		if status, _, stderr := h.run("1\n123456\n", "login", "-cache"); status != 0 {
+			t.Fatalf("expected success, got %d: %s", status, stderr)
+		}
+		h.environ["KUBERNETES_EXEC_INFO"] = execInfo(false, "https://staging.example.com")
+		status, stdout, stderr := h.run("", "kubernetes")
+		if status != 0 || stdout == cached {
+			t.Errorf("expected a new token for the cluster, got %d: %q %s", status, stdout, stderr)
+		}
+	})
+}
+
+func TestKubernetesUnsupportedVersion(t *testing.T) {
+	h := newHarness(t)
+	h.environ["KUBERNETES_EXEC_INFO"] = `{"apiVersion":"client.authentication.k8s.io/v1beta1","kind":"ExecCredential","spec":{}}`
+	status, _, stderr := h.run("", "kubernetes")
+	if status != 1 || !strings.Contains(stderr, "unsupported exec credential version") {
+		t.Errorf("expected an error, got %d: %s", status, stderr)
+	}
+}
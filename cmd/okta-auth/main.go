@@ -0,0 +1,290 @@
+// Command okta-auth authenticates with Okta from the command line.
+//
+// Usage:
+//
+//	okta-auth login [-cache]             Authenticate, and print the session token or cache a session
+//	okta-auth session show|refresh|logout Manage the cached session
+//	okta-auth factors                    List the user's enrolled factors
+//	okta-auth token [-field name]        Print OIDC tokens for the user
+//	okta-auth aws [-role arn]            Print AWS credentials for a credential_process
+//	okta-auth kubernetes [-cluster name] Print an ExecCredential for a kubectl credential plugin
+//	okta-auth agent [-socket path]       Hold a session, and share it with the other commands
+//
+// Settings are read from a JSON config file, then environment variables, then
+// flags, see config. The user is prompted on the terminal for anything missing,
+// and to verify a factor.
+//
+// To use AWS credentials from an Okta AWS app, set the app's embed link as
+// awsAppURL in the config file, and add a profile to ~/.aws/config:
+//
+//	[profile okta]
+//	credential_process = okta-auth aws -role arn:aws:iam::123456789012:role/Developer
+//
+// Only the credentials are written to stdout. Prompts are shown on the
+// controlling terminal, or stderr if there isn't one, and the credentials are
+// cached until shortly before they expire.
+//
+// To authenticate to Kubernetes clusters that trust the authorization server's
+// id tokens, configure the OIDC client and add a user to the kubeconfig:
+//
+//	users:
+//	- name: okta
+//	  user:
+//	    exec:
+//	      apiVersion: client.authentication.k8s.io/v1
+//	      command: okta-auth
+//	      args: ["kubernetes"]
+//	      interactiveMode: IfAvailable
+//	      provideClusterInfo: true
+//
+// Tokens are cached per cluster. When kubectl can't pass the terminal to the
+// plugin, it fails rather than prompting, unless the username is configured and
+// there is a cached session.
+//
+// Sessions, tokens and AWS credentials are cached in a file only readable by the
+// user, encrypted with a key from cacheKeyFile, or OKTA_AUTH_CACHE_SECRET if it
+// is set. Concurrent invocations, such as kubectl and the AWS SDK refreshing at
+// once, wait for the first to authenticate rather than each prompting. Values
+// are cached for each user, so the username is asked for first if it isn't
+// configured.
+//
+// Instead of caching the session, it can be held by an agent, like ssh-agent:
+//
+//	okta-auth agent -socket $XDG_RUNTIME_DIR/okta-auth/agent.sock &
+//	export OKTA_AUTH_SOCK=$XDG_RUNTIME_DIR/okta-auth/agent.sock
+//
+// The token, aws and kubernetes commands then get the session from the agent,
+// and the user answers its prompts in the terminal of the command that needed
+// it when the agent authenticates again.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/agent"
+	"github.com/wearefair/okta-auth/cache"
+	"github.com/wearefair/okta-auth/prompts/term"
+)
+
+// A subcommand, run with the arguments following its name.
+type command struct {
+	name        string
+	description string
+	run         func(e *env, args []string) error
+}
+
+var commands = []command{
+	{"login", "Authenticate, and print the session token or cache a session", login},
+	{"session", "Show, refresh, or log out of the cached session", session},
+	{"factors", "List the factors enrolled for the user", listFactors},
+	{"token", "Print OIDC tokens for the user", token},
+	{"aws", "Print AWS credentials for a credential_process", awsCredentials},
+	{"kubernetes", "Print an ExecCredential for a kubectl credential plugin", kubernetes},
+	{"agent", "Hold a session, and share it with the other commands", runAgent},
+}
+
+// Returned for invalid arguments, which exit with status 2 rather than 1.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// Where the command reads and writes. Prompts are written to stderr, so stdout
+// only has the command's output and can be captured.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+	// Opens the controlling terminal. Nil if there isn't one.
+	openTTY func() (*os.File, error)
+	// Set if the user can't be prompted, so authentication fails instead.
+	nonInteractive bool
+
+	prompts   *term.Prompts
+	promptOut io.Writer
+	cache     *cache.Cache
+}
+
+func main() {
+	e := &env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, getenv: os.Getenv, openTTY: openTTY}
+	os.Exit(run(e, os.Args[1:]))
+}
+
+// Runs the subcommand named by the first argument, returning the exit status.
+func run(e *env, args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "help" {
+		printUsage(e.stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	for _, c := range commands {
+		if c.name != args[0] {
+			continue
+		}
+		err := c.run(e, args[1:])
+		switch {
+		case err == nil:
+			return 0
+		case errors.Is(err, flag.ErrHelp):
+			return 0
+		}
+		fmt.Fprintf(e.stderr, "okta-auth %s: %s\n", c.name, err)
+		if _, ok := err.(usageError); ok {
+			return 2
+		}
+		return 1
+	}
+
+	fmt.Fprintf(e.stderr, "okta-auth: unknown command %q\n", args[0])
+	printUsage(e.stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: okta-auth <command> [flags]")
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.description)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run okta-auth <command> -h for the command's flags.")
+}
+
+// Returns the client config for the configured domain, without prompts.
+func (e *env) clientConfig(conf *config) (okta.ClientConfig, error) {
+	if conf.Domain == "" {
+		return okta.ClientConfig{}, usageError("an Okta domain is required, set domain in the config file, OKTA_DOMAIN, or -domain")
+	}
+	clientConf := okta.ClientConfig{
+		OktaDomain:   conf.Domain,
+		FactorPolicy: conf.FactorPolicy,
+		FactorStore:  factorStore(conf),
+	}
+	if conf.debug {
+		clientConf.LogHandler = slog.NewTextHandler(e.stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	return clientConf, nil
+}
+
+// Returns a client for the configured domain, prompting on the terminal.
+func (e *env) newClient(conf *config) (*okta.OktaClient, error) {
+	clientConf, err := e.clientConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	if e.nonInteractive {
+		clientConf.Prompts = nonInteractivePrompts{out: e.stderr}
+	} else {
+		e.terminal().Configure(&clientConf)
+	}
+	return okta.New(clientConf)
+}
+
+// Returns the terminal prompts, which are created on first use.
+func (e *env) terminal() *term.Prompts {
+	if e.prompts == nil {
+		e.prompts = term.New(e.stdin, e.stderr)
+	}
+	return e.prompts
+}
+
+// Returns where prompts are written.
+func (e *env) promptOutput() io.Writer {
+	if e.promptOut == nil {
+		return e.stderr
+	}
+	return e.promptOut
+}
+
+// Prompts on the controlling terminal if there is one, for commands run by other
+// programs that may not connect stdin and stderr to it. Returns a function that
+// closes the terminal.
+func (e *env) useTTY() func() {
+	if e.openTTY == nil || e.prompts != nil {
+		return func() {}
+	}
+	tty, err := e.openTTY()
+	if err != nil {
+		return func() {}
+	}
+	e.prompts = term.New(tty, tty)
+	e.promptOut = tty
+	return func() { tty.Close() }
+}
+
+func openTTY() (*os.File, error) {
+	return os.OpenFile("/dev/tty", os.O_RDWR, 0)
+}
+
+// Returns the configured username and password, prompting for any that are missing.
+func (e *env) credentials(conf *config) (string, string, error) {
+	if conf.password == "" && e.nonInteractive {
+		return "", "", errNotInteractive
+	}
+	username, err := e.username(conf)
+	if err != nil {
+		return "", "", err
+	}
+
+	password := conf.password
+	if password == "" {
+		if password, err = e.terminal().ReadSecret(fmt.Sprintf("Password for %s: ", username)); err != nil {
+			return "", "", err
+		}
+	}
+	return username, password, nil
+}
+
+// Returns the configured username, or the user of the agent's session if an agent
+// is configured, prompting for it otherwise. The username is kept in the config,
+// so it is only asked for once, and values are cached for that user.
+func (e *env) username(conf *config) (string, error) {
+	if conf.Username != "" {
+		return conf.Username, nil
+	}
+	if conf.AgentSocket != "" {
+		session, err := agent.NewClient(conf.AgentSocket, e.agentPrompts()).Session(context.Background())
+		if err != nil {
+			return "", err
+		}
+		conf.Username = session.Login
+		return conf.Username, nil
+	}
+	if e.nonInteractive {
+		return "", errNotInteractive
+	}
+
+	username, err := e.terminal().ReadLine("Username: ")
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return "", usageError("a username is required")
+	}
+	conf.Username = username
+	return username, nil
+}
+
+// Authenticates the user, verifying a factor if required, and returns the session token.
+func (e *env) authenticate(ctx context.Context, client *okta.OktaClient, conf *config) (string, error) {
+	username, password, err := e.credentials(conf)
+	if err != nil {
+		return "", err
+	}
+	return client.AuthenticateContext(ctx, username, password)
+}
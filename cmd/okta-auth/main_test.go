@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/oktatest"
+)
+
+const (
+	username = "first@example.com"
+	password = "correct-horse-battery-staple"
+)
+
+// Runs the command against a fake org, with the config file and environment.
+type harness struct {
+	t       *testing.T
+	server  *oktatest.Server
+	dir     string
+	environ map[string]string
+}
+
+func newHarness(t *testing.T) *harness {
+	server := oktatest.NewServer()
+	t.Cleanup(server.Close)
+	server.AddUser(&oktatest.User{
+		Login:    username,
+		Password: password,
+		Factors:  []*oktatest.Factor{oktatest.SMSFactor("+1 XXX-XXX-5555", "123456")},
+	})
+	server.AddClient(&oktatest.Client{Id: "cli", RedirectURIs: []string{"http://localhost:8080/callback"}})
+
+	h := &harness{t: t, server: server, dir: t.TempDir()}
+	h.writeConfig(map[string]interface{}{
+		"domain":       server.URL,
+		"clientId":     "cli",
+		"redirectURI":  "http://localhost:8080/callback",
+		"cacheFile":    filepath.Join(h.dir, "cache", "cache"),
+		"cacheKeyFile": filepath.Join(h.dir, "cache.key"),
+	})
+	h.environ = map[string]string{"OKTA_AUTH_CONFIG": filepath.Join(h.dir, "config.json")}
+	return h
+}
+
+func (h *harness) writeConfig(conf map[string]interface{}) {
+	b, err := json.Marshal(conf)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(h.dir, "config.json"), b, 0600); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// Runs the command with the input, returning its exit status, stdout and stderr.
+func (h *harness) run(input string, args ...string) (int, string, string) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	e := &env{
+		stdin:  strings.NewReader(input),
+		stdout: stdout,
+		stderr: stderr,
+		getenv: func(key string) string { return h.environ[key] },
+	}
+	status := run(e, args)
+	return status, stdout.String(), stderr.String()
+}
+
+func TestLogin(t *testing.T) {
+	h := newHarness(t)
+
+	status, stdout, stderr := h.run(username+"\n"+password+"\n1\n123456\n", "login")
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	if strings.TrimSpace(stdout) == "" {
+		t.Error("expected the session token to be printed")
+	}
+	if !strings.Contains(stderr, "Username: Password for first@example.com: Choose a factor:") {
+		t.Errorf("expected prompts on stderr, got %q", stderr)
+	}
+
+	// The password from the environment isn't prompted for
+	h.environ["OKTA_USERNAME"] = username
+	h.environ["OKTA_PASSWORD"] = "wrong"
+	if status, _, stderr := h.run("", "login"); status != 1 || !strings.Contains(stderr, "Failed to authenticate") {
+		t.Errorf("expected authentication to fail, got %d: %s", status, stderr)
+	}
+}
+
+func TestFactorPolicy(t *testing.T) {
+	h := newHarness(t)
+	h.environ["OKTA_USERNAME"] = username
+	h.environ["OKTA_PASSWORD"] = password
+	conf := map[string]interface{}{
+		"domain":       h.server.URL,
+		"cacheFile":    filepath.Join(h.dir, "cache", "cache"),
+		"cacheKeyFile": filepath.Join(h.dir, "cache.key"),
+		"factorPolicy": map[string]interface{}{"autoSelectSingle": true},
+	}
+
+	// The only factor is chosen without asking
+	h.writeConfig(conf)
+	status, _, stderr := h.run("123456\n", "login")
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	if strings.Contains(stderr, "Choose a factor") {
+		t.Errorf("expected the factor to be chosen automatically, got %q", stderr)
+	}
+
+	// The factor is remembered, so it is chosen again
+	conf["factorPolicy"] = map[string]interface{}{"autoSelectLast": true}
+	h.writeConfig(conf)
+	status, _, stderr = h.run("123456\n", "login")
+	if status != 0 || strings.Contains(stderr, "Choose a factor") {
+		t.Errorf("expected the last factor to be chosen automatically, got %d: %q", status, stderr)
+	}
+
+	conf["factorPolicy"] = map[string]interface{}{"deny": []map[string]string{{"factorType": "sms"}}}
+	h.writeConfig(conf)
+	if status, _, stderr := h.run("", "login"); status != 1 || !strings.Contains(stderr, "No allowed MFA types found") {
+		t.Errorf("expected SMS to be denied, got %d: %s", status, stderr)
+	}
+}
+
+func TestSession(t *testing.T) {
+	h := newHarness(t)
+	h.environ["OKTA_USERNAME"] = username
+	h.environ["OKTA_PASSWORD"] = password
+
+	status, stdout, stderr := h.run("1\n123456\n", "login", "-cache")
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	if stdout != "" || !strings.Contains(stderr, "Logged in as first@example.com") {
+		t.Errorf("unexpected output %q, %q", stdout, stderr)
+	}
+	cache := filepath.Join(h.dir, "cache", "cache")
+	info, err := os.Stat(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the cache to only be readable by the user, got %s", info.Mode())
+	}
+	if b, _ := ioutil.ReadFile(cache); bytes.Contains(b, []byte(username)) {
+		t.Errorf("expected the cache to be encrypted, got %s", b)
+	}
+
+	var shown okta.Session
+	status, stdout, stderr = h.run("", "session", "show")
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	if err := json.Unmarshal([]byte(stdout), &shown); err != nil || shown.Login != username {
+		t.Errorf("expected the session, got %q", stdout)
+	}
+
+	if status, _, stderr := h.run("", "session", "refresh"); status != 0 {
+		t.Errorf("expected success, got %d: %s", status, stderr)
+	}
+
+	// Tokens are issued for the cached session without authenticating
+	h.environ["OKTA_PASSWORD"] = "wrong"
+	status, stdout, stderr = h.run("", "token", "-field", "id_token")
+	if status != 0 || strings.Count(stdout, ".") != 2 {
+		t.Errorf("expected an id token, got %d: %q %s", status, stdout, stderr)
+	}
+
+	if status, _, stderr := h.run("", "session", "logout"); status != 0 {
+		t.Errorf("expected success, got %d: %s", status, stderr)
+	}
+	if h.server.Session(shown.Id) != nil {
+		t.Error("expected the session to be closed")
+	}
+	if status, _, stderr := h.run("", "session", "show"); status != 1 || !strings.Contains(stderr, okta.ErrSessionNotFound.Error()) {
+		t.Errorf("expected the session not to be found, got %d: %s", status, stderr)
+	}
+}
+
+func TestSessionPromptedUsername(t *testing.T) {
+	h := newHarness(t)
+	h.environ["OKTA_PASSWORD"] = password
+
+	if status, _, stderr := h.run(username+"\n1\n123456\n", "login", "-cache"); status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+
+	// The session is cached for the user who logged in, not whoever runs next
+	status, _, stderr := h.run("second@example.com\n", "session", "show")
+	if status != 1 || !strings.Contains(stderr, okta.ErrSessionNotFound.Error()) {
+		t.Errorf("expected no session for another user, got %d: %s", status, stderr)
+	}
+	status, stdout, stderr := h.run("First@Example.com\n", "session", "show")
+	var shown okta.Session
+	if status != 0 || json.Unmarshal([]byte(stdout), &shown) != nil || shown.Login != username {
+		t.Errorf("expected the user's session, got %d: %q %s", status, stdout, stderr)
+	}
+}
+
+func TestStaleSession(t *testing.T) {
+	h := newHarness(t)
+	h.environ["OKTA_USERNAME"] = username
+	h.environ["OKTA_PASSWORD"] = password
+
+	if status, _, stderr := h.run("1\n123456\n", "login", "-cache"); status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	status, stdout, stderr := h.run("", "session", "show")
+	var shown okta.Session
+	if status != 0 || json.Unmarshal([]byte(stdout), &shown) != nil {
+		t.Fatalf("expected the session, got %d: %q %s", status, stdout, stderr)
+	}
+
+	// Close the session in Okta, leaving it cached
+	request, _ := http.NewRequest(http.MethodDelete, h.server.URL+"/api/v1/sessions/me", nil)
+	request.AddCookie(&http.Cookie{Name: oktatest.SessionCookieName, Value: shown.Id})
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	// The user authenticates again, and the stale session is evicted
+	status, stdout, stderr = h.run("1\n123456\n", "token", "-field", "id_token")
+	if status != 0 || strings.Count(stdout, ".") != 2 {
+		t.Errorf("expected an id token, got %d: %q %s", status, stdout, stderr)
+	}
+	if !strings.Contains(stderr, "Enter a number") {
+		t.Errorf("expected the user to authenticate, got %q", stderr)
+	}
+	if status, _, stderr := h.run("", "session", "show"); status != 1 || !strings.Contains(stderr, okta.ErrSessionNotFound.Error()) {
+		t.Errorf("expected the session not to be found, got %d: %s", status, stderr)
+	}
+}
+
+func TestFactors(t *testing.T) {
+	h := newHarness(t)
+	h.environ["OKTA_PASSWORD"] = password
+
+	// Flags override the environment
+	h.environ["OKTA_USERNAME"] = "unknown@example.com"
+	status, stdout, stderr := h.run("", "factors", "-username", username)
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[1], "SMS to +1 XXX-XXX-5555") {
+		t.Errorf("unexpected factors %q", stdout)
+	}
+}
+
+func TestToken(t *testing.T) {
+	h := newHarness(t)
+	h.environ["OKTA_USERNAME"] = username
+	h.environ["OKTA_PASSWORD"] = password
+	h.environ["OKTA_SCOPES"] = "openid,offline_access"
+
+	status, stdout, stderr := h.run("1\n123456\n", "token")
+	if status != 0 {
+		t.Fatalf("expected success, got %d: %s", status, stderr)
+	}
+	var tokens okta.Tokens
+	if err := json.Unmarshal([]byte(stdout), &tokens); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.AccessToken == "" || tokens.IdToken == "" || tokens.RefreshToken == "" {
+		t.Errorf("unexpected tokens %#+v", tokens)
+	}
+
+	if status, _, _ := h.run("", "token", "-field", "password"); status != 2 {
+		t.Errorf("expected a usage error, got %d", status)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	h := newHarness(t)
+	h.writeConfig(map[string]interface{}{"domain": "config.okta.com", "username": "config", "scopes": []string{"openid"}})
+	h.environ["OKTA_USERNAME"] = "env"
+	h.environ["OKTA_SCOPES"] = "openid profile"
+
+	e := &env{stderr: ioutil.Discard, getenv: func(key string) string { return h.environ[key] }}
+	fs := e.newFlagSet("test", "")
+	flags := addConfigFlags(fs, true)
+	conf, err := e.parseConfig(fs, flags, []string{"-client-id", "flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Domain != "config.okta.com" || conf.Username != "env" || conf.ClientId != "flag" || strings.Join(conf.Scopes, " ") != "openid profile" {
+		t.Errorf("unexpected config %#+v", conf)
+	}
+
+	h.environ["OKTA_AUTH_CONFIG"] = filepath.Join(h.dir, "missing.json")
+	if _, err := e.parseConfig(e.newFlagSet("test", ""), &configFlags{}, nil); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
+
+func TestUsage(t *testing.T) {
+	h := newHarness(t)
+	if status, _, _ := h.run(""); status != 2 {
+		t.Errorf("expected a usage error, got %d", status)
+	}
+	if status, _, stderr := h.run("", "unknown"); status != 2 || !strings.Contains(stderr, `unknown command "unknown"`) {
+		t.Errorf("expected a usage error, got %d: %s", status, stderr)
+	}
+	if status, _, _ := h.run("", "session", "delete"); status != 2 {
+		t.Errorf("expected a usage error, got %d", status)
+	}
+
+	h.writeConfig(map[string]interface{}{})
+	if status, _, stderr := h.run("", "login"); status != 2 || !strings.Contains(stderr, "domain is required") {
+		t.Errorf("expected a usage error, got %d: %s", status, stderr)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+)
+
+func login(e *env, args []string) error {
+	fs := e.newFlagSet("login", "[-cache]")
+	flags := addConfigFlags(fs, false)
+	cache := fs.Bool("cache", false, "create a session and cache it, instead of printing the session token")
+	conf, err := e.parseConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	client, err := e.newClient(conf)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	sessionToken, err := e.authenticate(ctx, client, conf)
+	if err != nil {
+		return err
+	}
+	if !*cache {
+		fmt.Fprintln(e.stdout, sessionToken)
+		return nil
+	}
+
+	session, err := client.CreateSession(ctx, sessionToken)
+	if err != nil {
+		return err
+	}
+	if err := e.saveSession(conf, session); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "Logged in as %s until %s.\n", session.Login, session.ExpiresAt.Local().Format(time.Kitchen))
+	return nil
+}
+
+func session(e *env, args []string) error {
+	fs := e.newFlagSet("session", "show|refresh|logout")
+	flags := addConfigFlags(fs, false)
+	conf, err := e.parseConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageError("expected one of show, refresh, or logout")
+	}
+	action := fs.Arg(0)
+	if action != "show" && action != "refresh" && action != "logout" {
+		return usageError(fmt.Sprintf("unknown action %q, expected one of show, refresh, or logout", action))
+	}
+
+	client, err := e.newClient(conf)
+	if err != nil {
+		return err
+	}
+	cached, err := e.loadSession(conf)
+	if err != nil {
+		return err
+	}
+	if cached == nil {
+		if action == "logout" {
+			return nil
+		}
+		return okta.ErrSessionNotFound
+	}
+
+	ctx := context.Background()
+	switch action {
+	case "show":
+		session, err := client.GetSession(ctx, cached.Id)
+		if err != nil {
+			return e.checkSession(conf, err)
+		}
+		return printJSON(e, session)
+	case "refresh":
+		session, err := client.RefreshSession(ctx, cached.Id)
+		if err != nil {
+			return e.checkSession(conf, err)
+		}
+		if err := e.saveSession(conf, session); err != nil {
+			return err
+		}
+		return printJSON(e, session)
+	default:
+		// The cached session is removed even if Okta already expired it
+		if err := client.CloseSession(ctx, cached.Id); err != nil && err != okta.ErrSessionNotFound {
+			return err
+		}
+		if err := e.evictSession(conf); err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stderr, "Logged out %s.\n", cached.Login)
+		return nil
+	}
+}
+
+// Evicts the cached session if Okta no longer has it, returning err.
+func (e *env) checkSession(conf *config, err error) error {
+	if err == okta.ErrSessionNotFound {
+		e.evictSession(conf)
+	}
+	return err
+}
+
+// Returns the domain without its scheme or trailing slash, so the same org
+// matches however it is configured.
+func normalizeDomain(domain string) string {
+	domain = strings.TrimPrefix(domain, "https://")
+	return strings.TrimSuffix(domain, "/")
+}
+
+func printJSON(e *env, v interface{}) error {
+	encoder := json.NewEncoder(e.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
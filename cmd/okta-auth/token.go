@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+)
+
+func token(e *env, args []string) error {
+	fs := e.newFlagSet("token", "[-field access_token|id_token|refresh_token]")
+	flags := addConfigFlags(fs, true)
+	field := fs.String("field", "", "print only this token, instead of all of them as JSON")
+	conf, err := e.parseConfig(fs, flags, args)
+	if err != nil {
+		return err
+	}
+	if err := conf.requireOIDC(); err != nil {
+		return err
+	}
+	switch *field {
+	case "", "access_token", "id_token", "refresh_token":
+	default:
+		return usageError(fmt.Sprintf("unknown field %q", *field))
+	}
+
+	client, err := e.newClient(conf)
+	if err != nil {
+		return err
+	}
+
+	tokens, err := e.tokens(context.Background(), client, conf, oidcAudience(conf))
+	if err != nil {
+		return err
+	}
+
+	switch *field {
+	case "access_token":
+		fmt.Fprintln(e.stdout, tokens.AccessToken)
+	case "id_token":
+		fmt.Fprintln(e.stdout, tokens.IdToken)
+	case "refresh_token":
+		fmt.Fprintln(e.stdout, tokens.RefreshToken)
+	default:
+		return printJSON(e, tokens)
+	}
+	return nil
+}
+
+// Returns the OIDC tokens cached for the audience if they haven't expired.
+// Otherwise they are requested for the cached session if there is one, or the
+// user authenticates.
+func (e *env) tokens(ctx context.Context, client *okta.OktaClient, conf *config, audience string) (*okta.Tokens, error) {
+	session, err := e.loadSession(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := &okta.Tokens{}
+	var stale bool
+	err = e.credentialCache(conf).Fetch(cacheKey(conf, audience), tokens, nil, func() (time.Time, error) {
+		stale, err = e.authorize(ctx, client, conf, session, func(sessionToken, sessionId string) error {
+			t, err := client.Tokens(ctx, okta.TokenRequest{
+				Issuer:       conf.Issuer,
+				ClientId:     conf.ClientId,
+				RedirectURI:  conf.RedirectURI,
+				Scopes:       conf.Scopes,
+				SessionToken: sessionToken,
+				SessionId:    sessionId,
+			})
+			if err == nil {
+				*tokens = *t
+			}
+			return err
+		})
+		// Cached until the first of the tokens expires
+		expiry := tokens.Expiry
+		if idTokenExpiry := idTokenExpiry(tokens); idTokenExpiry.Before(expiry) {
+			expiry = idTokenExpiry
+		}
+		return expiry, err
+	})
+	if stale {
+		e.evictSession(conf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
@@ -5,5 +5,11 @@ Construct a client with your Okta domain, and a struct that implements the Promp
 
 After calling `Authenticate`, if a second factor is required the appropriate methods from the
 Prompts interface will be called to guide the user through the authentication flow.
+Prompts that also implement ContextPrompts are first shown the app the user is signing
+in to, and whether the policy allows remembering the device.
+Prompts that also implement CancelableCodePrompts can be asked for a TOTP code while
+waiting for a push, see FactorPolicy.
+Prompts that also implement DuoPrompts let the user choose between a Duo Push and a
+passcode for Duo Security factors, which otherwise send a push.
 */
 package okta
@@ -0,0 +1,303 @@
+package okta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/wearefair/okta-auth/api"
+)
+
+// How the user verifies a Duo Security factor, named as in Duo's prompt.
+type DuoFactor string
+
+const (
+	// Sends a push to the Duo Mobile app.
+	DuoFactorPush = DuoFactor("Duo Push")
+	// A passcode from Duo Mobile, a hardware token or an SMS, read with VerifyCode.
+	DuoFactorPasscode = DuoFactor("Passcode")
+)
+
+// The version of Duo's web SDK the prompt is requested as.
+const duoSDKVersion = "2.6"
+
+// Matches the devices the user can verify with in the prompt's device list.
+var (
+	duoDeviceSelectPattern = regexp.MustCompile(`(?s)<select[^>]+name="device"[^>]*>.*?</select>`)
+	duoDeviceValuePattern  = regexp.MustCompile(`<option[^>]+value="([^"]*)"`)
+)
+
+// Returned when the user doesn't answer the Duo Push in time.
+var errDuoTimeout = errors.New("Duo: Timed out waiting for the push to be approved")
+
+// Returned when Duo denies the authentication, ex: when the push is denied.
+type duoError struct {
+	Message string
+}
+
+func (e *duoError) Error() string {
+	return "Duo: " + e.Message
+}
+
+// Verifies a DUO web factor with Duo's prompt, which browsers show in an iframe
+// using Duo's web SDK. The client makes the same requests to Duo's frame
+// endpoints, and then posts Duo's signed response to Okta, which completes the
+// challenge.
+func (c *OktaClient) handleFactorTypeDuo(flow *authFlow, transaction api.AuthenticationTransaction) (string, error) {
+	factor := transaction.Embedded.Factor
+	verification := factor.Embedded.Verification
+	if verification == nil || verification.Host == "" {
+		c.transactionLogger(transaction).Error("Duo factor has no verification")
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, unexpectedErrorMessage)
+	}
+	tx, app, ok := splitDuoSignature(verification.Signature)
+	if !ok {
+		c.transactionLogger(transaction).Error("Duo factor has an invalid signature")
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, unexpectedErrorMessage)
+	}
+	complete := verification.Links.Complete
+	if complete == nil || complete.HREF == "" {
+		c.transactionLogger(transaction).Error("Missing link in Duo verification", slog.String("link", "complete"))
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, "Okta didn't return a complete link for the Duo verification.")
+	}
+
+	c.observeChallengeIssued(flow, factor)
+	duoFactor, passcode, err := c.chooseDuoFactor(flow, factor)
+	var terminal TerminalError
+	if errors.As(err, &terminal) {
+		c.observeFactorResult(flow, api.FactorResultError)
+		return "", err
+	}
+	if err != nil {
+		c.observeFactorResult(flow, api.FactorResultCancelled)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, "Cancelled")
+	}
+
+	duo := duoPrompt{client: c, host: verification.Host}
+	cookie, err := duo.verify(flow.ctx, tx, complete.HREF, duoFactor, passcode, func() {
+		if duoFactor == DuoFactorPush {
+			c.verifyPush(flow)
+		}
+	})
+	if err == errDuoTimeout {
+		c.observeFactorResult(flow, api.FactorResultTimeout)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, err.Error())
+	}
+	var denied *duoError
+	if errors.As(err, &denied) {
+		c.observeFactorResult(flow, api.FactorResultRejected)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, denied.Error())
+	}
+	if err != nil {
+		c.observeFactorResult(flow, api.FactorResultError)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, err.Error())
+	}
+
+	if err := c.completeDuo(flow.ctx, complete.HREF, factor.Id, transaction.StateToken, cookie+":"+app); err != nil {
+		c.observeFactorResult(flow, api.FactorResultError)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, err.Error())
+	}
+
+	// Okta has verified Duo's response, so polling the factor completes the challenge
+	newTransaction, apiError, err := c.sendLinkRequest(flow.ctx, transaction.Links, "poll", &api.FactorVerify{
+		StateToken: transaction.StateToken,
+	})
+	if err != nil {
+		c.observeFactorResult(flow, api.FactorResultError)
+		return "", err
+	}
+	if apiError != nil {
+		c.observeVerifyError(flow, apiError)
+		return c.cancelCurrentFactorWithErrorMessage(flow, transaction, apiError.ErrorSummary)
+	}
+	if newTransaction.Status != api.StateSuccess {
+		c.observeVerifiedTransaction(flow, newTransaction)
+		c.transactionLogger(newTransaction).Error("Duo verification wasn't accepted by Okta", slog.String("factor_result", string(newTransaction.FactorResult)))
+		return c.cancelCurrentFactorWithErrorMessage(flow, newTransaction, unexpectedErrorMessage)
+	}
+	c.observeFactorResult(flow, api.FactorResultSuccess)
+	return c.handleAuthUserFlow(flow, newTransaction, false)
+}
+
+// Asks the user how to verify with Duo if the prompts implement DuoPrompts, and
+// for the passcode if they choose one. Otherwise a push is sent.
+func (c *OktaClient) chooseDuoFactor(flow *authFlow, factor api.Factor) (DuoFactor, string, error) {
+	prompts, ok := c.prompts.(DuoPrompts)
+	if !ok {
+		return DuoFactorPush, "", nil
+	}
+	var duoFactor DuoFactor
+	var err error
+	c.prompt(flow, "ChooseDuoFactor", func() { duoFactor, err = prompts.ChooseDuoFactor(factor.Public()) })
+	if err != nil {
+		return "", "", err
+	}
+	switch duoFactor {
+	case DuoFactorPush:
+		return duoFactor, "", nil
+	case DuoFactorPasscode:
+		passcode, err := c.verifyCode(flow, factor.Public())
+		return duoFactor, passcode, err
+	}
+	return "", "", TerminalError(fmt.Sprintf("Unknown Duo factor %q", duoFactor))
+}
+
+// Posts Duo's signed response to Okta, which verifies it.
+func (c *OktaClient) completeDuo(ctx context.Context, completeURL, factorId, stateToken, sigResponse string) error {
+	form := url.Values{
+		"id":           {factorId},
+		"stateToken":   {stateToken},
+		"sig_response": {sigResponse},
+	}
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	response, _, err := c.sendOAuthRequest(ctx, c.httpClient, http.MethodPost, completeURL, header, form.Encode())
+	if err != nil {
+		return err
+	}
+	if response.StatusCode >= 400 {
+		return fmt.Errorf("Okta returned status %d for the Duo response", response.StatusCode)
+	}
+	return nil
+}
+
+// Splits the signature Okta returned into the request for Duo, and the app
+// signature that is appended to Duo's response.
+func splitDuoSignature(signature string) (string, string, bool) {
+	tx, app, ok := strings.Cut(signature, ":")
+	if !ok || !strings.HasPrefix(tx, "TX|") || !strings.HasPrefix(app, "APP|") {
+		return "", "", false
+	}
+	return tx, app, true
+}
+
+// A Duo prompt, which is a session with Duo's frame endpoints on the API host.
+type duoPrompt struct {
+	client *OktaClient
+	host   string
+	sid    string
+	// The devices in the prompt's device list, ex: phone1.
+	devices []string
+}
+
+// Duo's responses from its frame endpoints.
+type duoResponse struct {
+	Stat     string          `json:"stat"`
+	Message  string          `json:"message"`
+	Response json.RawMessage `json:"response"`
+}
+
+type duoStatus struct {
+	StatusCode string `json:"status_code"`
+	Status     string `json:"status"`
+	Result     string `json:"result"`
+	ResultURL  string `json:"result_url"`
+}
+
+// Starts the prompt for the signed request, and verifies the Duo factor with the
+// first device in the prompt, which is the one Duo selects by default, calling
+// sent once Duo has sent the push or accepted the passcode. Returns the cookie
+// Duo signed the response with.
+func (d *duoPrompt) verify(ctx context.Context, tx, parent string, duoFactor DuoFactor, passcode string, sent func()) (string, error) {
+	if err := d.start(ctx, tx, parent); err != nil {
+		return "", err
+	}
+
+	form := url.Values{"sid": {d.sid}, "device": {d.devices[0]}, "factor": {string(duoFactor)}}
+	if duoFactor == DuoFactorPasscode {
+		form.Set("passcode", passcode)
+	}
+	var prompt struct {
+		TxId string `json:"txid"`
+	}
+	if err := d.send(ctx, "/frame/prompt", form, &prompt); err != nil {
+		return "", err
+	}
+	sent()
+
+	// Duo answers each status request once the status changes, ex: from pushed to
+	// allowed, so the status is polled until there is a result
+	var status duoStatus
+	backoffPolicy := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), 60)
+	operation := func() error {
+		if err := d.send(ctx, "/frame/status", url.Values{"sid": {d.sid}, "txid": {prompt.TxId}}, &status); err != nil {
+			return backoff.Permanent(err)
+		}
+		switch status.Result {
+		case "SUCCESS":
+			return nil
+		case "FAILURE":
+			return backoff.Permanent(&duoError{status.Status})
+		}
+		return errDuoTimeout
+	}
+	notify := d.client.observeHTTPRetries(http.MethodPost, d.url("/frame/status"))
+	if err := backoff.RetryNotify(operation, backoff.WithContext(backoffPolicy, ctx), notify); err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Cookie string `json:"cookie"`
+	}
+	if err := d.send(ctx, status.ResultURL, url.Values{"sid": {d.sid}}, &result); err != nil {
+		return "", err
+	}
+	if result.Cookie == "" {
+		return "", errors.New("Duo didn't return a signed response")
+	}
+	return result.Cookie, nil
+}
+
+// Starts a prompt session. Duo redirects to the prompt, with the session id in
+// the query, and the user's devices in its form.
+func (d *duoPrompt) start(ctx context.Context, tx, parent string) error {
+	form := url.Values{"tx": {tx}, "parent": {parent}, "v": {duoSDKVersion}}
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}, "Accept": {"text/html"}}
+	response, body, err := d.client.sendOAuthRequest(ctx, d.client.httpClient, http.MethodPost, d.url("/frame/web/v1/auth"), header, form.Encode())
+	if err != nil {
+		return err
+	}
+	d.sid = response.Request.URL.Query().Get("sid")
+	if response.StatusCode != http.StatusOK || d.sid == "" {
+		return fmt.Errorf("Duo returned status %d without starting a prompt", response.StatusCode)
+	}
+	for _, option := range duoDeviceValuePattern.FindAllSubmatch(duoDeviceSelectPattern.Find(body), -1) {
+		if len(option[1]) > 0 {
+			d.devices = append(d.devices, html.UnescapeString(string(option[1])))
+		}
+	}
+	if len(d.devices) == 0 {
+		return errors.New("Duo's prompt has no devices to verify with")
+	}
+	return nil
+}
+
+// Posts the form to the frame endpoint, and unmarshals the response into v.
+func (d *duoPrompt) send(ctx context.Context, path string, form url.Values, v interface{}) error {
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	response, body, err := d.client.sendOAuthRequest(ctx, d.client.httpClient, http.MethodPost, d.url(path), header, form.Encode())
+	if err != nil {
+		return err
+	}
+	var duoResponse duoResponse
+	if err := json.Unmarshal(body, &duoResponse); err != nil {
+		d.client.requestLogger(http.MethodPost, d.url(path)).Error("Got error unmarshaling Duo response", errorLogAttr(err))
+		return fmt.Errorf("Duo returned status %d", response.StatusCode)
+	}
+	if duoResponse.Stat != "OK" {
+		return &duoError{duoResponse.Message}
+	}
+	return json.Unmarshal(duoResponse.Response, v)
+}
+
+func (d *duoPrompt) url(path string) string {
+	return "https://" + d.host + path
+}
@@ -1,5 +1,7 @@
 package factors
 
+import "time"
+
 type FactorType string
 
 const (
@@ -12,6 +14,19 @@ const (
 	FactorTypeTokenSoftwareTOTP = FactorType("token:software:totp")
 	FactorTypeTokenHardware     = FactorType("token:hardware")
 	FactorTypeQuestion          = FactorType("question")
+	FactorTypeWeb               = FactorType("web")
+)
+
+// https://developer.okta.com/docs/reference/api/factors/#factor-status
+type FactorStatus string
+
+const (
+	FactorStatusNotSetup          = FactorStatus("NOT_SETUP")
+	FactorStatusPendingActivation = FactorStatus("PENDING_ACTIVATION")
+	FactorStatusEnrolled          = FactorStatus("ENROLLED")
+	FactorStatusActive            = FactorStatus("ACTIVE")
+	FactorStatusInactive          = FactorStatus("INACTIVE")
+	FactorStatusExpired           = FactorStatus("EXPIRED")
 )
 
 // Specifies a multi-factor method available for authentication.
@@ -21,6 +36,17 @@ type Factor struct {
 	FactorType FactorType
 	// https://developer.okta.com/docs/api/resources/factors#provider-type
 	Provider string
+	// Name of the factor's vendor, which for most factors is the provider.
+	VendorName string
+	// Blank if Okta didn't return the factor's status.
+	Status FactorStatus
+
+	// Whether enrolling the factor is "REQUIRED" or "OPTIONAL", only set when
+	// the user is asked to enroll factors.
+	Enrollment string
+	// When the factor was enrolled and last updated. Zero if Okta didn't return them.
+	Created     time.Time
+	LastUpdated time.Time
 
 	// Specifies the profile for a FactorTypeQuestion factor.
 	ProfileQuestion *ProfileQuestion
@@ -31,6 +57,10 @@ type Factor struct {
 	// Specifies the profile for a FactorTypeToken, FactorTypeTokenHardware,
 	// and FactorTypeTokenSoftwareTOTP factor.
 	ProfileToken *ProfileToken
+	// Specifies the profile for a FactorTypeU2F factor.
+	ProfileU2F *ProfileU2F
+	// Specifies the profile for a FactorTypeWebAuthN factor.
+	ProfileWebAuthN *ProfileWebAuthN
 }
 
 type ProfileQuestion struct {
@@ -54,3 +84,19 @@ type ProfileToken struct {
 	// Id for credential. Ex: "dade.murphy@example.com"
 	CredentialId string
 }
+
+type ProfileU2F struct {
+	// Key handle of the registered security key.
+	CredentialId string
+	// App id the key was registered for. Ex: "https://example.okta.com"
+	AppId string
+	// U2F protocol version. Ex: "U2F_V2"
+	Version string
+}
+
+type ProfileWebAuthN struct {
+	// Id of the registered credential.
+	CredentialId string
+	// Name of the authenticator, if Okta recognized it. Ex: "YubiKey 5 NFC"
+	AuthenticatorName string
+}
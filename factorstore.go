@@ -0,0 +1,88 @@
+package okta
+
+import (
+	"sync"
+
+	"github.com/wearefair/okta-auth/api"
+)
+
+// Stores the factor each user last verified, so it can be listed first when the
+// user chooses a factor, or chosen automatically (see FactorPolicy.AutoSelectLast).
+//
+// The methods are called synchronously from the authentication flow, and must be
+// safe for concurrent use.
+type FactorStore interface {
+	// Returns the id of the factor the user last verified on the Okta domain, or
+	// blank if there isn't one.
+	LastFactor(domain, username string) (string, error)
+
+	// Called when the user has verified the factor.
+	SetLastFactor(domain, username, factorId string) error
+}
+
+// A FactorStore that keeps the factors in memory, for the life of the process.
+type MemoryFactorStore struct {
+	mu      sync.Mutex
+	factors map[[2]string]string
+}
+
+func (s *MemoryFactorStore) LastFactor(domain, username string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.factors[[2]string{domain, username}], nil
+}
+
+func (s *MemoryFactorStore) SetLastFactor(domain, username, factorId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.factors == nil {
+		s.factors = map[[2]string]string{}
+	}
+	s.factors[[2]string{domain, username}] = factorId
+	return nil
+}
+
+// Loads the user's last factor from the store, the first time the user could be
+// asked to choose a factor. Failing to load it isn't fatal, the user chooses
+// from the usual list.
+func (c *OktaClient) loadLastFactor(flow *authFlow) {
+	if c.factorStore == nil || flow.lastFactorLoaded {
+		return
+	}
+	flow.lastFactorLoaded = true
+	factorId, err := c.factorStore.LastFactor(c.domain, flow.username)
+	if err != nil {
+		c.logger.Warn("Failed to load the last factor", errorLogAttr(err))
+		return
+	}
+	flow.lastFactorId = factorId
+}
+
+// Stores the factor the user verified, or stops treating it as the last factor
+// for the rest of the flow if verifying it failed.
+func (c *OktaClient) rememberFactorResult(flow *authFlow, result api.FactorResult) {
+	factorId := flow.challengeFactor.Id
+	if c.factorStore == nil || factorId == "" {
+		return
+	}
+	if result != api.FactorResultSuccess {
+		if factorId == flow.lastFactorId {
+			flow.lastFactorId = ""
+		}
+		return
+	}
+	if err := c.factorStore.SetLastFactor(c.domain, flow.username, factorId); err != nil {
+		c.logger.Warn("Failed to store the last factor", errorLogAttr(err))
+	}
+}
+
+// Moves the last factor to the front of the factors, so it is the default choice.
+func withLastFactorFirst(facs api.Factors, lastFactorId string) api.Factors {
+	for i, factor := range facs {
+		if factor.Id == lastFactorId {
+			sorted := append(api.Factors{factor}, facs[:i]...)
+			return append(sorted, facs[i+1:]...)
+		}
+	}
+	return facs
+}
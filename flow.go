@@ -0,0 +1,122 @@
+package okta
+
+import (
+	"context"
+	"time"
+
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Holds the state of a single call to Authenticate.
+// Okta holds the state of the transaction, this only tracks what is needed to
+// report on the flow.
+type authFlow struct {
+	username string
+	start    time.Time
+
+	// The context for the current step of the flow, which is used for requests and
+	// prompts. It is replaced as the flow moves between states and challenges so
+	// that their spans are parented correctly.
+	ctx context.Context
+
+	// Context and span of the whole flow.
+	rootCtx  context.Context
+	rootSpan trace.Span
+
+	// Status of the last transaction handled, and the span covering it.
+	state     api.TransactionState
+	stateCtx  context.Context
+	stateSpan trace.Span
+
+	// The factor currently being verified, when the challenge was issued, and the
+	// span covering the verification.
+	challengeFactor factors.Factor
+	challengeStart  time.Time
+	challengeSpan   trace.Span
+
+	// Total time spent waiting on prompts.
+	promptWait time.Duration
+
+	// Set once the context has been presented to ContextPrompts.
+	contextPresented bool
+
+	// The factor the user last verified, from the FactorStore. Cleared if verifying
+	// it fails, so the user then chooses from the usual list.
+	lastFactorId     string
+	lastFactorLoaded bool
+	// Set when the factor being verified was chosen without asking the user.
+	autoSelected bool
+
+	// The TOTP factor to prompt for a code with while waiting for the push that
+	// was chosen, see FactorPolicy.RacePushWithTOTP. Blank Id if there isn't one.
+	raceFactor api.Factor
+}
+
+func newAuthFlow(ctx context.Context, span trace.Span, username string) *authFlow {
+	return &authFlow{
+		username: username,
+		start:    time.Now(),
+		ctx:      ctx,
+		rootCtx:  ctx,
+		rootSpan: span,
+		stateCtx: ctx,
+	}
+}
+
+// Wrappers for the user provided prompts that trace the time spent in each.
+
+func (c *OktaClient) checkU2FPresence(flow *authFlow, request VerifyU2FRequest) (present bool) {
+	c.prompt(flow, "CheckU2FPresence", func() { present = c.prompts.CheckU2FPresence(request) })
+	return present
+}
+
+func (c *OktaClient) chooseFactor(flow *authFlow, choices []factors.Factor) (factor factors.Factor, err error) {
+	c.prompt(flow, "ChooseFactor", func() { factor, err = c.prompts.ChooseFactor(choices) })
+	return factor, err
+}
+
+func (c *OktaClient) presentUserError(flow *authFlow, msg string) {
+	c.prompt(flow, "PresentUserError", func() { c.prompts.PresentUserError(msg) })
+}
+
+func (c *OktaClient) verifyU2F(flow *authFlow, ctx context.Context, request VerifyU2FRequest) (response VerifyU2FResponse, err error) {
+	c.prompt(flow, "VerifyU2F", func() { response, err = c.prompts.VerifyU2F(ctx, request) })
+	return response, err
+}
+
+func (c *OktaClient) verifyCode(flow *authFlow, factor factors.Factor) (code string, err error) {
+	c.prompt(flow, "VerifyCode", func() { code, err = c.prompts.VerifyCode(factor) })
+	return code, err
+}
+
+func (c *OktaClient) verifyPush(flow *authFlow) {
+	c.prompt(flow, "VerifyPush", func() { c.prompts.VerifyPush() })
+}
+
+// Presents the transaction's context the first time the user is prompted, if the
+// prompts implement ContextPrompts.
+func (c *OktaClient) presentContext(flow *authFlow, transaction api.AuthenticationTransaction) {
+	prompts, ok := c.prompts.(ContextPrompts)
+	if !ok || flow.contextPresented {
+		return
+	}
+	flow.contextPresented = true
+	promptContext := transactionPromptContext(transaction)
+	c.prompt(flow, "PresentContext", func() { prompts.PresentContext(promptContext) })
+}
+
+func transactionPromptContext(transaction api.AuthenticationTransaction) PromptContext {
+	var re PromptContext
+	if target := transaction.Embedded.Target; target != nil {
+		re.TargetName = target.Name
+		re.TargetLabel = target.Label
+	}
+	if policy := transaction.Embedded.Policy; policy != nil {
+		re.AllowRememberDevice = policy.AllowRememberDevice
+		re.RememberDeviceByDefault = policy.RememberDeviceByDefault
+		re.RememberDeviceLifetime = time.Duration(policy.RememberDeviceLifetimeInMinutes) * time.Minute
+	}
+	return re
+}
@@ -0,0 +1,1021 @@
+package okta_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+	"github.com/wearefair/okta-auth/oktatest"
+)
+
+const (
+	login    = "first@example.com"
+	password = "correct-horse-battery-staple"
+)
+
+func TestAuthenticatePasswordOnly(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{Login: login, Password: password})
+
+	client := newClient(t, server, oktatest.NewPrompts(t))
+
+	t.Run("correct password returns a session token", func(t *testing.T) {
+		token, err := client.Authenticate(login, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token == "" {
+			t.Error("expected a session token")
+		}
+	})
+
+	t.Run("wrong password returns an error", func(t *testing.T) {
+		if _, err := client.Authenticate(login, "wrong"); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("unknown user returns an error", func(t *testing.T) {
+		if _, err := client.Authenticate("unknown@example.com", password); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestAuthenticateTerminalStates(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+
+	server.AddUser(&oktatest.User{Login: "locked@example.com", Password: password, LockedOut: true})
+	server.AddUser(&oktatest.User{Login: "expired@example.com", Password: password, PasswordExpired: true})
+	lockout := server.AddUser(&oktatest.User{Login: "lockout@example.com", Password: password, LockoutAttempts: 2})
+
+	client := newClient(t, server, oktatest.NewPrompts(t))
+
+	t.Run("locked out", func(t *testing.T) {
+		_, err := client.Authenticate("locked@example.com", password)
+		assertTerminalError(t, err, "locked")
+	})
+
+	t.Run("password expired", func(t *testing.T) {
+		_, err := client.Authenticate("expired@example.com", password)
+		assertTerminalError(t, err, "password is expired")
+	})
+
+	t.Run("locked out after failed attempts", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if _, err := client.Authenticate(lockout.Login, "wrong"); err == nil {
+				t.Fatal("expected error")
+			}
+		}
+		_, err := client.Authenticate(lockout.Login, password)
+		assertTerminalError(t, err, "locked")
+	})
+}
+
+func TestAuthenticateCodeFactors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		factor *oktatest.Factor
+	}{
+		{"sms", oktatest.SMSFactor("+1 XXX-XXX-5555", "123456")},
+		{"call", oktatest.CallFactor("+1 XXX-XXX-5555", "123456")},
+		{"totp", oktatest.TOTPFactor("GOOGLE", login, "123456")},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := oktatest.NewServer()
+			defer server.Close()
+			server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{testCase.factor}})
+
+			// The first code is wrong, so the user is asked to choose a factor again.
+			factorType := testCase.factor.FactorType
+			prompts := oktatest.NewPrompts(t,
+				oktatest.ExpectChooseFactor(factorType),
+				oktatest.ExpectVerifyCode("000000"),
+				oktatest.ExpectUserError("Invalid Passcode/Answer"),
+				oktatest.ExpectChooseFactor(factorType),
+				oktatest.ExpectVerifyCode("123456"),
+			)
+			client := newClient(t, server, prompts)
+
+			token, err := client.Authenticate(login, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token == "" {
+				t.Error("expected a session token")
+			}
+		})
+	}
+}
+
+func TestAuthenticatePush(t *testing.T) {
+	t.Run("approved", func(t *testing.T) {
+		server := oktatest.NewServer()
+		defer server.Close()
+		push := oktatest.PushFactor(oktatest.PushApprove)
+		push.PushWaitPolls = 1
+		server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{push}})
+
+		prompts := oktatest.NewPrompts(t,
+			oktatest.ExpectChooseFactor(factors.FactorTypePush),
+			oktatest.ExpectVerifyPush(),
+		)
+		token, err := newClient(t, server, prompts).Authenticate(login, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token == "" {
+			t.Error("expected a session token")
+		}
+	})
+
+	for result, message := range map[oktatest.PushResult]string{oktatest.PushReject: "rejected", oktatest.PushTimeout: "Timed Out"} {
+		result, message := result, message
+		t.Run(string(result), func(t *testing.T) {
+			server := oktatest.NewServer()
+			defer server.Close()
+			server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{oktatest.PushFactor(result)}})
+
+			prompts := oktatest.NewPrompts(t,
+				oktatest.ExpectChooseFactor(factors.FactorTypePush),
+				oktatest.ExpectVerifyPush(),
+				oktatest.ExpectUserErrorContaining(message),
+			)
+			// The result is presented through the prompts, never written to stdout
+			var err error
+			stdout := captureStdout(t, func() {
+				_, err = newClient(t, server, prompts).Authenticate(login, password)
+			})
+			if stdout != "" {
+				t.Errorf("expected nothing to be written to stdout, got %q", stdout)
+			}
+			var nonFatal *okta.NonFatalAuthError
+			if !errors.As(err, &nonFatal) {
+				t.Fatalf("expected a NonFatalAuthError, got %v", err)
+			}
+
+			requests := server.Requests()
+			if requests[len(requests)-1] != "POST /api/v1/authn/cancel" {
+				t.Errorf("expected the transaction to be cancelled, got requests %v", requests)
+			}
+		})
+	}
+}
+
+func TestAuthenticateU2F(t *testing.T) {
+	testCases := []struct {
+		name   string
+		factor func(server *oktatest.Server, authenticator *oktatest.Authenticator) *oktatest.Factor
+	}{
+		{"u2f", func(server *oktatest.Server, authenticator *oktatest.Authenticator) *oktatest.Factor {
+			return oktatest.U2FFactor(server.URL, authenticator)
+		}},
+		{"webauthn", func(server *oktatest.Server, authenticator *oktatest.Authenticator) *oktatest.Factor {
+			return oktatest.WebAuthnFactor(authenticator)
+		}},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := oktatest.NewServer()
+			defer server.Close()
+			authenticator := oktatest.NewAuthenticator()
+			server.AddUser(&oktatest.User{
+				Login:    login,
+				Password: password,
+				Factors: []*oktatest.Factor{
+					oktatest.SMSFactor("+1 XXX-XXX-5555", "123456"),
+					testCase.factor(server, authenticator),
+				},
+			})
+
+			t.Run("signed by the key", func(t *testing.T) {
+				// The present device is used without asking the user to choose a factor.
+				var requests []okta.VerifyU2FRequest
+				prompts := oktatest.NewPrompts(t,
+					oktatest.ExpectCheckU2FPresence(true),
+					oktatest.ExpectVerifyU2FFunc(func(ctx context.Context, request okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+						requests = append(requests, request)
+						return authenticator.VerifyU2F(ctx, request)
+					}),
+				)
+				token, err := newClient(t, server, prompts).Authenticate(login, password)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if token == "" {
+					t.Error("expected a session token")
+				}
+				if len(requests) != 1 || requests[0].KeyHandle != authenticator.CredentialId || requests[0].Challenge == "" {
+					t.Errorf("expected one request for the credential, got %#+v", requests)
+				}
+			})
+
+			t.Run("signed by a different key", func(t *testing.T) {
+				impostor := oktatest.NewAuthenticator()
+				impostor.CredentialId = authenticator.CredentialId
+
+				aborted := errors.New("aborted")
+				prompts := oktatest.NewPrompts(t,
+					oktatest.ExpectCheckU2FPresence(true),
+					oktatest.ExpectVerifyU2FFunc(impostor.VerifyU2F),
+					oktatest.ExpectUserError("Invalid Passcode/Answer"),
+					oktatest.ExpectChooseFactorError(aborted),
+				)
+				if _, err := newClient(t, server, prompts).Authenticate(login, password); !errors.Is(err, aborted) {
+					t.Errorf("expected the signature to be rejected, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestAuthenticateCancelled(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{oktatest.SMSFactor("+1 XXX-XXX-5555", "123456")}})
+
+	aborted := errors.New("aborted")
+	prompts := oktatest.NewPrompts(t,
+		oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+		oktatest.ExpectVerifyCodeError(errors.New("cancelled")),
+		oktatest.ExpectUserError("Cancelled"),
+		oktatest.ExpectChooseFactorError(aborted),
+	)
+	if _, err := newClient(t, server, prompts).Authenticate(login, password); !errors.Is(err, aborted) {
+		t.Errorf("expected the error from choosing a factor, got %v", err)
+	}
+
+	expected := []string{"ChooseFactor", "VerifyCode", "PresentUserError", "ChooseFactor"}
+	if !reflect.DeepEqual(prompts.Calls(), expected) {
+		t.Errorf("expected calls %v, got %v", expected, prompts.Calls())
+	}
+	if !reflect.DeepEqual(prompts.UserErrors(), []string{"Cancelled"}) {
+		t.Errorf("expected the cancellation to be presented, got %v", prompts.UserErrors())
+	}
+}
+
+func TestAuthenticateRateLimited(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{Login: login, Password: password})
+
+	server.RateLimit(1)
+	if _, err := newClient(t, server, oktatest.NewPrompts(t)).Authenticate(login, password); err == nil {
+		t.Error("expected error")
+	}
+
+	// The rate limit only applied to the first request
+	if _, err := newClient(t, server, oktatest.NewPrompts(t)).Authenticate(login, password); err != nil {
+		t.Errorf("expected the next authentication to succeed, got %v", err)
+	}
+}
+
+// Prompts that record the contexts presented to them.
+type contextPrompts struct {
+	*oktatest.Prompts
+	contexts []okta.PromptContext
+}
+
+func (p *contextPrompts) PresentContext(promptContext okta.PromptContext) {
+	p.contexts = append(p.contexts, promptContext)
+}
+
+func TestAuthenticatePresentsContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"stateToken": "state",
+			"status": "MFA_REQUIRED",
+			"_embedded": {
+				"factors": [{"id": "sms1", "factorType": "sms", "provider": "OKTA"}],
+				"policy": {"allowRememberDevice": true, "rememberDeviceLifetimeInMinutes": 15},
+				"target": {"type": "APP", "name": "amazon_aws", "label": "AWS Prod"}
+			}
+		}`)
+	}))
+	defer server.Close()
+
+	aborted := errors.New("aborted")
+	prompts := &contextPrompts{Prompts: oktatest.NewPrompts(t, oktatest.ExpectChooseFactorError(aborted))}
+	client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Authenticate(login, password); !errors.Is(err, aborted) {
+		t.Errorf("expected the error from choosing a factor, got %v", err)
+	}
+
+	expected := []okta.PromptContext{{
+		TargetName:             "amazon_aws",
+		TargetLabel:            "AWS Prod",
+		AllowRememberDevice:    true,
+		RememberDeviceLifetime: 15 * time.Minute,
+	}}
+	if !reflect.DeepEqual(prompts.contexts, expected) {
+		t.Errorf("expected contexts %#+v, got %#+v", expected, prompts.contexts)
+	}
+}
+
+func TestAuthenticateMissingLink(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprint(w, `{
+			"stateToken": "state",
+			"status": "MFA_REQUIRED",
+			"_embedded": {"factors": [{
+				"id": "sms1", "factorType": "sms", "provider": "OKTA",
+				"profile": {"phoneNumber": "+1 XXX-XXX-5555"}
+			}]}
+		}`)
+	}))
+	defer server.Close()
+
+	prompts := oktatest.NewPrompts(t, oktatest.ExpectChooseFactor(factors.FactorTypeSMS))
+	client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Authenticate(login, password)
+	assertTerminalError(t, err, "verify link")
+	if !reflect.DeepEqual(paths, []string{"/api/v1/authn"}) {
+		t.Errorf("expected no request without a link, got %v", paths)
+	}
+}
+
+func TestAuthenticateSecurityKeyWithoutProfile(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{
+			"stateToken": "state",
+			"status": "MFA_REQUIRED",
+			"_embedded": {"factors": [
+				{"id": "u2f1", "factorType": "u2f", "provider": "FIDO"},
+				{"id": "webauthn1", "factorType": "webauthn", "provider": "FIDO", "profile": null}
+			]}
+		}`)
+	}))
+	defer server.Close()
+
+	// The devices can't be checked without their profiles, so the user chooses
+	aborted := errors.New("aborted")
+	prompts := oktatest.NewPrompts(t, oktatest.ExpectChooseFactorError(aborted))
+	client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Authenticate(login, password); !errors.Is(err, aborted) {
+		t.Errorf("expected the error from choosing a factor, got %v", err)
+	}
+}
+
+func TestAuthenticateFactorPolicy(t *testing.T) {
+	authenticator := oktatest.NewAuthenticator()
+	userFactors := func(server *oktatest.Server) []*oktatest.Factor {
+		return []*oktatest.Factor{
+			oktatest.SMSFactor("+1 XXX-XXX-5555", "123456"),
+			oktatest.CallFactor("+1 XXX-XXX-5555", "123456"),
+			oktatest.TOTPFactor("GOOGLE", login, "123456"),
+			oktatest.PushFactor(oktatest.PushApprove),
+			oktatest.U2FFactor(server.URL, authenticator),
+		}
+	}
+	denyPhone := []okta.FactorMatch{{FactorType: factors.FactorTypeSMS}, {FactorType: factors.FactorTypeCall}}
+
+	testCases := []struct {
+		name    string
+		policy  okta.FactorPolicy
+		script  []oktatest.Interaction
+		choices []factors.FactorType
+	}{
+		{
+			name:   "deny",
+			policy: okta.FactorPolicy{Deny: denyPhone},
+			script: []oktatest.Interaction{
+				oktatest.ExpectCheckU2FPresence(false),
+				oktatest.ExpectChooseFactor(factors.FactorTypeTokenSoftwareTOTP),
+				oktatest.ExpectVerifyCode("123456"),
+			},
+			choices: []factors.FactorType{factors.FactorTypeTokenSoftwareTOTP, factors.FactorTypePush, factors.FactorTypeU2F},
+		},
+		{
+			name: "prefer",
+			policy: okta.FactorPolicy{Prefer: []okta.FactorMatch{
+				{FactorType: factors.FactorTypeU2F},
+				{FactorType: factors.FactorTypePush, Provider: "okta"},
+			}},
+			script: []oktatest.Interaction{
+				oktatest.ExpectCheckU2FPresence(false),
+				oktatest.ExpectVerifyPush(),
+			},
+		},
+		{
+			// The TOTP factor's provider is GOOGLE, so only U2F is preferred
+			name: "preferred are listed first",
+			policy: okta.FactorPolicy{
+				Deny:   denyPhone,
+				Prefer: []okta.FactorMatch{{FactorType: factors.FactorTypeTokenSoftwareTOTP, Provider: "OKTA"}, {FactorType: factors.FactorTypeU2F}},
+			},
+			script: []oktatest.Interaction{
+				oktatest.ExpectCheckU2FPresence(false),
+				oktatest.ExpectChooseFactor(factors.FactorTypeTokenSoftwareTOTP),
+				oktatest.ExpectVerifyCode("123456"),
+			},
+			choices: []factors.FactorType{factors.FactorTypeU2F, factors.FactorTypeTokenSoftwareTOTP, factors.FactorTypePush},
+		},
+		{
+			name: "auto select single",
+			policy: okta.FactorPolicy{
+				Deny:             append(denyPhone, okta.FactorMatch{FactorType: factors.FactorTypePush}, okta.FactorMatch{Provider: "FIDO"}),
+				AutoSelectSingle: true,
+			},
+			script: []oktatest.Interaction{
+				oktatest.ExpectVerifyCode("123456"),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := oktatest.NewServer()
+			defer server.Close()
+			server.AddUser(&oktatest.User{Login: login, Password: password, Factors: userFactors(server)})
+
+			prompts := &recordingPrompts{Prompts: oktatest.NewPrompts(t, testCase.script...)}
+			client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts, FactorPolicy: testCase.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := client.Authenticate(login, password); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(prompts.choices, testCase.choices) {
+				t.Errorf("expected to choose from %v, got %v", testCase.choices, prompts.choices)
+			}
+		})
+	}
+
+	t.Run("none allowed", func(t *testing.T) {
+		server := oktatest.NewServer()
+		defer server.Close()
+		server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{
+			oktatest.SMSFactor("+1 XXX-XXX-5555", "123456"),
+		}})
+
+		prompts := oktatest.NewPrompts(t)
+		client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts, FactorPolicy: okta.FactorPolicy{Deny: denyPhone}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Authenticate(login, password)
+		assertTerminalError(t, err, "No allowed MFA types found")
+	})
+}
+
+func TestAuthenticateLastFactor(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{
+		oktatest.SMSFactor("+1 XXX-XXX-5555", "123456"),
+		oktatest.TOTPFactor("GOOGLE", login, "123456"),
+	}})
+	store := &okta.MemoryFactorStore{}
+	authenticate := func(policy okta.FactorPolicy, script ...oktatest.Interaction) []factors.FactorType {
+		t.Helper()
+		prompts := &recordingPrompts{Prompts: oktatest.NewPrompts(t, script...)}
+		client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts, FactorPolicy: policy, FactorStore: store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Authenticate(login, password); err != nil {
+			t.Fatal(err)
+		}
+		return prompts.choices
+	}
+
+	choices := authenticate(okta.FactorPolicy{},
+		oktatest.ExpectChooseFactor(factors.FactorTypeTokenSoftwareTOTP),
+		oktatest.ExpectVerifyCode("123456"),
+	)
+	if expected := []factors.FactorType{factors.FactorTypeSMS, factors.FactorTypeTokenSoftwareTOTP}; !reflect.DeepEqual(choices, expected) {
+		t.Errorf("expected to choose from %v, got %v", expected, choices)
+	}
+
+	// The last factor is the default
+	choices = authenticate(okta.FactorPolicy{},
+		oktatest.ExpectChooseFactor(factors.FactorTypeTokenSoftwareTOTP),
+		oktatest.ExpectVerifyCode("123456"),
+	)
+	if expected := []factors.FactorType{factors.FactorTypeTokenSoftwareTOTP, factors.FactorTypeSMS}; !reflect.DeepEqual(choices, expected) {
+		t.Errorf("expected to choose from %v, got %v", expected, choices)
+	}
+
+	// When it is chosen automatically and fails, the user chooses from the usual list
+	choices = authenticate(okta.FactorPolicy{AutoSelectLast: true},
+		oktatest.ExpectVerifyCode("000000"),
+		oktatest.ExpectUserError("Invalid Passcode/Answer"),
+		oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+		oktatest.ExpectVerifyCode("123456"),
+	)
+	if expected := []factors.FactorType{factors.FactorTypeSMS, factors.FactorTypeTokenSoftwareTOTP}; !reflect.DeepEqual(choices, expected) {
+		t.Errorf("expected to choose from %v, got %v", expected, choices)
+	}
+
+	authenticate(okta.FactorPolicy{AutoSelectLast: true}, oktatest.ExpectVerifyCode("123456"))
+	domain := strings.TrimPrefix(server.URL, "http://")
+	if factorId, _ := store.LastFactor(domain, login); !strings.HasPrefix(factorId, "sms") {
+		t.Errorf("expected the SMS factor to be stored, got %q", factorId)
+	}
+}
+
+// A remembered or automatically chosen push that is rejected goes back to the
+// user choosing a factor, like other factors.
+func TestAuthenticateLastFactorPushRejected(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy okta.FactorPolicy
+		script []oktatest.Interaction
+	}{
+		{
+			name:   "remembered",
+			policy: okta.FactorPolicy{},
+			script: []oktatest.Interaction{
+				oktatest.ExpectChooseFactor(factors.FactorTypePush),
+				oktatest.ExpectVerifyPush(),
+				oktatest.ExpectUserErrorContaining("rejected"),
+				oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+				oktatest.ExpectVerifyCode("123456"),
+			},
+		},
+		{
+			name:   "auto-selected",
+			policy: okta.FactorPolicy{AutoSelectLast: true},
+			script: []oktatest.Interaction{
+				oktatest.ExpectVerifyPush(),
+				oktatest.ExpectUserErrorContaining("rejected"),
+				oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+				oktatest.ExpectVerifyCode("123456"),
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := oktatest.NewServer()
+			defer server.Close()
+			push := oktatest.PushFactor(oktatest.PushReject)
+			server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{
+				push,
+				oktatest.SMSFactor("+1 XXX-XXX-5555", "123456"),
+			}})
+			store := &okta.MemoryFactorStore{}
+			store.SetLastFactor(strings.TrimPrefix(server.URL, "http://"), login, push.Id)
+
+			client, err := okta.New(okta.ClientConfig{
+				OktaDomain:   server.URL,
+				Prompts:      oktatest.NewPrompts(t, testCase.script...),
+				FactorPolicy: testCase.policy,
+				FactorStore:  store,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Authenticate(login, password); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// The last factor doesn't take precedence over more preferred factors unless
+// AutoSelectLast is set.
+func TestAuthenticateLastFactorPreferred(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{
+		oktatest.PushFactor(oktatest.PushApprove),
+		oktatest.TOTPFactor("GOOGLE", login, "123456"),
+	}})
+	store := &okta.MemoryFactorStore{}
+	prefer := []okta.FactorMatch{{FactorType: factors.FactorTypePush}, {FactorType: factors.FactorTypeTokenSoftwareTOTP}}
+	authenticate := func(policy okta.FactorPolicy, script ...oktatest.Interaction) {
+		t.Helper()
+		client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: oktatest.NewPrompts(t, script...), FactorPolicy: policy, FactorStore: store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Authenticate(login, password); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authenticate(okta.FactorPolicy{},
+		oktatest.ExpectChooseFactor(factors.FactorTypeTokenSoftwareTOTP),
+		oktatest.ExpectVerifyCode("123456"),
+	)
+	authenticate(okta.FactorPolicy{Prefer: prefer}, oktatest.ExpectVerifyPush())
+
+	authenticate(okta.FactorPolicy{},
+		oktatest.ExpectChooseFactor(factors.FactorTypeTokenSoftwareTOTP),
+		oktatest.ExpectVerifyCode("123456"),
+	)
+	authenticate(okta.FactorPolicy{Prefer: prefer, AutoSelectLast: true}, oktatest.ExpectVerifyCode("123456"))
+}
+
+func TestAuthenticateRacePushWithTOTP(t *testing.T) {
+	testCases := []struct {
+		name     string
+		push     oktatest.PushResult
+		script   []oktatest.Interaction
+		previous bool
+	}{
+		{
+			name: "push approved first",
+			push: oktatest.PushApprove,
+			script: []oktatest.Interaction{
+				oktatest.ExpectChooseFactor(factors.FactorTypePush),
+				oktatest.ExpectVerifyPush(),
+				oktatest.ExpectVerifyCodeCancelled(),
+			},
+		},
+		{
+			name: "code entered first",
+			push: oktatest.PushWait,
+			script: []oktatest.Interaction{
+				oktatest.ExpectChooseFactor(factors.FactorTypePush),
+				oktatest.ExpectVerifyPush(),
+				oktatest.ExpectVerifyCode("123456"),
+			},
+			previous: true,
+		},
+		{
+			name: "wrong code entered first",
+			push: oktatest.PushWait,
+			script: []oktatest.Interaction{
+				oktatest.ExpectChooseFactor(factors.FactorTypePush),
+				oktatest.ExpectVerifyPush(),
+				oktatest.ExpectVerifyCode("000000"),
+				oktatest.ExpectUserError("Invalid Passcode/Answer"),
+				oktatest.ExpectChooseFactor(factors.FactorTypeTokenSoftwareTOTP),
+				oktatest.ExpectVerifyCode("123456"),
+			},
+			previous: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := oktatest.NewServer()
+			defer server.Close()
+			push := oktatest.PushFactor(testCase.push)
+			push.PushWaitPolls = 1
+			server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{
+				push,
+				oktatest.TOTPFactor("GOOGLE", login, "123456"),
+			}})
+
+			prompts := oktatest.NewPrompts(t, testCase.script...)
+			client, err := okta.New(okta.ClientConfig{
+				OktaDomain:   server.URL,
+				Prompts:      prompts,
+				FactorPolicy: okta.FactorPolicy{RacePushWithTOTP: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := client.Authenticate(login, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token == "" {
+				t.Error("expected a session token")
+			}
+
+			// Entering a code goes back to the factors, abandoning the push
+			requests := strings.Join(server.Requests(), "\n")
+			if previous := strings.Contains(requests, "POST /api/v1/authn/previous"); previous != testCase.previous {
+				t.Errorf("expected the push to be cancelled %t, got requests %v", testCase.previous, server.Requests())
+			}
+		})
+	}
+}
+
+func TestAuthenticateDuo(t *testing.T) {
+	testCases := []struct {
+		name     string
+		push     oktatest.PushResult
+		script   []oktatest.Interaction
+		duoError bool
+	}{
+		{
+			name: "push approved",
+			push: oktatest.PushApprove,
+			script: []oktatest.Interaction{
+				oktatest.ExpectChooseFactor(factors.FactorTypeWeb),
+				oktatest.ExpectChooseDuoFactor(okta.DuoFactorPush),
+				oktatest.ExpectVerifyPush(),
+			},
+		},
+		{
+			name: "push denied",
+			push: oktatest.PushReject,
+			script: []oktatest.Interaction{
+				oktatest.ExpectChooseFactor(factors.FactorTypeWeb),
+				oktatest.ExpectChooseDuoFactor(okta.DuoFactorPush),
+				oktatest.ExpectVerifyPush(),
+				oktatest.ExpectUserError("Duo: Login request denied."),
+				oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+				oktatest.ExpectVerifyCode("123456"),
+			},
+			duoError: true,
+		},
+		{
+			name: "passcode",
+			push: oktatest.PushWait,
+			script: []oktatest.Interaction{
+				oktatest.ExpectChooseFactor(factors.FactorTypeWeb),
+				oktatest.ExpectChooseDuoFactor(okta.DuoFactorPasscode),
+				oktatest.ExpectVerifyCode("987654"),
+			},
+		},
+		{
+			name: "wrong passcode",
+			push: oktatest.PushWait,
+			script: []oktatest.Interaction{
+				oktatest.ExpectChooseFactor(factors.FactorTypeWeb),
+				oktatest.ExpectChooseDuoFactor(okta.DuoFactorPasscode),
+				oktatest.ExpectVerifyCode("000000"),
+				oktatest.ExpectUserError("Duo: Incorrect passcode. Please try again."),
+				oktatest.ExpectChooseFactor(factors.FactorTypeWeb),
+				oktatest.ExpectChooseDuoFactor(okta.DuoFactorPasscode),
+				oktatest.ExpectVerifyCode("987654"),
+			},
+			duoError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			server := oktatest.NewServer()
+			defer server.Close()
+			duo := oktatest.NewDuoServer()
+			defer duo.Close()
+			server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{
+				oktatest.DuoFactor(duo, testCase.push, "987654"),
+				oktatest.SMSFactor("+1 XXX-XXX-5555", "123456"),
+			}})
+
+			prompts := oktatest.NewPrompts(t, testCase.script...)
+			client, err := okta.New(okta.ClientConfig{
+				OktaDomain:   server.URL,
+				Prompts:      prompts,
+				RoundTripper: duo.Client().Transport,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := client.Authenticate(login, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token == "" {
+				t.Error("expected a session token")
+			}
+
+			if denied := len(prompts.UserErrors()) > 0; denied != testCase.duoError {
+				t.Errorf("expected Duo to deny the first attempt %t, got errors %v", testCase.duoError, prompts.UserErrors())
+			}
+		})
+	}
+
+	t.Run("push by default", func(t *testing.T) {
+		server := oktatest.NewServer()
+		defer server.Close()
+		duo := oktatest.NewDuoServer()
+		defer duo.Close()
+		server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{
+			oktatest.DuoFactor(duo, oktatest.PushApprove, ""),
+		}})
+
+		// Prompts that don't implement DuoPrompts aren't asked how to verify
+		prompts := oktatest.NewPrompts(t,
+			oktatest.ExpectChooseFactor(factors.FactorTypeWeb),
+			oktatest.ExpectVerifyPush(),
+		)
+		client, err := okta.New(okta.ClientConfig{
+			OktaDomain:   server.URL,
+			Prompts:      struct{ okta.Prompts }{prompts},
+			RoundTripper: duo.Client().Transport,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Authenticate(login, password); err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"POST /frame/web/v1/auth", "GET /frame/prompt", "POST /frame/prompt", "POST /frame/status"}
+		if requests := duo.Requests(); !reflect.DeepEqual(requests[:len(expected)], expected) {
+			t.Errorf("expected requests %v, got %v", expected, requests)
+		}
+
+		// Duo's signed response is posted to Okta, which completes the challenge
+		if requests := strings.Join(server.Requests(), "\n"); !strings.Contains(requests, "/lifecycle/duoCallback") {
+			t.Errorf("expected Duo's response to be posted to Okta, got requests %v", server.Requests())
+		}
+	})
+
+	t.Run("device from the prompt", func(t *testing.T) {
+		server := oktatest.NewServer()
+		defer server.Close()
+		duo := oktatest.NewDuoServer()
+		defer duo.Close()
+		factor := oktatest.DuoFactor(duo, oktatest.PushApprove, "")
+		factor.DuoDevices = []string{"phone2", "phone1"}
+		server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{factor}})
+
+		prompts := oktatest.NewPrompts(t,
+			oktatest.ExpectChooseFactor(factors.FactorTypeWeb),
+			oktatest.ExpectChooseDuoFactor(okta.DuoFactorPush),
+			oktatest.ExpectVerifyPush(),
+		)
+		client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts, RoundTripper: duo.Client().Transport})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Authenticate(login, password); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("unknown duo factor", func(t *testing.T) {
+		server := oktatest.NewServer()
+		defer server.Close()
+		duo := oktatest.NewDuoServer()
+		defer duo.Close()
+		server.AddUser(&oktatest.User{Login: login, Password: password, Factors: []*oktatest.Factor{
+			oktatest.DuoFactor(duo, oktatest.PushApprove, ""),
+		}})
+
+		prompts := oktatest.NewPrompts(t,
+			oktatest.ExpectChooseFactor(factors.FactorTypeWeb),
+			oktatest.ExpectChooseDuoFactor(okta.DuoFactor("Phone Call")),
+		)
+		client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts, RoundTripper: duo.Client().Transport})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Authenticate(login, password)
+		assertTerminalError(t, err, `Unknown Duo factor "Phone Call"`)
+		if requests := duo.Requests(); len(requests) > 0 {
+			t.Errorf("expected no requests to Duo, got %v", requests)
+		}
+	})
+}
+
+// Records the types of the factors the user is asked to choose from.
+type recordingPrompts struct {
+	*oktatest.Prompts
+	choices []factors.FactorType
+}
+
+func (p *recordingPrompts) ChooseFactor(choices []factors.Factor) (factors.Factor, error) {
+	for _, choice := range choices {
+		p.choices = append(p.choices, choice.FactorType)
+	}
+	return p.Prompts.ChooseFactor(choices)
+}
+
+// Prompts that decline everything, so any transaction can be fuzzed without a
+// script.
+type decliningPrompts struct{}
+
+func (decliningPrompts) CheckU2FPresence(okta.VerifyU2FRequest) bool { return true }
+
+func (decliningPrompts) ChooseFactor(choices []factors.Factor) (factors.Factor, error) {
+	if len(choices) == 0 {
+		return factors.Factor{}, errors.New("no factors")
+	}
+	return choices[0], nil
+}
+
+func (decliningPrompts) PresentUserError(string) {}
+
+func (decliningPrompts) VerifyU2F(context.Context, okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+	return okta.VerifyU2FResponse{}, errors.New("declined")
+}
+
+func (decliningPrompts) VerifyCode(factors.Factor) (string, error) {
+	return "", errors.New("declined")
+}
+
+func (decliningPrompts) VerifyPush() {}
+
+// Whatever Okta, or anyone between it and the client, responds with, the flow
+// fails rather than panicking.
+func FuzzAuthenticate(f *testing.F) {
+	paths, err := filepath.Glob("api/testdata/transactions/*.json")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		input, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(input)
+	}
+	f.Add([]byte(`{"status":"MFA_REQUIRED","_embedded":{"factors":[{"factorType":"u2f"}]}}`))
+	f.Add([]byte(`{"status":"MFA_CHALLENGE","_embedded":{"factor":{"factorType":"webauthn","profile":{}}}}`))
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		client, err := okta.New(okta.ClientConfig{
+			OktaDomain:   "example.okta.com",
+			Prompts:      decliningPrompts{},
+			RoundTripper: &replayingTransport{body: body},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Polling for a push waits between requests
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		client.AuthenticateContext(ctx, login, password)
+	})
+}
+
+// Responds to every request with the same body, wherever it is sent, until the
+// limit of requests is reached. Then the state token is rejected, ending the
+// flow.
+type replayingTransport struct {
+	body     []byte
+	requests int
+}
+
+func (t *replayingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.requests++
+	response := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: r}
+	body := t.body
+	if t.requests > 10 {
+		response.StatusCode = http.StatusForbidden
+		body = []byte(`{"errorCode":"E0000011","errorSummary":"Invalid token provided"}`)
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return response, nil
+}
+
+func newClient(t *testing.T, server *oktatest.Server, prompts okta.Prompts) *okta.OktaClient {
+	t.Helper()
+	client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// Returns what is written to stdout while running f.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		output <- string(b)
+	}()
+	f()
+	w.Close()
+	return <-output
+}
+
+func assertTerminalError(t *testing.T, err error, contains string) {
+	t.Helper()
+	terminal, ok := err.(okta.TerminalError)
+	if !ok {
+		t.Fatalf("expected a TerminalError, got %#+v", err)
+	}
+	if !strings.Contains(terminal.Error(), contains) {
+		t.Errorf("expected error to contain %q, got %q", contains, terminal)
+	}
+}
@@ -0,0 +1,107 @@
+package okta
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wearefair/okta-auth/api"
+)
+
+// Keys for the structured attributes attached to log records.
+const (
+	LogKeyTransactionStatus = "transaction_status"
+	LogKeyFactorType        = "factor_type"
+	LogKeyFactorProvider    = "factor_provider"
+	LogKeyMethod            = "method"
+	LogKeyPath              = "path"
+	LogKeyStatusCode        = "status_code"
+	LogKeyLatency           = "latency"
+	LogKeyRequestId         = "request_id"
+	LogKeyError             = "error"
+)
+
+// Header Okta sets on every response to identify the request in its system log.
+const oktaRequestIdHeader = "X-Okta-Request-Id"
+
+// Returns the logger for the client based on the config.
+// A LogHandler takes precedence over a DebugLogger, and when neither are set
+// all logs are discarded.
+func newLogger(conf ClientConfig) *slog.Logger {
+	if conf.LogHandler != nil {
+		return slog.New(conf.LogHandler)
+	}
+	if conf.DebugLogger != nil {
+		return slog.New(NewDebugLoggerHandler(conf.DebugLogger))
+	}
+	return slog.New(discardHandler{})
+}
+
+// Returns a slog.Handler that formats records as text and passes each one to the
+// DebugLogger as a single line.
+func NewDebugLoggerHandler(logger DebugLogger) slog.Handler {
+	return slog.NewTextHandler(debugLoggerWriter{logger}, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			// The DebugLogger is responsible for timestamps
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+}
+
+type debugLoggerWriter struct {
+	logger DebugLogger
+}
+
+// The text handler issues exactly one write per record.
+func (w debugLoggerWriter) Write(p []byte) (int, error) {
+	w.logger.Log(strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// Returns a logger annotated with the state of the transaction, and the active
+// factor if there is one.
+func (c *OktaClient) transactionLogger(transaction api.AuthenticationTransaction) *slog.Logger {
+	logger := c.logger.With(slog.String(LogKeyTransactionStatus, string(transaction.Status)))
+	if factor := transaction.Embedded.Factor; factor.FactorType != "" {
+		logger = logger.With(factorLogAttrs(factor)...)
+	}
+	return logger
+}
+
+func factorLogAttrs(factor api.Factor) []interface{} {
+	return []interface{}{
+		slog.String(LogKeyFactorType, string(factor.FactorType)),
+		slog.String(LogKeyFactorProvider, factor.Provider),
+	}
+}
+
+// Returns a logger annotated with the method and path of the request.
+// The query string is left out as it may contain tokens.
+func (c *OktaClient) requestLogger(method, rawURL string) *slog.Logger {
+	path := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil {
+		path = parsed.Path
+	}
+	return c.logger.With(slog.String(LogKeyMethod, method), slog.String(LogKeyPath, path))
+}
+
+func latencyLogAttr(start time.Time) slog.Attr {
+	return slog.Duration(LogKeyLatency, time.Since(start))
+}
+
+func errorLogAttr(err error) slog.Attr {
+	return slog.String(LogKeyError, err.Error())
+}
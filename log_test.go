@@ -0,0 +1,82 @@
+package okta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogHandlerAttributes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(oktaRequestIdHeader, "WbvWzM1Ll3GZJrO3F1NmHwAAAAA")
+		fmt.Fprintf(w, `{"status":"SUCCESS","sessionToken":"token"}`)
+	}))
+	defer server.Close()
+
+	buf := &bytes.Buffer{}
+	client, err := New(ClientConfig{
+		OktaDomain: server.URL,
+		Prompts:    TestPrompts{},
+		LogHandler: slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Authenticate("first@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	records := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log line %q: %s", line, err)
+		}
+		records[record["msg"].(string)] = record
+	}
+
+	response, ok := records["Got http response"]
+	if !ok {
+		t.Fatalf("expected http response record, got %v", records)
+	}
+	expected := map[string]interface{}{
+		LogKeyMethod:     "POST",
+		LogKeyPath:       "/api/v1/authn",
+		LogKeyStatusCode: float64(200),
+		LogKeyRequestId:  "WbvWzM1Ll3GZJrO3F1NmHwAAAAA",
+	}
+	for key, value := range expected {
+		if response[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, response[key])
+		}
+	}
+	if _, ok := response[LogKeyLatency]; !ok {
+		t.Errorf("expected %s to be set", LogKeyLatency)
+	}
+
+	flow, ok := records["Handling auth user flow"]
+	if !ok {
+		t.Fatalf("expected auth user flow record, got %v", records)
+	}
+	if flow[LogKeyTransactionStatus] != "SUCCESS" {
+		t.Errorf("expected %s to be SUCCESS, got %v", LogKeyTransactionStatus, flow[LogKeyTransactionStatus])
+	}
+}
+
+func TestDebugLoggerHandler(t *testing.T) {
+	logger := &recordingLogger{}
+	slog.New(NewDebugLoggerHandler(logger)).Debug("Sending http request", slog.String(LogKeyMethod, "POST"))
+
+	if len(logger.logs) != 1 {
+		t.Fatalf("expected exactly one log, got %v", logger.logs)
+	}
+	expected := `level=DEBUG msg="Sending http request" method=POST`
+	if logger.logs[0] != expected {
+		t.Errorf("expected %q, got %q", expected, logger.logs[0])
+	}
+}
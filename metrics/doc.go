@@ -0,0 +1,11 @@
+// Exports Prometheus metrics for authentication flows.
+//
+// Construct the metrics with a registerer, and instrument the client config before
+// creating the client:
+//
+//	m, err := metrics.New(prometheus.DefaultRegisterer)
+//	...
+//	conf := okta.ClientConfig{OktaDomain: "example.okta.com", Prompts: prompts}
+//	m.Instrument(&conf)
+//	client, err := okta.New(conf)
+package metrics
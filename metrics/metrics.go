@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+const namespace = "okta_auth"
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// Collects metrics for authentication flows.
+//
+// Metrics implements okta.Observer to record the flow, and wraps the client's
+// http.RoundTripper to record requests made to Okta.
+type Metrics struct {
+	okta.NopObserver
+
+	authentications        *prometheus.CounterVec
+	authenticationDuration *prometheus.HistogramVec
+	factorsChosen          *prometheus.CounterVec
+	factorResults          *prometheus.CounterVec
+	pushApprovalDuration   *prometheus.HistogramVec
+	httpRequestDuration    *prometheus.HistogramVec
+	httpRateLimited        *prometheus.CounterVec
+}
+
+// Creates the metrics and registers them with the given registerer.
+func New(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		authentications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "authentications_total",
+			Help:      "Number of authentication flows by outcome.",
+		}, []string{"outcome"}),
+		authenticationDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "authentication_duration_seconds",
+			Help:      "Duration of authentication flows by outcome, including time spent waiting on the user.",
+			Buckets:   []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+		}, []string{"outcome"}),
+		factorsChosen: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "factors_chosen_total",
+			Help:      "Number of times a factor was chosen for verification by factor type and provider.",
+		}, []string{"factor_type", "provider", "automatic"}),
+		factorResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "factor_results_total",
+			Help:      "Number of factor verifications by factor type, provider and result.",
+		}, []string{"factor_type", "provider", "result"}),
+		pushApprovalDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "push_approval_duration_seconds",
+			Help:      "Time between a push notification being sent and the user responding to it, by result.",
+			Buckets:   []float64{1, 2.5, 5, 7.5, 10, 15, 20, 30, 60},
+		}, []string{"result"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of requests to Okta by method, endpoint and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "endpoint", "status"}),
+		httpRateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_rate_limited_total",
+			Help:      "Number of requests to Okta that were rate limited (HTTP 429) by endpoint.",
+		}, []string{"method", "endpoint"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.authentications,
+		m.authenticationDuration,
+		m.factorsChosen,
+		m.factorResults,
+		m.pushApprovalDuration,
+		m.httpRequestDuration,
+		m.httpRateLimited,
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Configures the client config to report to the metrics.
+// Any existing Observer and RoundTripper on the config are preserved.
+func (m *Metrics) Instrument(conf *okta.ClientConfig) {
+	if conf.Observer == nil {
+		conf.Observer = m
+	} else {
+		conf.Observer = okta.MultiObserver(conf.Observer, m)
+	}
+	conf.RoundTripper = m.RoundTripper(conf.RoundTripper)
+}
+
+// Returns a RoundTripper that records the latency and status of each request
+// before passing it to next. If next is nil, http.DefaultTransport is used.
+func (m *Metrics) RoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return roundTripperFunc(func(request *http.Request) (*http.Response, error) {
+		endpoint := Endpoint(request.URL.Path)
+		start := time.Now()
+
+		response, err := next.RoundTrip(request)
+		if err != nil {
+			m.httpRequestDuration.WithLabelValues(request.Method, endpoint, "error").Observe(time.Since(start).Seconds())
+			return response, err
+		}
+
+		m.httpRequestDuration.WithLabelValues(request.Method, endpoint, strconv.Itoa(response.StatusCode)).Observe(time.Since(start).Seconds())
+		if response.StatusCode == http.StatusTooManyRequests {
+			m.httpRateLimited.WithLabelValues(request.Method, endpoint).Inc()
+		}
+		return response, nil
+	})
+}
+
+func (m *Metrics) FactorChosen(e okta.FactorEvent) {
+	m.factorsChosen.WithLabelValues(string(e.Factor.FactorType), e.Factor.Provider, strconv.FormatBool(e.Automatic)).Inc()
+}
+
+func (m *Metrics) FactorResult(e okta.FactorResultEvent) {
+	m.factorResults.WithLabelValues(string(e.Factor.FactorType), e.Factor.Provider, string(e.Result)).Inc()
+
+	if e.Factor.FactorType == factors.FactorTypePush && e.Result != api.FactorResultTimeout {
+		m.pushApprovalDuration.WithLabelValues(string(e.Result)).Observe(e.Duration.Seconds())
+	}
+}
+
+func (m *Metrics) AuthenticationFinished(e okta.AuthenticationFinishedEvent) {
+	outcome := outcomeSuccess
+	if e.Err != nil {
+		outcome = outcomeFailure
+	}
+	m.authentications.WithLabelValues(outcome).Inc()
+	m.authenticationDuration.WithLabelValues(outcome).Observe(e.Duration.Seconds())
+}
+
+// Collections in the Okta API whose next path segment is the id of a resource.
+var collections = map[string]bool{
+	"factors":  true,
+	"sessions": true,
+	"users":    true,
+	"apps":     true,
+}
+
+// Returns the path with resource ids replaced by a placeholder, so that it can be
+// used as a label without creating a series per factor or session.
+//
+// Ex: "/api/v1/authn/factors/sms59eptnqQ7XZ2xe1t7/verify" => "/api/v1/authn/factors/{id}/verify"
+func Endpoint(path string) string {
+	segments := strings.Split(path, "/")
+	for i := 1; i < len(segments); i++ {
+		if collections[segments[i-1]] && segments[i] != "" && segments[i] != "me" {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(request *http.Request) (*http.Response, error) {
+	return f(request)
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+	"github.com/wearefair/okta-auth/oktatest"
+)
+
+func TestEndpoint(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"/api/v1/authn", "/api/v1/authn"},
+		{"/api/v1/authn/factors/sms59eptnqQ7XZ2xe1t7/verify", "/api/v1/authn/factors/{id}/verify"},
+		{"/api/v1/authn/factors/sms59eptnqQ7XZ2xe1t7/verify/resend", "/api/v1/authn/factors/{id}/verify/resend"},
+		{"/api/v1/sessions/me", "/api/v1/sessions/me"},
+		{"/api/v1/sessions/102GALFyfg5QaW5Hm8KfOKz3g", "/api/v1/sessions/{id}"},
+		{"/api/v1/sessions/", "/api/v1/sessions/"},
+	}
+
+	for i, testCase := range testCases {
+		actual := Endpoint(testCase.input)
+		if actual != testCase.expected {
+			t.Errorf("%0d: expected %q, got %q", i, testCase.expected, actual)
+		}
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/authn":
+			fmt.Fprintf(w, `{"status":"SUCCESS","sessionToken":"token"}`)
+		default:
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	m, err := New(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := okta.ClientConfig{OktaDomain: server.URL, Prompts: oktatest.NewPrompts(t)}
+	m.Instrument(&conf)
+	client, err := okta.New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Authenticate("first@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := testutil.ToFloat64(m.authentications.WithLabelValues(outcomeSuccess)); actual != 1 {
+		t.Errorf("expected 1 successful authentication, got %v", actual)
+	}
+	if actual := testutil.CollectAndCount(m.httpRequestDuration); actual != 1 {
+		t.Errorf("expected 1 http request series, got %v", actual)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/authn/factors/abc/verify", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := conf.RoundTripper.RoundTrip(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if actual := testutil.ToFloat64(m.httpRateLimited.WithLabelValues(http.MethodPost, "/api/v1/authn/factors/{id}/verify")); actual != 1 {
+		t.Errorf("expected 1 rate limited request, got %v", actual)
+	}
+}
+
+func TestFactorResult(t *testing.T) {
+	m, err := New(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push := factors.Factor{FactorType: factors.FactorTypePush, Provider: "OKTA"}
+	m.FactorResult(okta.FactorResultEvent{Factor: push, Result: api.FactorResultSuccess, Duration: 4 * time.Second})
+	m.FactorResult(okta.FactorResultEvent{Factor: push, Result: api.FactorResultRejected, Duration: 2 * time.Second})
+	m.FactorResult(okta.FactorResultEvent{Factor: push, Result: api.FactorResultTimeout, Duration: 30 * time.Second})
+
+	for _, result := range []api.FactorResult{api.FactorResultSuccess, api.FactorResultRejected, api.FactorResultTimeout} {
+		if actual := testutil.ToFloat64(m.factorResults.WithLabelValues("push", "OKTA", string(result))); actual != 1 {
+			t.Errorf("expected 1 %s result, got %v", result, actual)
+		}
+	}
+	// Timeouts aren't an approval, so aren't recorded in the latency
+	if actual := testutil.CollectAndCount(m.pushApprovalDuration); actual != 2 {
+		t.Errorf("expected 2 push approval series, got %v", actual)
+	}
+}
+
+func TestNewRegistersOnce(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if _, err := New(registry); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(registry); err == nil {
+		t.Error("expected error registering metrics twice")
+	}
+}
@@ -0,0 +1,196 @@
+package okta
+
+import (
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// Receives events as an authentication flow progresses, for example to feed an
+// audit log, metrics, or a progress UI.
+//
+// The methods are called synchronously from the authentication flow, so they
+// should return quickly. Embed NopObserver to only implement the events you need.
+type Observer interface {
+	// Called before the username and password are posted to Okta.
+	PrimaryAuthStarted(PrimaryAuthEvent)
+
+	// Called every time Okta returns a transaction in a different state.
+	StateTransitioned(StateTransitionEvent)
+
+	// Called when a factor has been chosen for verification, either by the
+	// user or automatically.
+	FactorChosen(FactorEvent)
+
+	// Called when the user is challenged to verify the chosen factor.
+	ChallengeIssued(FactorEvent)
+
+	// Called when the verification of the challenged factor has finished.
+	FactorResult(FactorResultEvent)
+
+	// Called before a request is retried, ex: when polling for the result of a push.
+	HTTPRetry(HTTPRetryEvent)
+
+	// Called when the authentication flow has finished, successfully or not.
+	AuthenticationFinished(AuthenticationFinishedEvent)
+}
+
+type PrimaryAuthEvent struct {
+	Username string
+}
+
+type StateTransitionEvent struct {
+	// The previous state, empty for the first transaction of the flow.
+	From api.TransactionState
+	To   api.TransactionState
+}
+
+type FactorEvent struct {
+	Factor factors.Factor
+	// Set when the factor was chosen without asking the user.
+	Automatic bool
+}
+
+type FactorResultEvent struct {
+	Factor factors.Factor
+	Result api.FactorResult
+	// Time between the challenge being issued and the result.
+	Duration time.Duration
+}
+
+type HTTPRetryEvent struct {
+	Method string
+	URL    string
+	// The number of the upcoming attempt, starting at 2 for the first retry.
+	Attempt int
+	// How long the client waits before retrying.
+	Delay time.Duration
+}
+
+type AuthenticationFinishedEvent struct {
+	Username string
+	// Nil when the authentication succeeded.
+	Err      error
+	Duration time.Duration
+}
+
+// An Observer that ignores all events.
+type NopObserver struct{}
+
+func (NopObserver) PrimaryAuthStarted(PrimaryAuthEvent)                {}
+func (NopObserver) StateTransitioned(StateTransitionEvent)             {}
+func (NopObserver) FactorChosen(FactorEvent)                           {}
+func (NopObserver) ChallengeIssued(FactorEvent)                        {}
+func (NopObserver) FactorResult(FactorResultEvent)                     {}
+func (NopObserver) HTTPRetry(HTTPRetryEvent)                           {}
+func (NopObserver) AuthenticationFinished(AuthenticationFinishedEvent) {}
+
+// Notifies the observer if the transaction is in a different state than the last one.
+func (c *OktaClient) observeTransition(flow *authFlow, transaction api.AuthenticationTransaction) {
+	if transaction.Status == flow.state {
+		return
+	}
+	c.observer.StateTransitioned(StateTransitionEvent{From: flow.state, To: transaction.Status})
+	c.startStateSpan(flow, transaction.Status)
+	flow.state = transaction.Status
+}
+
+func (c *OktaClient) observeFactorChosen(factor api.Factor, automatic bool) {
+	c.observer.FactorChosen(FactorEvent{Factor: factor.Public(), Automatic: automatic})
+}
+
+func (c *OktaClient) observeChallengeIssued(flow *authFlow, factor api.Factor) {
+	flow.challengeFactor = factor.Public()
+	flow.challengeStart = time.Now()
+	c.startChallengeSpan(flow)
+	c.observer.ChallengeIssued(FactorEvent{Factor: flow.challengeFactor})
+}
+
+func (c *OktaClient) observeFactorResult(flow *authFlow, result api.FactorResult) {
+	c.endChallengeSpan(flow, result)
+	c.rememberFactorResult(flow, result)
+	c.observer.FactorResult(FactorResultEvent{
+		Factor:   flow.challengeFactor,
+		Result:   result,
+		Duration: time.Since(flow.challengeStart),
+	})
+}
+
+// Reports the result of a verify request that Okta accepted.
+// A successful verification doesn't carry a factor result.
+func (c *OktaClient) observeVerifiedTransaction(flow *authFlow, transaction api.AuthenticationTransaction) {
+	if transaction.FactorResult != "" {
+		c.observeFactorResult(flow, transaction.FactorResult)
+	} else {
+		c.observeFactorResult(flow, api.FactorResultSuccess)
+	}
+}
+
+// Reports the result of a verify request that Okta rejected.
+func (c *OktaClient) observeVerifyError(flow *authFlow, apiError *api.APIError) {
+	if apiError.ErrorCode == api.ErrorCodePasscodeReplayed {
+		c.observeFactorResult(flow, api.FactorResultPasscodeReplayed)
+	} else {
+		c.observeFactorResult(flow, api.FactorResultError)
+	}
+}
+
+// Returns a backoff notification that reports each retry of the request to the observer.
+func (c *OktaClient) observeHTTPRetries(method, url string) backoff.Notify {
+	attempt := 1
+	return func(_ error, delay time.Duration) {
+		attempt++
+		c.observer.HTTPRetry(HTTPRetryEvent{Method: method, URL: url, Attempt: attempt, Delay: delay})
+	}
+}
+
+// Returns an Observer that passes every event to each of the given observers in order.
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) PrimaryAuthStarted(e PrimaryAuthEvent) {
+	for _, o := range m {
+		o.PrimaryAuthStarted(e)
+	}
+}
+
+func (m multiObserver) StateTransitioned(e StateTransitionEvent) {
+	for _, o := range m {
+		o.StateTransitioned(e)
+	}
+}
+
+func (m multiObserver) FactorChosen(e FactorEvent) {
+	for _, o := range m {
+		o.FactorChosen(e)
+	}
+}
+
+func (m multiObserver) ChallengeIssued(e FactorEvent) {
+	for _, o := range m {
+		o.ChallengeIssued(e)
+	}
+}
+
+func (m multiObserver) FactorResult(e FactorResultEvent) {
+	for _, o := range m {
+		o.FactorResult(e)
+	}
+}
+
+func (m multiObserver) HTTPRetry(e HTTPRetryEvent) {
+	for _, o := range m {
+		o.HTTPRetry(e)
+	}
+}
+
+func (m multiObserver) AuthenticationFinished(e AuthenticationFinishedEvent) {
+	for _, o := range m {
+		o.AuthenticationFinished(e)
+	}
+}
@@ -0,0 +1,187 @@
+package okta
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+func TestObserverEvents(t *testing.T) {
+	server := newSMSTestServer()
+	defer server.Close()
+
+	observer := &recordingObserver{}
+	client, err := New(ClientConfig{OktaDomain: server.URL, Prompts: TestPrompts{}, Observer: observer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Authenticate("first@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	sms := factors.Factor{
+		Id:         "sms1",
+		FactorType: factors.FactorTypeSMS,
+		Provider:   "OKTA",
+		ProfileSMS: &factors.ProfileSMS{PhoneNumber: "+1 XXX-XXX-5555"},
+	}
+	expected := []interface{}{
+		PrimaryAuthEvent{Username: "first@example.com"},
+		StateTransitionEvent{From: "", To: api.StateMFARequired},
+		FactorEvent{Factor: sms},
+		StateTransitionEvent{From: api.StateMFARequired, To: api.StateMFAChallenge},
+		FactorEvent{Factor: sms},
+		FactorResultEvent{Factor: sms, Result: api.FactorResultSuccess},
+		StateTransitionEvent{From: api.StateMFAChallenge, To: api.StateSuccess},
+		AuthenticationFinishedEvent{Username: "first@example.com"},
+	}
+	if !reflect.DeepEqual(observer.events, expected) {
+		t.Errorf("Expected:\n    %#+v\nActual:\n    %#+v\n", expected, observer.events)
+	}
+}
+
+func TestObserverHTTPRetry(t *testing.T) {
+	defer func(interval time.Duration) { pushPollInterval = interval }(pushPollInterval)
+	pushPollInterval = time.Millisecond
+
+	// The push is approved on the fifth request, after the verify request, the
+	// first poll, and two retries of the poll
+	var polls int
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/authn":
+			fmt.Fprintf(w, `{
+				"stateToken": "state",
+				"status": "MFA_REQUIRED",
+				"_embedded": {"factors": [{
+					"id": "push1", "factorType": "push", "provider": "OKTA",
+					"_links": {"verify": {"href": "%[1]s/api/v1/authn/factors/push1/verify"}}
+				}]}
+			}`, server.URL)
+		case "/api/v1/authn/factors/push1/verify":
+			polls++
+			if polls > 4 {
+				fmt.Fprintf(w, `{"status": "SUCCESS", "sessionToken": "token"}`)
+				return
+			}
+			fmt.Fprintf(w, `{
+				"stateToken": "state",
+				"status": "MFA_CHALLENGE",
+				"factorResult": "WAITING",
+				"_embedded": {"factor": {"id": "push1", "factorType": "push", "provider": "OKTA"}},
+				"_links": {"poll": {"href": "%[1]s/api/v1/authn/factors/push1/verify"}}
+			}`, server.URL)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	observer := &recordingObserver{}
+	client, err := New(ClientConfig{OktaDomain: server.URL, Prompts: TestPrompts{}, Observer: observer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Authenticate("first@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	var retries []HTTPRetryEvent
+	for _, event := range observer.events {
+		if retry, ok := event.(HTTPRetryEvent); ok {
+			retries = append(retries, retry)
+		}
+	}
+	pollURL := server.URL + "/api/v1/authn/factors/push1/verify"
+	expected := []HTTPRetryEvent{
+		{Method: http.MethodPost, URL: pollURL, Attempt: 2, Delay: time.Millisecond},
+		{Method: http.MethodPost, URL: pollURL, Attempt: 3, Delay: time.Millisecond},
+	}
+	if !reflect.DeepEqual(retries, expected) {
+		t.Errorf("Expected:\n    %#+v\nActual:\n    %#+v\n", expected, retries)
+	}
+}
+
+// Records events with durations zeroed so they can be compared.
+type recordingObserver struct {
+	events []interface{}
+}
+
+func (o *recordingObserver) PrimaryAuthStarted(e PrimaryAuthEvent) {
+	o.events = append(o.events, e)
+}
+
+func (o *recordingObserver) StateTransitioned(e StateTransitionEvent) {
+	o.events = append(o.events, e)
+}
+
+func (o *recordingObserver) FactorChosen(e FactorEvent) {
+	o.events = append(o.events, e)
+}
+
+func (o *recordingObserver) ChallengeIssued(e FactorEvent) {
+	o.events = append(o.events, e)
+}
+
+func (o *recordingObserver) FactorResult(e FactorResultEvent) {
+	e.Duration = 0
+	o.events = append(o.events, e)
+}
+
+func (o *recordingObserver) HTTPRetry(e HTTPRetryEvent) {
+	o.events = append(o.events, e)
+}
+
+func (o *recordingObserver) AuthenticationFinished(e AuthenticationFinishedEvent) {
+	e.Duration = 0
+	o.events = append(o.events, e)
+}
+
+// Returns a server for a user with a single SMS factor.
+// Any code is accepted, and the trace context of each request is kept in its header.
+func newSMSTestServer() *smsTestServer {
+	server := &smsTestServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.traceparents = append(server.traceparents, r.Header.Get("traceparent"))
+
+		switch r.URL.Path {
+		case "/api/v1/authn":
+			fmt.Fprintf(w, `{
+				"stateToken": "state",
+				"status": "MFA_REQUIRED",
+				"_embedded": {"factors": [{
+					"id": "sms1", "factorType": "sms", "provider": "OKTA",
+					"profile": {"phoneNumber": "+1 XXX-XXX-5555"},
+					"_links": {"verify": {"href": "%[1]s/api/v1/authn/factors/sms1/verify"}}
+				}]}
+			}`, server.URL)
+		case "/api/v1/authn/factors/sms1/verify":
+			fmt.Fprintf(w, `{
+				"stateToken": "state",
+				"status": "MFA_CHALLENGE",
+				"_embedded": {"factor": {
+					"id": "sms1", "factorType": "sms", "provider": "OKTA",
+					"profile": {"phoneNumber": "+1 XXX-XXX-5555"}
+				}},
+				"_links": {"next": {"name": "verify", "href": "%[1]s/api/v1/authn/factors/sms1/verify/code"}}
+			}`, server.URL)
+		case "/api/v1/authn/factors/sms1/verify/code":
+			fmt.Fprintf(w, `{"status": "SUCCESS", "sessionToken": "token"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+type smsTestServer struct {
+	*httptest.Server
+	traceparents []string
+}
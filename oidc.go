@@ -0,0 +1,242 @@
+package okta
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Requests OIDC tokens for an authenticated user.
+type TokenRequest struct {
+	// The authorization server's issuer.
+	// Defaults to the org's default authorization server, https://<domain>/oauth2/default.
+	Issuer      string
+	ClientId    string
+	RedirectURI string
+	// Defaults to "openid". Include "offline_access" for a refresh token.
+	Scopes []string
+
+	// Authorizes as the user the session token was issued to, see Authenticate.
+	SessionToken string
+	// Authorizes as the user of the session, see CreateSession. Ignored if SessionToken is set.
+	SessionId string
+}
+
+// Tokens issued by the authorization server.
+// https://developer.okta.com/docs/reference/api/oidc/#response-properties-2
+type Tokens struct {
+	TokenType    string `json:"token_type"`
+	AccessToken  string `json:"access_token"`
+	IdToken      string `json:"id_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	// Seconds until the access token expires, from when it was issued.
+	ExpiresIn int `json:"expires_in"`
+	// When the access token expires.
+	Expiry time.Time `json:"expiry"`
+}
+
+// An error returned by the authorization server.
+// https://developer.okta.com/docs/reference/api/oidc/#possible-errors
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+// Returns OIDC tokens for the user of the session token or session, using the
+// authorization code flow with PKCE. The client must allow the authorization code
+// grant, and the redirect uri, but the redirect is never followed.
+// https://developer.okta.com/docs/guides/implement-grant-type/authcodepkce/main/
+func (c *OktaClient) Tokens(ctx context.Context, request TokenRequest) (*Tokens, error) {
+	if request.SessionToken == "" && request.SessionId == "" {
+		return nil, errors.New("a session token or session id is required")
+	}
+	issuer := strings.TrimSuffix(request.Issuer, "/")
+	if issuer == "" {
+		issuer = c.rootURL + "/oauth2/default"
+	}
+	scopes := request.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+
+	verifier, err := randomURLSafe(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomURLSafe(16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomURLSafe(16)
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"client_id":             {request.ClientId},
+		"response_type":         {"code"},
+		"response_mode":         {"query"},
+		"scope":                 {strings.Join(scopes, " ")},
+		"redirect_uri":          {request.RedirectURI},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"prompt":                {"none"},
+	}
+	header := http.Header{}
+	if request.SessionToken != "" {
+		query.Set("sessionToken", request.SessionToken)
+	} else {
+		header.Set("Cookie", (&http.Cookie{Name: sessionCookieName, Value: request.SessionId}).String())
+	}
+
+	code, err := c.authorize(ctx, issuer+"/v1/authorize?"+query.Encode(), header, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.exchangeCode(ctx, issuer+"/v1/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {request.ClientId},
+		"redirect_uri":  {request.RedirectURI},
+		"code":          {code},
+		"code_verifier": {verifier},
+	})
+}
+
+// Sends the authorization request, and returns the code from the redirect.
+func (c *OktaClient) authorize(ctx context.Context, authorizeURL string, header http.Header, state string) (string, error) {
+	// The redirect is to the client, so isn't followed
+	client := *c.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	response, _, err := c.sendOAuthRequest(ctx, &client, http.MethodGet, authorizeURL, header, "")
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusFound && response.StatusCode != http.StatusSeeOther {
+		return "", fmt.Errorf("authorize request returned status %d, expected a redirect", response.StatusCode)
+	}
+
+	location, err := response.Location()
+	if err != nil {
+		return "", fmt.Errorf("authorize request returned an invalid redirect: %s", err)
+	}
+	redirect := location.Query()
+	if code := redirect.Get("error"); code != "" {
+		return "", &OAuthError{Code: code, Description: redirect.Get("error_description")}
+	}
+	if redirect.Get("state") != state {
+		return "", errors.New("authorize request returned a redirect for a different state")
+	}
+	if redirect.Get("code") == "" {
+		return "", errors.New("authorize request returned a redirect without a code")
+	}
+	return redirect.Get("code"), nil
+}
+
+// Exchanges the authorization code for tokens.
+func (c *OktaClient) exchangeCode(ctx context.Context, tokenURL string, form url.Values) (*Tokens, error) {
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	issued := time.Now()
+	response, body, err := c.sendOAuthRequest(ctx, c.httpClient, http.MethodPost, tokenURL, header, form.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		oauthError := &OAuthError{}
+		if err := json.Unmarshal(body, oauthError); err != nil || oauthError.Code == "" {
+			return nil, fmt.Errorf("token request returned status %d", response.StatusCode)
+		}
+		return nil, oauthError
+	}
+
+	tokens := &Tokens{}
+	if err := json.Unmarshal(body, tokens); err != nil {
+		c.requestLogger(http.MethodPost, tokenURL).Error("Got error unmarshaling tokens", errorLogAttr(err))
+		return nil, TerminalError(unexpectedErrorMessage)
+	}
+	tokens.Expiry = issued.Add(time.Duration(tokens.ExpiresIn) * time.Second)
+	return tokens, nil
+}
+
+// Sends a request to the authorization server, an app or Duo, returning the response
+// and its body. Bodies aren't logged, as they contain codes, tokens and assertions.
+func (c *OktaClient) sendOAuthRequest(ctx context.Context, client *http.Client, method, rawURL string, header http.Header, body string) (*http.Response, []byte, error) {
+	logger := c.requestLogger(method, rawURL)
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, rawURL, reader)
+	if err != nil {
+		logger.Error("Error creating request", errorLogAttr(err))
+		return nil, nil, err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	if request.Header.Get("Accept") == "" {
+		request.Header.Set("Accept", "application/json")
+	}
+	logger.Debug("Sending http request", slog.Any("headers", c.redactor.header(request.Header)))
+
+	ctx, span := c.startRequestSpan(ctx, request)
+	request = request.WithContext(ctx)
+
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		err = redactURLError(err)
+		endRequestSpan(span, nil, err)
+		logger.Error("Error sending request", latencyLogAttr(start), errorLogAttr(err))
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	endRequestSpan(span, response, err)
+	if err != nil {
+		logger.Error("Error reading response body", latencyLogAttr(start), errorLogAttr(err))
+		return nil, nil, err
+	}
+	logger.Debug("Got http response",
+		slog.Int(LogKeyStatusCode, response.StatusCode),
+		latencyLogAttr(start),
+		slog.String(LogKeyRequestId, response.Header.Get(oktaRequestIdHeader)))
+	return response, responseBody, nil
+}
+
+// Returns n random bytes, encoded as websafe base64.
+func randomURLSafe(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,263 @@
+package oktatest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	okta "github.com/wearefair/okta-auth"
+)
+
+// Client data types, as defined by the U2F and WebAuthn specs.
+const (
+	u2fClientDataType      = "navigator.id.getAssertion"
+	webAuthnClientDataType = "webauthn.get"
+)
+
+// Flags set in signatures. User presence is the only one that is required.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+)
+
+// A software security key, holding a single ECDSA P-256 credential in memory.
+//
+// VerifyU2F signs challenges the same way a hardware key does, so it can be used
+// as the Prompts callback against the fake server or a real org. Register the
+// key with the fake server using U2FFactor or WebAuthnFactor.
+type Authenticator struct {
+	// The key handle (U2F) or credential id (WebAuthn) of the credential.
+	CredentialId string
+	// Returned as the authenticatorName of WebAuthn factors, ex: "YubiKey 5 NFC".
+	Name string
+
+	key *ecdsa.PrivateKey
+
+	mu      sync.Mutex
+	counter uint32
+}
+
+// Returns an authenticator with a newly generated key and credential id.
+func NewAuthenticator() *Authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("oktatest: failed to generate key: %s", err))
+	}
+	return &Authenticator{CredentialId: randomId(), key: key}
+}
+
+func (a *Authenticator) PublicKey() *ecdsa.PublicKey {
+	return &a.key.PublicKey
+}
+
+// Signs the challenge in the request, returning the response a hardware key would.
+// Returns an error if the request is for a different credential, as a key doesn't
+// respond to challenges for credentials it doesn't hold.
+//
+// U2F responses are encoded as websafe base64, as the U2F JavaScript API does.
+// WebAuthn responses are encoded as standard base64, as the Okta sign-in widget does.
+func (a *Authenticator) VerifyU2F(ctx context.Context, request okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return okta.VerifyU2FResponse{}, err
+	}
+	if request.KeyHandle != a.CredentialId {
+		return okta.VerifyU2FResponse{}, fmt.Errorf("oktatest: unknown credential %q", request.KeyHandle)
+	}
+
+	a.mu.Lock()
+	a.counter++
+	counter := a.counter
+	a.mu.Unlock()
+
+	if request.WebAuthn {
+		return a.signWebAuthn(request, counter)
+	}
+	return a.signU2F(request, counter)
+}
+
+// https://fidoalliance.org/specs/fido-u2f-v1.2-ps-20170411/fido-u2f-raw-message-formats-v1.2-ps-20170411.html#authentication-response-message-success
+func (a *Authenticator) signU2F(request okta.VerifyU2FRequest, counter uint32) (okta.VerifyU2FResponse, error) {
+	clientData, err := json.Marshal(clientData{
+		Typ:       u2fClientDataType,
+		Challenge: request.Challenge,
+		Origin:    request.Facet,
+	})
+	if err != nil {
+		return okta.VerifyU2FResponse{}, err
+	}
+
+	// The signature is over the hashes of the app id and client data, with the
+	// flags and counter, which are also returned before it.
+	signed := signedCounter(flagUserPresent, counter)
+	signature, err := a.sign(sha256Sum([]byte(request.AppId)), signed, sha256Sum(clientData))
+	if err != nil {
+		return okta.VerifyU2FResponse{}, err
+	}
+
+	return okta.VerifyU2FResponse{
+		ClientData:    base64.RawURLEncoding.EncodeToString(clientData),
+		SignatureData: base64.RawURLEncoding.EncodeToString(append(signed, signature...)),
+	}, nil
+}
+
+// https://www.w3.org/TR/webauthn-2/#sctn-op-get-assertion
+func (a *Authenticator) signWebAuthn(request okta.VerifyU2FRequest, counter uint32) (okta.VerifyU2FResponse, error) {
+	clientData, err := json.Marshal(clientData{
+		Type:      webAuthnClientDataType,
+		Challenge: request.Challenge,
+		Origin:    request.Facet,
+	})
+	if err != nil {
+		return okta.VerifyU2FResponse{}, err
+	}
+
+	// The authenticator data is the hash of the relying party id, followed by the flags and counter.
+	authenticatorData := append(sha256Sum([]byte(request.AppId)), signedCounter(flagUserPresent|flagUserVerified, counter)...)
+	signature, err := a.sign(authenticatorData, sha256Sum(clientData))
+	if err != nil {
+		return okta.VerifyU2FResponse{}, err
+	}
+
+	return okta.VerifyU2FResponse{
+		ClientData:        base64.StdEncoding.EncodeToString(clientData),
+		SignatureData:     base64.StdEncoding.EncodeToString(signature),
+		AuthenticatorData: base64.StdEncoding.EncodeToString(authenticatorData),
+	}, nil
+}
+
+// Returns the DER encoded signature of the SHA-256 hash of the concatenated parts.
+func (a *Authenticator) sign(parts ...[]byte) ([]byte, error) {
+	var message []byte
+	for _, part := range parts {
+		message = append(message, part...)
+	}
+	return ecdsa.SignASN1(rand.Reader, a.key, sha256Sum(message))
+}
+
+// The client data of U2F and WebAuthn assertions, which use different names for the type.
+type clientData struct {
+	Typ       string `json:"typ,omitempty"`
+	Type      string `json:"type,omitempty"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// An assertion to be checked against a registered credential.
+type assertion struct {
+	// The app id (U2F) or relying party id (WebAuthn) the credential is scoped to.
+	appId     string
+	challenge string
+	origin    string
+
+	clientData        string
+	signatureData     string
+	authenticatorData string
+}
+
+// Verifies a U2F assertion was signed by the key, and returns its counter.
+func verifyU2FAssertion(key *ecdsa.PublicKey, a assertion) (uint32, error) {
+	clientData, err := verifyClientData(a, u2fClientDataType)
+	if err != nil {
+		return 0, err
+	}
+	signatureData, err := decodeBase64(a.signatureData)
+	if err != nil {
+		return 0, fmt.Errorf("signatureData: %s", err)
+	}
+	if len(signatureData) < 5 {
+		return 0, errors.New("signatureData is too short")
+	}
+
+	signed, signature := signatureData[:5], signatureData[5:]
+	message := append(append(sha256Sum([]byte(a.appId)), signed...), sha256Sum(clientData)...)
+	return verifySignature(key, signed, message, signature)
+}
+
+// Verifies a WebAuthn assertion was signed by the key, and returns its counter.
+func verifyWebAuthnAssertion(key *ecdsa.PublicKey, a assertion) (uint32, error) {
+	clientData, err := verifyClientData(a, webAuthnClientDataType)
+	if err != nil {
+		return 0, err
+	}
+	authenticatorData, err := decodeBase64(a.authenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("authenticatorData: %s", err)
+	}
+	if len(authenticatorData) < sha256.Size+5 {
+		return 0, errors.New("authenticatorData is too short")
+	}
+	if string(authenticatorData[:sha256.Size]) != string(sha256Sum([]byte(a.appId))) {
+		return 0, errors.New("authenticatorData is for a different relying party")
+	}
+	signature, err := decodeBase64(a.signatureData)
+	if err != nil {
+		return 0, fmt.Errorf("signatureData: %s", err)
+	}
+
+	message := append(authenticatorData, sha256Sum(clientData)...)
+	return verifySignature(key, authenticatorData[sha256.Size:sha256.Size+5], message, signature)
+}
+
+// Decodes the client data, and checks it is for the expected challenge and origin.
+func verifyClientData(a assertion, expectedType string) ([]byte, error) {
+	decoded, err := decodeBase64(a.clientData)
+	if err != nil {
+		return nil, fmt.Errorf("clientData: %s", err)
+	}
+	var data clientData
+	if err := json.Unmarshal(decoded, &data); err != nil {
+		return nil, fmt.Errorf("clientData: %s", err)
+	}
+
+	switch {
+	case data.Typ+data.Type != expectedType:
+		return nil, fmt.Errorf("clientData has type %q, expected %q", data.Typ+data.Type, expectedType)
+	case data.Challenge != a.challenge:
+		return nil, errors.New("clientData is for a different challenge")
+	case data.Origin != a.origin:
+		return nil, fmt.Errorf("clientData has origin %q, expected %q", data.Origin, a.origin)
+	}
+	return decoded, nil
+}
+
+// Verifies the signature of the message, and returns the counter from the signed flags and counter.
+func verifySignature(key *ecdsa.PublicKey, signed, message, signature []byte) (uint32, error) {
+	if signed[0]&flagUserPresent == 0 {
+		return 0, errors.New("user presence flag is not set")
+	}
+	if !ecdsa.VerifyASN1(key, sha256Sum(message), signature) {
+		return 0, errors.New("invalid signature")
+	}
+	return binary.BigEndian.Uint32(signed[1:]), nil
+}
+
+// Returns the flags byte followed by the big endian counter.
+func signedCounter(flags byte, counter uint32) []byte {
+	signed := make([]byte, 5)
+	signed[0] = flags
+	binary.BigEndian.PutUint32(signed[1:], counter)
+	return signed
+}
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+// Decodes standard or websafe base64, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
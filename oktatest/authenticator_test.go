@@ -0,0 +1,126 @@
+package oktatest
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	okta "github.com/wearefair/okta-auth"
+)
+
+func TestAuthenticator(t *testing.T) {
+	authenticator := NewAuthenticator()
+
+	testCases := []struct {
+		name     string
+		webAuthn bool
+		// Modifies the signed assertion so it should be rejected, or nil if it should be verified.
+		modify func(*assertion)
+	}{
+		{name: "u2f"},
+		{name: "u2f different challenge", modify: func(a *assertion) { a.challenge = "other" }},
+		{name: "u2f different origin", modify: func(a *assertion) { a.origin = "https://other.okta.com" }},
+		{name: "u2f different app id", modify: func(a *assertion) { a.appId = "https://other.okta.com" }},
+		{name: "u2f tampered signature", modify: func(a *assertion) { a.signatureData = tamper(a.signatureData) }},
+		{name: "webauthn", webAuthn: true},
+		{name: "webauthn different challenge", webAuthn: true, modify: func(a *assertion) { a.challenge = "other" }},
+		{name: "webauthn different origin", webAuthn: true, modify: func(a *assertion) { a.origin = "https://other.okta.com" }},
+		{name: "webauthn different relying party", webAuthn: true, modify: func(a *assertion) { a.appId = "other.okta.com" }},
+		{name: "webauthn tampered signature", webAuthn: true, modify: func(a *assertion) { a.signatureData = tamper(a.signatureData) }},
+		{name: "webauthn tampered authenticator data", webAuthn: true, modify: func(a *assertion) { a.authenticatorData = tamper(a.authenticatorData) }},
+	}
+
+	var lastCounter uint32
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			request := okta.VerifyU2FRequest{
+				Facet:     "https://example.okta.com",
+				AppId:     "https://example.okta.com",
+				KeyHandle: authenticator.CredentialId,
+				Challenge: randomId(),
+				WebAuthn:  testCase.webAuthn,
+			}
+			verify := verifyU2FAssertion
+			if testCase.webAuthn {
+				request.AppId = "example.okta.com"
+				verify = verifyWebAuthnAssertion
+			}
+
+			response, err := authenticator.VerifyU2F(context.Background(), request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := assertion{
+				appId:             request.AppId,
+				challenge:         request.Challenge,
+				origin:            request.Facet,
+				clientData:        response.ClientData,
+				signatureData:     response.SignatureData,
+				authenticatorData: response.AuthenticatorData,
+			}
+			if testCase.modify != nil {
+				testCase.modify(&a)
+				if _, err := verify(authenticator.PublicKey(), a); err == nil {
+					t.Error("expected the modified assertion to be rejected")
+				}
+				return
+			}
+
+			counter, err := verify(authenticator.PublicKey(), a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if counter <= lastCounter {
+				t.Errorf("expected the counter to increase from %d, got %d", lastCounter, counter)
+			}
+			lastCounter = counter
+		})
+	}
+
+	t.Run("unknown credential", func(t *testing.T) {
+		if _, err := authenticator.VerifyU2F(context.Background(), okta.VerifyU2FRequest{KeyHandle: "other"}); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestVerifyU2FReplay(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	authenticator := NewAuthenticator()
+	user := server.AddUser(&User{Login: "first@example.com", Password: "password", Factors: []*Factor{WebAuthnFactor(authenticator)}})
+	factor := user.Factors[0]
+
+	// The counter starts above the authenticator's, as if the key had been cloned.
+	factor.signCount = 10
+
+	client, err := okta.New(okta.ClientConfig{
+		OktaDomain: server.URL,
+		Prompts: NewPrompts(t,
+			ExpectCheckU2FPresence(true),
+			ExpectVerifyU2FFunc(authenticator.VerifyU2F),
+			ExpectUserError("Invalid Passcode/Answer"),
+			ExpectChooseFactorError(context.Canceled),
+		),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Authenticate(user.Login, user.Password); err == nil {
+		t.Error("expected the signature to be rejected")
+	}
+}
+
+// Flips a bit in the last byte of the base64 encoded data, keeping its encoding.
+func tamper(data string) string {
+	decoded, err := decodeBase64(data)
+	if err != nil {
+		panic(err)
+	}
+	decoded[len(decoded)-1] ^= 0x01
+	if strings.ContainsAny(data, "+/=") {
+		return base64.StdEncoding.EncodeToString(decoded)
+	}
+	return base64.RawURLEncoding.EncodeToString(decoded)
+}
@@ -0,0 +1,32 @@
+// Provides an in-process fake of the Okta authentication API for testing.
+//
+// The fake implements the /api/v1/authn state machine, factor verification
+// (including push polling and cancellation), sessions, OIDC authorization code
+// flows and SAML app embed links. Users and their
+// factors are scripted up front, and the fake can inject failures such as
+// rate limiting.
+//
+// U2F and WebAuthn factors are backed by an Authenticator, a software security
+// key whose signatures the server verifies against the registered public key.
+// Duo web factors are verified with a DuoServer, a fake of the Duo endpoints
+// the client answers Duo's prompt through.
+//
+// The server's URL can be used directly as the OktaDomain of a client, and
+// Prompts plays back the interactions the test expects of the user:
+//
+//	server := oktatest.NewServer()
+//	defer server.Close()
+//
+//	server.AddUser(&oktatest.User{
+//		Login:    "first@example.com",
+//		Password: "correct-horse",
+//		Factors:  []*oktatest.Factor{oktatest.SMSFactor("+1 XXX-XXX-5555", "123456")},
+//	})
+//
+//	prompts := oktatest.NewPrompts(t,
+//		oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+//		oktatest.ExpectVerifyCode("123456"),
+//	)
+//
+//	client, err := okta.New(okta.ClientConfig{OktaDomain: server.URL, Prompts: prompts})
+package oktatest
@@ -0,0 +1,315 @@
+package oktatest
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// How long Duo's signed requests and responses are valid for.
+const duoSignatureLifetime = 5 * time.Minute
+
+// A fake of the Duo Security frame endpoints that Duo's prompt uses, served over
+// HTTPS, for verifying DUO web factors. Add a DuoFactor for the server to a user
+// of the Okta fake, and use the Client method's transport as the client's
+// RoundTripper so the server's certificate is trusted.
+//
+// Like Okta, the fake signs the request for each challenge, and Duo's response
+// is only accepted by Okta if it is signed with the same key.
+type DuoServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	ikey     string
+	skey     []byte
+	pending  map[string]*duoRequest
+	sessions map[string]*duoSession
+	requests []string
+}
+
+// A signed request for a challenge, which starts a prompt session.
+type duoRequest struct {
+	username string
+	factor   *Factor
+}
+
+// A prompt session, and the authentication started from it.
+type duoSession struct {
+	request *duoRequest
+	txid    string
+	factor  string
+	// The passcode entered, for passcode authentications.
+	passcode string
+	polls    int
+}
+
+// Starts a new Duo server, which should be closed when finished.
+func NewDuoServer() *DuoServer {
+	s := &DuoServer{
+		ikey:     "DI" + strings.ToUpper(randomId()),
+		skey:     []byte(randomId() + randomId()),
+		pending:  map[string]*duoRequest{},
+		sessions: map[string]*duoSession{},
+	}
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+// Returns the host clients are given to reach the server, without the scheme.
+func (s *DuoServer) Host() string {
+	return strings.TrimPrefix(s.URL, "https://")
+}
+
+// Returns the method and path of each request the server has received, in order.
+// Ex: "POST /frame/prompt"
+func (s *DuoServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Returns a Duo web factor verified with the server, where the user answers a
+// push with the result, or enters the passcode.
+func DuoFactor(duo *DuoServer, push PushResult, passCode string) *Factor {
+	return &Factor{
+		FactorType: factors.FactorTypeWeb,
+		Provider:   "DUO",
+		Push:       push,
+		PassCode:   passCode,
+		Duo:        duo,
+	}
+}
+
+func (s *DuoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	if err := r.ParseForm(); err != nil {
+		writeDuoFailure(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/frame/web/v1/auth":
+		s.startPrompt(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/frame/prompt":
+		s.showPrompt(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/frame/prompt":
+		s.prompt(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/frame/status":
+		s.status(w, r)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/frame/status/"):
+		s.result(w, r, strings.TrimPrefix(r.URL.Path, "/frame/status/"))
+	default:
+		writeDuoFailure(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// Starts a prompt session for a signed request, redirecting to the prompt.
+func (s *DuoServer) startPrompt(w http.ResponseWriter, r *http.Request) {
+	tx := r.Form.Get("tx")
+	request, ok := s.pending[tx]
+	if !ok || s.verify("TX", tx) != request.username {
+		http.Error(w, "Invalid request signature", http.StatusBadRequest)
+		return
+	}
+	delete(s.pending, tx)
+
+	sid := randomId()
+	s.sessions[sid] = &duoSession{request: request}
+	http.Redirect(w, r, "/frame/prompt?"+url.Values{"sid": {sid}}.Encode(), http.StatusFound)
+}
+
+// Shows the prompt's form, listing the user's devices.
+func (s *DuoServer) showPrompt(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.sessions[r.Form.Get("sid")]
+	if !ok {
+		http.Error(w, "Invalid session", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, `<!DOCTYPE html><title>Duo Security</title><form id="login-form"><select name="device">`)
+	for _, device := range session.request.factor.duoDevices() {
+		fmt.Fprintf(w, `<option value="%s">%s</option>`, html.EscapeString(device), html.EscapeString(device))
+	}
+	fmt.Fprint(w, `</select></form>`)
+}
+
+// Sends a push or checks a passcode, starting an authentication whose status is polled.
+func (s *DuoServer) prompt(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.sessions[r.Form.Get("sid")]
+	if !ok {
+		writeDuoFailure(w, http.StatusOK, "Invalid session")
+		return
+	}
+	switch factor := r.Form.Get("factor"); factor {
+	case "Duo Push", "Passcode":
+		session.factor = factor
+	default:
+		writeDuoFailure(w, http.StatusOK, "Unsupported factor")
+		return
+	}
+	if !session.request.factor.hasDuoDevice(r.Form.Get("device")) {
+		writeDuoFailure(w, http.StatusOK, "Unknown device")
+		return
+	}
+	session.txid = randomId()
+	session.passcode = r.Form.Get("passcode")
+	session.polls = 0
+	writeDuoResponse(w, map[string]interface{}{"txid": session.txid})
+}
+
+// Reports the status of the authentication, and its result once it has one.
+func (s *DuoServer) status(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.sessions[r.Form.Get("sid")]
+	if !ok || session.txid == "" || session.txid != r.Form.Get("txid") {
+		writeDuoFailure(w, http.StatusOK, "Invalid transaction")
+		return
+	}
+	factor := session.request.factor
+
+	if session.factor == "Passcode" {
+		if session.passcode != factor.PassCode {
+			writeDuoStatus(w, "deny", "Incorrect passcode. Please try again.", "FAILURE", "")
+			return
+		}
+		writeDuoStatus(w, "allow", "Success. Logging you in...", "SUCCESS", "/frame/status/"+session.txid)
+		return
+	}
+
+	session.polls++
+	if session.polls <= factor.PushWaitPolls {
+		writeDuoStatus(w, "pushed", "Pushed a login request to your device...", "", "")
+		return
+	}
+	switch factor.Push {
+	case PushApprove:
+		writeDuoStatus(w, "allow", "Success. Logging you in...", "SUCCESS", "/frame/status/"+session.txid)
+	case PushReject:
+		writeDuoStatus(w, "deny", "Login request denied.", "FAILURE", "")
+	case PushTimeout:
+		writeDuoStatus(w, "timeout", "Login timed out.", "FAILURE", "")
+	default:
+		writeDuoStatus(w, "pushed", "Pushed a login request to your device...", "", "")
+	}
+}
+
+// Returns the signed response for a successful authentication, ending the session.
+func (s *DuoServer) result(w http.ResponseWriter, r *http.Request, txid string) {
+	sid := r.Form.Get("sid")
+	session, ok := s.sessions[sid]
+	if !ok || session.txid != txid {
+		writeDuoFailure(w, http.StatusOK, "Invalid transaction")
+		return
+	}
+	delete(s.sessions, sid)
+	writeDuoResponse(w, map[string]interface{}{
+		"cookie": s.sign("AUTH", session.request.username),
+	})
+}
+
+// Signs a request for the user to verify the factor, which is returned to the
+// client as the verification's signature, "TX|...:APP|...". Called by the Okta
+// fake when the factor is challenged.
+func (s *DuoServer) signRequest(username string, factor *Factor) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := s.sign("TX", username)
+	s.pending[tx] = &duoRequest{username: username, factor: factor}
+	return tx + ":" + s.sign("APP", username)
+}
+
+// Verifies a signed response, "AUTH|...:APP|...", for the user and the app
+// signature of the request.
+func (s *DuoServer) verifyResponse(username, app, sigResponse string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	auth, responseApp, ok := strings.Cut(sigResponse, ":")
+	return ok && responseApp == app && s.verify("AUTH", auth) == username && s.verify("APP", app) == username
+}
+
+// Signs "username|ikey|expiry" with a prefix, as Duo's web SDK does.
+func (s *DuoServer) sign(prefix, username string) string {
+	expiry := time.Now().Add(duoSignatureLifetime).Unix()
+	cookie := prefix + "|" + base64.StdEncoding.EncodeToString([]byte(username+"|"+s.ikey+"|"+strconv.FormatInt(expiry, 10)))
+	return cookie + "|" + s.mac(cookie)
+}
+
+// Returns the username of a valid, unexpired value signed with the prefix, or blank.
+func (s *DuoServer) verify(prefix, value string) string {
+	parts := strings.Split(value, "|")
+	if len(parts) != 3 || parts[0] != prefix {
+		return ""
+	}
+	if !hmac.Equal([]byte(s.mac(parts[0]+"|"+parts[1])), []byte(parts[2])) {
+		return ""
+	}
+	decoded, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	fields := strings.Split(string(decoded), "|")
+	if len(fields) != 3 || fields[1] != s.ikey {
+		return ""
+	}
+	expiry, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return ""
+	}
+	return fields[0]
+}
+
+func (s *DuoServer) mac(value string) string {
+	mac := hmac.New(sha1.New, s.skey)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (f *Factor) duoDevices() []string {
+	if len(f.DuoDevices) == 0 {
+		return []string{"phone1"}
+	}
+	return f.DuoDevices
+}
+
+func (f *Factor) hasDuoDevice(device string) bool {
+	for _, d := range f.duoDevices() {
+		if d == device {
+			return true
+		}
+	}
+	return false
+}
+
+func writeDuoStatus(w http.ResponseWriter, statusCode, status, result, resultURL string) {
+	response := map[string]interface{}{"status_code": statusCode, "status": status}
+	if result != "" {
+		response["result"] = result
+	}
+	if resultURL != "" {
+		response["result_url"] = resultURL
+	}
+	writeDuoResponse(w, response)
+}
+
+// Duo wraps responses in {"stat": "OK", "response": ...}.
+func writeDuoResponse(w http.ResponseWriter, response interface{}) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"stat": "OK", "response": response})
+}
+
+func writeDuoFailure(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{"stat": "FAIL", "message": message})
+}
@@ -0,0 +1,176 @@
+package oktatest
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How long authorization codes and access tokens are valid for.
+const (
+	codeLifetime  = time.Minute
+	tokenLifetime = time.Hour
+)
+
+// An OIDC client registered with the fake authorization servers.
+type Client struct {
+	Id           string
+	RedirectURIs []string
+}
+
+// An authorization code, waiting to be exchanged for tokens.
+type authorizationCode struct {
+	client        *Client
+	user          *User
+	redirectURI   string
+	scopes        []string
+	nonce         string
+	codeChallenge string
+	issuer        string
+	expiresAt     time.Time
+}
+
+// Registers an OIDC client, which is allowed the authorization code grant with PKCE.
+func (s *Server) AddClient(client *Client) *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[client.Id] = client
+	return client
+}
+
+// Serves the OIDC endpoints of the authorization servers, /oauth2/{id}/v1/authorize
+// and /oauth2/{id}/v1/token. Any authorization server id is accepted.
+// https://developer.okta.com/docs/reference/api/oidc/
+func (s *Server) serveOIDC(w http.ResponseWriter, r *http.Request, path []string) {
+	issuer := s.URL + "/oauth2/" + path[1]
+	switch {
+	case r.Method == http.MethodGet && path[3] == "authorize":
+		s.authorize(w, r, issuer)
+	case r.Method == http.MethodPost && path[3] == "token":
+		s.token(w, r, issuer)
+	default:
+		writeError(w, http.StatusNotFound, "E0000022", "The endpoint does not support the provided HTTP method")
+	}
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, issuer string) {
+	query := r.URL.Query()
+	client, ok := s.clients[query.Get("client_id")]
+	if !ok {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_client", "The client is not registered.")
+		return
+	}
+	redirectURI := query.Get("redirect_uri")
+	if !contains(client.RedirectURIs, redirectURI) {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The redirect_uri is not allowed for the client.")
+		return
+	}
+
+	// Errors once the redirect uri is known are returned to the client in the redirect.
+	redirect := func(params url.Values) {
+		params.Set("state", query.Get("state"))
+		http.Redirect(w, r, redirectURI+"?"+params.Encode(), http.StatusFound)
+	}
+	switch {
+	case query.Get("response_type") != "code":
+		redirect(url.Values{"error": {"unsupported_response_type"}, "error_description": {"Only the code response type is supported."}})
+		return
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		redirect(url.Values{"error": {"invalid_request"}, "error_description": {"PKCE with the S256 method is required."}})
+		return
+	}
+
+	// The user is identified by a session token, or by the session cookie.
+	var user *User
+	if sessionToken := query.Get("sessionToken"); sessionToken != "" {
+		user = s.sessionTokens[sessionToken]
+		delete(s.sessionTokens, sessionToken)
+	} else if cookie, err := r.Cookie(SessionCookieName); err == nil {
+		if session := s.activeSession(cookie.Value); session != nil {
+			user = session.User
+		}
+	}
+	if user == nil {
+		redirect(url.Values{"error": {"login_required"}, "error_description": {"The client specified not to prompt, but the user is not logged in."}})
+		return
+	}
+
+	code := randomId() + randomId()
+	s.codes[code] = &authorizationCode{
+		client:        client,
+		user:          user,
+		redirectURI:   redirectURI,
+		scopes:        strings.Fields(query.Get("scope")),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		issuer:        issuer,
+		expiresAt:     time.Now().Add(codeLifetime),
+	}
+	redirect(url.Values{"code": {code}})
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request, issuer string) {
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, http.StatusBadRequest, "invalid_request", "The request body was not well-formed.")
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "Only the authorization_code grant type is supported.")
+		return
+	}
+
+	// Codes can only be used once
+	code, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(code.expiresAt) || code.issuer != issuer:
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "The authorization code is invalid or has expired.")
+		return
+	case code.client.Id != r.PostForm.Get("client_id") || code.redirectURI != r.PostForm.Get("redirect_uri"):
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "The authorization code was issued to a different client or redirect_uri.")
+		return
+	case base64.RawURLEncoding.EncodeToString(verifier[:]) != code.codeChallenge:
+		writeOAuthError(w, http.StatusBadRequest, "invalid_grant", "PKCE verification failed.")
+		return
+	}
+
+	now := time.Now()
+	tokens := map[string]interface{}{
+		"token_type":   "Bearer",
+		"access_token": unsignedJWT(map[string]interface{}{"iss": issuer, "sub": code.user.Login, "cid": code.client.Id, "scp": code.scopes, "iat": now.Unix(), "exp": now.Add(tokenLifetime).Unix()}),
+		"scope":        strings.Join(code.scopes, " "),
+		"expires_in":   int(tokenLifetime / time.Second),
+	}
+	if contains(code.scopes, "openid") {
+		tokens["id_token"] = unsignedJWT(map[string]interface{}{"iss": issuer, "sub": code.user.Id, "aud": code.client.Id, "nonce": code.nonce, "preferred_username": code.user.Login, "iat": now.Unix(), "exp": now.Add(tokenLifetime).Unix()})
+	}
+	if contains(code.scopes, "offline_access") {
+		tokens["refresh_token"] = randomId() + randomId()
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// Returns a JWT with the claims which isn't signed, as the fake's tokens are only for testing.
+func unsignedJWT(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, map[string]string{"error": code, "error_description": description})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package oktatest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// Returned by Prompts when a call doesn't match the script, which aborts the
+// authentication flow.
+var ErrUnexpectedPrompt = errors.New("oktatest: unexpected prompt")
+
+// An okta.Prompts implementation that plays back an ordered script of expected
+// interactions.
+//
+// Any call that doesn't match the next interaction in the script fails the test,
+// as does finishing the test with interactions left in the script.
+type Prompts struct {
+	t testing.TB
+
+	mu     sync.Mutex
+	script []Interaction
+	calls  []string
+	errors []string
+}
+
+// An expected call to one of the Prompts methods, and how to respond to it.
+type Interaction struct {
+	method      string
+	description string
+
+	present    bool
+	substring  bool
+	factorType factors.FactorType
+	duoFactor  okta.DuoFactor
+	code       string
+	message    string
+	err        error
+	cancelled  bool
+	verifyU2F  func(context.Context, okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error)
+}
+
+// Returns Prompts that expect exactly the given interactions, in order.
+// The script is checked for missing interactions when the test finishes.
+func NewPrompts(t testing.TB, script ...Interaction) *Prompts {
+	p := &Prompts{t: t, script: script}
+	t.Cleanup(p.assertFinished)
+	return p
+}
+
+// Expects CheckU2FPresence, and responds with whether the device is present.
+func ExpectCheckU2FPresence(present bool) Interaction {
+	return Interaction{method: "CheckU2FPresence", description: fmt.Sprintf("CheckU2FPresence(%t)", present), present: present}
+}
+
+// Expects ChooseFactor, and chooses the first factor of the given type.
+// The test fails if there is no factor of the type to choose from.
+func ExpectChooseFactor(factorType factors.FactorType) Interaction {
+	return Interaction{method: "ChooseFactor", description: fmt.Sprintf("ChooseFactor(%s)", factorType), factorType: factorType}
+}
+
+// Expects ChooseFactor, and returns the error, aborting the flow.
+func ExpectChooseFactorError(err error) Interaction {
+	return Interaction{method: "ChooseFactor", description: fmt.Sprintf("ChooseFactor() error %q", err), err: err}
+}
+
+// Expects ChooseDuoFactor, and chooses how to verify the Duo factor.
+func ExpectChooseDuoFactor(duoFactor okta.DuoFactor) Interaction {
+	return Interaction{method: "ChooseDuoFactor", description: fmt.Sprintf("ChooseDuoFactor(%s)", duoFactor), duoFactor: duoFactor}
+}
+
+// Expects PresentUserError with exactly the given message.
+func ExpectUserError(message string) Interaction {
+	return Interaction{method: "PresentUserError", description: fmt.Sprintf("PresentUserError(%q)", message), message: message}
+}
+
+// Expects PresentUserError with a message containing the given substring.
+func ExpectUserErrorContaining(substring string) Interaction {
+	return Interaction{method: "PresentUserError", description: fmt.Sprintf("PresentUserError(containing %q)", substring), message: substring, substring: true}
+}
+
+// Expects VerifyU2F, and responds with the canned response.
+func ExpectVerifyU2F(response okta.VerifyU2FResponse) Interaction {
+	return ExpectVerifyU2FFunc(func(context.Context, okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+		return response, nil
+	})
+}
+
+// Expects VerifyU2F, and responds by calling fn. Use this with an Authenticator to
+// sign the challenge.
+func ExpectVerifyU2FFunc(fn func(context.Context, okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error)) Interaction {
+	return Interaction{method: "VerifyU2F", description: "VerifyU2F()", verifyU2F: fn}
+}
+
+// Expects VerifyCode, and enters the code.
+func ExpectVerifyCode(code string) Interaction {
+	return Interaction{method: "VerifyCode", description: fmt.Sprintf("VerifyCode(%q)", code), code: code}
+}
+
+// Expects VerifyCode, and returns the error, canceling the factor.
+func ExpectVerifyCodeError(err error) Interaction {
+	return Interaction{method: "VerifyCode", description: fmt.Sprintf("VerifyCode() error %q", err), err: err}
+}
+
+// Expects VerifyCodeContext, and waits for its context to be cancelled, ex: when
+// the push raced against the code is approved first. ExpectVerifyCode and
+// ExpectVerifyCodeError also expect VerifyCodeContext.
+func ExpectVerifyCodeCancelled() Interaction {
+	return Interaction{method: "VerifyCodeContext", description: "VerifyCodeContext() cancelled", cancelled: true}
+}
+
+// Expects VerifyPush.
+func ExpectVerifyPush() Interaction {
+	return Interaction{method: "VerifyPush", description: "VerifyPush()"}
+}
+
+// Returns the name of each method called, in order.
+func (p *Prompts) Calls() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.calls...)
+}
+
+// Returns each message passed to PresentUserError, in order.
+func (p *Prompts) UserErrors() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.errors...)
+}
+
+func (p *Prompts) CheckU2FPresence(request okta.VerifyU2FRequest) bool {
+	interaction, ok := p.next("CheckU2FPresence")
+	return ok && interaction.present
+}
+
+func (p *Prompts) ChooseFactor(choices []factors.Factor) (factors.Factor, error) {
+	interaction, ok := p.next("ChooseFactor")
+	if !ok {
+		return factors.Factor{}, ErrUnexpectedPrompt
+	}
+	if interaction.err != nil {
+		return factors.Factor{}, interaction.err
+	}
+
+	var available []string
+	for _, choice := range choices {
+		if choice.FactorType == interaction.factorType {
+			return choice, nil
+		}
+		available = append(available, string(choice.FactorType))
+	}
+	p.t.Errorf("oktatest: %s: no factor of type %s to choose from, got [%s]", interaction.description, interaction.factorType, strings.Join(available, ", "))
+	return factors.Factor{}, ErrUnexpectedPrompt
+}
+
+func (p *Prompts) ChooseDuoFactor(factor factors.Factor) (okta.DuoFactor, error) {
+	interaction, ok := p.next("ChooseDuoFactor")
+	if !ok {
+		return "", ErrUnexpectedPrompt
+	}
+	return interaction.duoFactor, nil
+}
+
+func (p *Prompts) PresentUserError(message string) {
+	p.mu.Lock()
+	p.errors = append(p.errors, message)
+	p.mu.Unlock()
+
+	interaction, ok := p.next("PresentUserError")
+	if !ok {
+		return
+	}
+	if interaction.substring {
+		if !strings.Contains(message, interaction.message) {
+			p.t.Errorf("oktatest: expected %s, got PresentUserError(%q)", interaction.description, message)
+		}
+	} else if message != interaction.message {
+		p.t.Errorf("oktatest: expected %s, got PresentUserError(%q)", interaction.description, message)
+	}
+}
+
+func (p *Prompts) VerifyU2F(ctx context.Context, request okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+	interaction, ok := p.next("VerifyU2F")
+	if !ok {
+		return okta.VerifyU2FResponse{}, ErrUnexpectedPrompt
+	}
+	return interaction.verifyU2F(ctx, request)
+}
+
+func (p *Prompts) VerifyCode(factor factors.Factor) (string, error) {
+	interaction, ok := p.next("VerifyCode")
+	if !ok {
+		return "", ErrUnexpectedPrompt
+	}
+	return interaction.code, interaction.err
+}
+
+func (p *Prompts) VerifyCodeContext(ctx context.Context, factor factors.Factor) (string, error) {
+	interaction, ok := p.next("VerifyCodeContext")
+	if !ok {
+		return "", ErrUnexpectedPrompt
+	}
+	if interaction.cancelled {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return interaction.code, interaction.err
+}
+
+func (p *Prompts) VerifyPush() {
+	p.next("VerifyPush")
+}
+
+// Records the call, and pops the next interaction from the script.
+// Fails the test and returns false if the call doesn't match the interaction.
+func (p *Prompts) next(method string) (Interaction, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls = append(p.calls, method)
+	if len(p.script) == 0 {
+		p.t.Errorf("oktatest: unexpected call to %s, the script is finished", method)
+		return Interaction{}, false
+	}
+
+	interaction := p.script[0]
+	if !interaction.expects(method) {
+		p.t.Errorf("oktatest: unexpected call to %s, expected %s", method, interaction.description)
+		return Interaction{}, false
+	}
+	p.script = p.script[1:]
+	return interaction, true
+}
+
+// Codes are entered the same way whether or not the prompt can be cancelled.
+func (i Interaction) expects(method string) bool {
+	return i.method == method || (i.method == "VerifyCode" && method == "VerifyCodeContext")
+}
+
+func (p *Prompts) assertFinished() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.script) > 0 {
+		var missing []string
+		for _, interaction := range p.script {
+			missing = append(missing, interaction.description)
+		}
+		p.t.Errorf("oktatest: expected prompts were not called: %s", strings.Join(missing, ", "))
+	}
+}
@@ -0,0 +1,122 @@
+package oktatest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+func TestPrompts(t *testing.T) {
+	sms := factors.Factor{Id: "sms1", FactorType: factors.FactorTypeSMS}
+	push := factors.Factor{Id: "push1", FactorType: factors.FactorTypePush}
+
+	t.Run("plays back the script", func(t *testing.T) {
+		recorder := &recordingTB{TB: t}
+		prompts := NewPrompts(recorder,
+			ExpectCheckU2FPresence(false),
+			ExpectChooseFactor(factors.FactorTypePush),
+			ExpectVerifyPush(),
+			ExpectUserErrorContaining("Rejected"),
+			ExpectChooseFactor(factors.FactorTypeSMS),
+			ExpectVerifyCode("123456"),
+			ExpectVerifyU2F(okta.VerifyU2FResponse{ClientData: "client-data"}),
+		)
+
+		if prompts.CheckU2FPresence(okta.VerifyU2FRequest{}) {
+			t.Error("expected the device not to be present")
+		}
+		if choice, err := prompts.ChooseFactor([]factors.Factor{sms, push}); err != nil || choice.Id != "push1" {
+			t.Errorf("expected the push factor, got %#+v, %v", choice, err)
+		}
+		prompts.VerifyPush()
+		prompts.PresentUserError("Authentication Rejected")
+		if choice, err := prompts.ChooseFactor([]factors.Factor{sms, push}); err != nil || choice.Id != "sms1" {
+			t.Errorf("expected the sms factor, got %#+v, %v", choice, err)
+		}
+		if code, err := prompts.VerifyCode(sms); err != nil || code != "123456" {
+			t.Errorf("expected the code, got %q, %v", code, err)
+		}
+		if response, err := prompts.VerifyU2F(context.Background(), okta.VerifyU2FRequest{}); err != nil || response.ClientData != "client-data" {
+			t.Errorf("expected the canned response, got %#+v, %v", response, err)
+		}
+
+		expected := []string{"CheckU2FPresence", "ChooseFactor", "VerifyPush", "PresentUserError", "ChooseFactor", "VerifyCode", "VerifyU2F"}
+		if !reflect.DeepEqual(prompts.Calls(), expected) {
+			t.Errorf("expected calls %v, got %v", expected, prompts.Calls())
+		}
+		if !reflect.DeepEqual(prompts.UserErrors(), []string{"Authentication Rejected"}) {
+			t.Errorf("expected the user error to be recorded, got %v", prompts.UserErrors())
+		}
+		prompts.assertFinished()
+		recorder.assertErrors(t)
+	})
+
+	t.Run("fails on an unexpected call", func(t *testing.T) {
+		recorder := &recordingTB{TB: t}
+		prompts := NewPrompts(recorder, ExpectVerifyCode("123456"))
+
+		if _, err := prompts.ChooseFactor([]factors.Factor{sms}); err != ErrUnexpectedPrompt {
+			t.Errorf("expected ErrUnexpectedPrompt, got %v", err)
+		}
+		recorder.assertErrors(t, `oktatest: unexpected call to ChooseFactor, expected VerifyCode("123456")`)
+	})
+
+	t.Run("fails on a call after the script is finished", func(t *testing.T) {
+		recorder := &recordingTB{TB: t}
+		prompts := NewPrompts(recorder)
+
+		prompts.VerifyPush()
+		recorder.assertErrors(t, "oktatest: unexpected call to VerifyPush, the script is finished")
+	})
+
+	t.Run("fails on a missing call", func(t *testing.T) {
+		recorder := &recordingTB{TB: t}
+		prompts := NewPrompts(recorder, ExpectVerifyPush(), ExpectUserError("Cancelled"))
+
+		prompts.VerifyPush()
+		prompts.assertFinished()
+		recorder.assertErrors(t, `oktatest: expected prompts were not called: PresentUserError("Cancelled")`)
+	})
+
+	t.Run("fails on a different user error", func(t *testing.T) {
+		recorder := &recordingTB{TB: t}
+		prompts := NewPrompts(recorder, ExpectUserError("Cancelled"))
+
+		prompts.PresentUserError("Invalid Passcode/Answer")
+		recorder.assertErrors(t, `oktatest: expected PresentUserError("Cancelled"), got PresentUserError("Invalid Passcode/Answer")`)
+	})
+
+	t.Run("fails when the factor type isn't a choice", func(t *testing.T) {
+		recorder := &recordingTB{TB: t}
+		prompts := NewPrompts(recorder, ExpectChooseFactor(factors.FactorTypeU2F))
+
+		if _, err := prompts.ChooseFactor([]factors.Factor{sms, push}); err != ErrUnexpectedPrompt {
+			t.Errorf("expected ErrUnexpectedPrompt, got %v", err)
+		}
+		recorder.assertErrors(t, "oktatest: ChooseFactor(u2f): no factor of type u2f to choose from, got [sms, push]")
+	})
+}
+
+// Records test failures instead of failing the test, and skips cleanup so the
+// script can be checked explicitly.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recordingTB) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) Cleanup(func()) {}
+
+func (r *recordingTB) assertErrors(t *testing.T, expected ...string) {
+	t.Helper()
+	if len(r.errors) != len(expected) || (len(expected) > 0 && !reflect.DeepEqual(r.errors, expected)) {
+		t.Errorf("expected failures %q, got %q", expected, r.errors)
+	}
+}
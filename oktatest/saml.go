@@ -0,0 +1,129 @@
+package oktatest
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// A SAML app that all users are assigned to.
+type SAMLApp struct {
+	// The app's name in its embed link, ex: amazon_aws
+	Name string
+	Id   string
+	// Where the SAML response is posted, ex: https://signin.aws.amazon.com/saml
+	ACSURL string
+	// Attributes included in the assertion, by name.
+	Attributes map[string][]string
+}
+
+// Adds a SAML app, returning its embed link. Generates an id if blank.
+func (s *Server) AddSAMLApp(app *SAMLApp) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if app.Id == "" {
+		app.Id = "0oa" + randomId()
+	}
+	s.samlApps[app.Id] = app
+	return fmt.Sprintf("%s/home/%s/%s/272", s.URL, app.Name, app.Id)
+}
+
+// Serves the embed link, /home/{name}/{id}/{n}, which redirects to the app's SSO
+// endpoint, /app/{name}/{id}/sso/saml. As with Okta, a sessionToken query
+// parameter on either is exchanged for a session, setting the session cookie.
+func (s *Server) serveSAML(w http.ResponseWriter, r *http.Request, path []string) {
+	app, ok := s.samlApps[path[2]]
+	if r.Method != http.MethodGet || !ok || app.Name != path[1] {
+		writeError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: "+r.URL.Path+" (App)")
+		return
+	}
+
+	session := s.sessionFromToken(w, r)
+	if path[0] == "home" {
+		http.Redirect(w, r, fmt.Sprintf("/app/%s/%s/sso/saml", app.Name, app.Id), http.StatusFound)
+		return
+	}
+	if session == nil {
+		if cookie, err := r.Cookie(SessionCookieName); err == nil {
+			session = s.activeSession(cookie.Value)
+		}
+	}
+
+	// Okta shows its sign in page without a session
+	w.Header().Set("Content-Type", "text/html;charset=utf-8")
+	if session == nil {
+		fmt.Fprint(w, `<html><body><form id="form1" action="/login/do-login"><input name="username"></form></body></html>`)
+		return
+	}
+	fmt.Fprintf(w, `<html><body onload="document.forms[0].submit()">
+<form id="appForm" action="%s" method="POST">
+<input name="SAMLResponse" type="hidden" value="%s"/>
+<input name="RelayState" type="hidden" value=""/>
+</form></body></html>`, html.EscapeString(app.ACSURL), html.EscapeString(s.samlResponse(app, session.User)))
+}
+
+// Consumes the sessionToken query parameter, if any, returning the new session.
+func (s *Server) sessionFromToken(w http.ResponseWriter, r *http.Request) *Session {
+	token := r.URL.Query().Get("sessionToken")
+	user, ok := s.sessionTokens[token]
+	if token == "" || !ok {
+		return nil
+	}
+	delete(s.sessionTokens, token)
+	session := s.createSessionForUser(user)
+	http.SetCookie(w, &http.Cookie{Name: SessionCookieName, Value: session.Id, Path: "/", HttpOnly: true})
+	return session
+}
+
+type samlAttribute struct {
+	Name   string   `xml:"Name,attr"`
+	Values []string `xml:"saml2:AttributeValue"`
+}
+
+type samlResponse struct {
+	XMLName      xml.Name `xml:"saml2p:Response"`
+	Namespace    string   `xml:"xmlns:saml2p,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	Id           string   `xml:"ID,attr"`
+	IssueInstant string   `xml:"IssueInstant,attr"`
+	Version      string   `xml:"Version,attr"`
+	Issuer       string   `xml:"saml2:Issuer"`
+	Assertion    struct {
+		Namespace  string          `xml:"xmlns:saml2,attr"`
+		Issuer     string          `xml:"saml2:Issuer"`
+		NameId     string          `xml:"saml2:Subject>saml2:NameID"`
+		Attributes []samlAttribute `xml:"saml2:AttributeStatement>saml2:Attribute"`
+	} `xml:"saml2:Assertion"`
+}
+
+// Returns the base64 encoded SAML response for the user. It isn't signed, as the
+// fake's assertions are only for testing.
+func (s *Server) samlResponse(app *SAMLApp, user *User) string {
+	response := samlResponse{
+		Namespace:    "urn:oasis:names:tc:SAML:2.0:protocol",
+		Destination:  app.ACSURL,
+		Id:           "id" + randomId(),
+		IssueInstant: time.Now().UTC().Format(time.RFC3339),
+		Version:      "2.0",
+		Issuer:       s.URL,
+	}
+	response.Assertion.Namespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	response.Assertion.Issuer = s.URL
+	response.Assertion.NameId = user.Login
+
+	names := make([]string, 0, len(app.Attributes))
+	for name := range app.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		response.Assertion.Attributes = append(response.Assertion.Attributes, samlAttribute{Name: name, Values: app.Attributes[name]})
+	}
+
+	b, _ := xml.Marshal(response)
+	return base64.StdEncoding.EncodeToString(append([]byte(xml.Header), b...))
+}
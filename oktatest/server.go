@@ -0,0 +1,669 @@
+package oktatest
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// How long transactions and sessions are valid for.
+const (
+	transactionLifetime = 5 * time.Minute
+	sessionLifetime     = 2 * time.Hour
+)
+
+// Name of the cookie holding the session id.
+const SessionCookieName = "sid"
+
+// A fake Okta org served over HTTP.
+//
+// Server is safe for concurrent use. Users and their factors may be modified
+// between requests while holding no other references, but not concurrently with
+// requests in flight.
+type Server struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	users         map[string]*User
+	transactions  map[string]*transaction
+	sessionTokens map[string]*User
+	sessions      map[string]*Session
+	clients       map[string]*Client
+	codes         map[string]*authorizationCode
+	samlApps      map[string]*SAMLApp
+	rateLimited   int
+	requests      []string
+	now           func() time.Time
+}
+
+// An active authentication transaction, keyed by its state token.
+type transaction struct {
+	stateToken string
+	user       *User
+	status     api.TransactionState
+	expiresAt  time.Time
+
+	// The factor being challenged in the MFA_CHALLENGE state.
+	factor *Factor
+	// For push factors, the number of times the challenge has been polled, and
+	// the result once the push has been answered.
+	pushPolls  int
+	pushResult api.FactorResult
+	// For U2F and WebAuthn factors, the challenge that must be signed.
+	challenge string
+	// For Duo factors, the signed request for Duo, and whether Duo's signed
+	// response has been posted to the callback.
+	duoSignature string
+	duoVerified  bool
+}
+
+// A session created from a session token.
+type Session struct {
+	Id        string
+	User      *User
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Starts a new server, which should be closed when finished.
+func NewServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Starts a new server serving HTTPS, which should be closed when finished.
+// Use the Client method to get a client that trusts the server's certificate.
+func NewTLSServer() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(s)
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		users:         map[string]*User{},
+		transactions:  map[string]*transaction{},
+		sessionTokens: map[string]*User{},
+		sessions:      map[string]*Session{},
+		clients:       map[string]*Client{},
+		codes:         map[string]*authorizationCode{},
+		samlApps:      map[string]*SAMLApp{},
+		now:           time.Now,
+	}
+}
+
+// Adds a user to the org. Ids are generated for the user and its factors if blank.
+func (s *Server) AddUser(user *User) *User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if user.Id == "" {
+		user.Id = "00u" + randomId()
+	}
+	for _, factor := range user.Factors {
+		if factor.Id == "" {
+			factor.Id = factorIdPrefix(factor.FactorType) + randomId()
+		}
+	}
+	s.users[user.Login] = user
+	return user
+}
+
+// Responds to the next n requests with HTTP 429, as if the org's rate limit was exceeded.
+func (s *Server) RateLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimited = n
+}
+
+// Sets the clock used to validate TOTP codes, which defaults to time.Now.
+func (s *Server) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.now = now
+}
+
+// Returns the method and path of each request the server has received, in order.
+// Ex: "POST /api/v1/authn"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Returns the active session with the given id, or nil.
+func (s *Server) Session(id string) *Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.activeSession(id)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	w.Header().Set("X-Okta-Request-Id", randomId())
+
+	if s.rateLimited > 0 {
+		s.rateLimited--
+		w.Header().Set("X-Rate-Limit-Limit", "600")
+		w.Header().Set("X-Rate-Limit-Remaining", "0")
+		w.Header().Set("X-Rate-Limit-Reset", strconv.FormatInt(time.Now().Unix(), 10))
+		writeError(w, http.StatusTooManyRequests, api.ErrorCodeTooManyRequests, "API call exceeded rate limit due to too many requests.")
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/authn":
+		s.primaryAuthentication(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/authn/previous":
+		s.previous(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/authn/cancel":
+		s.cancel(w, r)
+	case r.Method == http.MethodPost && len(path) >= 6 && path[2] == "authn" && path[3] == "factors" && path[5] == "verify":
+		s.verifyFactor(w, r, path[4])
+	case r.Method == http.MethodPost && len(path) == 7 && path[2] == "authn" && path[3] == "factors" && path[5] == "lifecycle" && path[6] == "duoCallback":
+		s.duoCallback(w, r, path[4])
+	case strings.HasPrefix(r.URL.Path, "/api/v1/sessions"):
+		s.serveSessions(w, r)
+	case len(path) == 4 && path[0] == "oauth2" && path[2] == "v1":
+		s.serveOIDC(w, r, path)
+	case (len(path) == 4 && path[0] == "home") || (len(path) == 5 && path[0] == "app" && path[3] == "sso" && path[4] == "saml"):
+		s.serveSAML(w, r, path)
+	default:
+		writeError(w, http.StatusNotFound, "E0000022", "The endpoint does not support the provided HTTP method")
+	}
+}
+
+type authnRequest struct {
+	Username          string `json:"username"`
+	Password          string `json:"password"`
+	StateToken        string `json:"stateToken"`
+	PassCode          string `json:"passCode"`
+	Answer            string `json:"answer"`
+	ClientData        string `json:"clientData"`
+	SignatureData     string `json:"signatureData"`
+	AuthenticatorData string `json:"authenticatorData"`
+}
+
+func (s *Server) primaryAuthentication(w http.ResponseWriter, r *http.Request) {
+	var request authnRequest
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	user, ok := s.users[request.Username]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, api.ErrorCodeAuthenticationFailed, "Authentication failed")
+		return
+	}
+	if user.LockedOut {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": api.StateLockedOut})
+		return
+	}
+	if request.Password != user.Password {
+		user.failedAttempts++
+		if user.LockoutAttempts > 0 && user.failedAttempts >= user.LockoutAttempts {
+			user.LockedOut = true
+		}
+		writeError(w, http.StatusUnauthorized, api.ErrorCodeAuthenticationFailed, "Authentication failed")
+		return
+	}
+	user.failedAttempts = 0
+
+	t := &transaction{
+		stateToken: "00" + randomId() + randomId(),
+		user:       user,
+		expiresAt:  time.Now().Add(transactionLifetime),
+	}
+	switch {
+	case user.PasswordExpired:
+		t.status = api.StatePasswordExpired
+	case len(user.Factors) == 0:
+		s.writeSuccess(w, t)
+		return
+	default:
+		t.status = api.StateMFARequired
+	}
+	s.transactions[t.stateToken] = t
+	s.writeTransaction(w, t)
+}
+
+func (s *Server) previous(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.transaction(w, r, nil)
+	if !ok {
+		return
+	}
+	t.status = api.StateMFARequired
+	t.factor = nil
+	s.writeTransaction(w, t)
+}
+
+func (s *Server) cancel(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.transaction(w, r, nil)
+	if !ok {
+		return
+	}
+	delete(s.transactions, t.stateToken)
+	writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (s *Server) verifyFactor(w http.ResponseWriter, r *http.Request, factorId string) {
+	var request authnRequest
+	t, ok := s.transaction(w, r, &request)
+	if !ok {
+		return
+	}
+	if t.status != api.StateMFARequired && t.status != api.StateMFAChallenge {
+		writeError(w, http.StatusForbidden, "E0000079", "This operation is not allowed in the current authentication state.")
+		return
+	}
+
+	factor := t.user.factor(factorId)
+	if factor == nil {
+		writeError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: "+factorId+" (UserFactor)")
+		return
+	}
+
+	// Verifying a different factor abandons the current challenge.
+	if t.factor != factor {
+		t.factor = nil
+	}
+
+	switch factor.FactorType {
+	case factors.FactorTypePush:
+		s.verifyPush(w, t, factor)
+	case factors.FactorTypeU2F, factors.FactorTypeWebAuthN:
+		s.verifyU2F(w, t, factor, request)
+	case factors.FactorTypeWeb:
+		s.verifyDuo(w, t, factor)
+	default:
+		s.verifyCode(w, t, factor, request)
+	}
+}
+
+func (s *Server) verifyCode(w http.ResponseWriter, t *transaction, factor *Factor, request authnRequest) {
+	passCode := request.PassCode
+	if factor.FactorType == factors.FactorTypeQuestion {
+		passCode = request.Answer
+	}
+
+	if passCode == "" {
+		s.challenge(w, t, factor)
+		return
+	}
+	if factor.TOTP != nil {
+		step, ok := factor.TOTP.Validate(passCode, s.now())
+		if !ok {
+			writeError(w, http.StatusForbidden, "E0000068", "Invalid Passcode/Answer")
+			return
+		}
+		if step <= factor.lastStep {
+			writeError(w, http.StatusForbidden, api.ErrorCodePasscodeReplayed, "Each code can only be used once. Please wait for a new code and try again.")
+			return
+		}
+		factor.lastStep = step
+	} else if passCode != factor.PassCode {
+		writeError(w, http.StatusForbidden, "E0000068", "Invalid Passcode/Answer")
+		return
+	}
+	s.writeSuccess(w, t)
+}
+
+func (s *Server) verifyPush(w http.ResponseWriter, t *transaction, factor *Factor) {
+	// Verifying the factor sends a push, after which verifying it again polls for the result.
+	if t.status != api.StateMFAChallenge || t.factor != factor {
+		t.pushPolls = 0
+		t.pushResult = ""
+		s.challenge(w, t, factor)
+		return
+	}
+	if t.pushResult != "" {
+		s.writeTransactionWithResult(w, t, t.pushResult)
+		return
+	}
+
+	t.pushPolls++
+	if t.pushPolls <= factor.PushWaitPolls {
+		s.writeTransactionWithResult(w, t, api.FactorResultWaiting)
+		return
+	}
+
+	switch factor.Push {
+	case PushApprove:
+		s.writeSuccess(w, t)
+	case PushReject:
+		t.pushResult = api.FactorResultRejected
+		s.writeTransactionWithResult(w, t, t.pushResult)
+	case PushTimeout:
+		t.pushResult = api.FactorResultTimeout
+		s.writeTransactionWithResult(w, t, t.pushResult)
+	default:
+		s.writeTransactionWithResult(w, t, api.FactorResultWaiting)
+	}
+}
+
+func (s *Server) verifyU2F(w http.ResponseWriter, t *transaction, factor *Factor, request authnRequest) {
+	if request.ClientData == "" {
+		t.challenge = randomId()
+		s.challenge(w, t, factor)
+		return
+	}
+	if t.factor != factor || factor.PublicKey == nil {
+		writeError(w, http.StatusForbidden, "E0000068", "Invalid Passcode/Answer")
+		return
+	}
+
+	// Okta orgs are only served over https, so that is the origin clients sign for
+	// regardless of the scheme of the fake.
+	host := strings.TrimPrefix(strings.TrimPrefix(s.URL, "https://"), "http://")
+	a := assertion{
+		challenge:         t.challenge,
+		origin:            "https://" + host,
+		clientData:        request.ClientData,
+		signatureData:     request.SignatureData,
+		authenticatorData: request.AuthenticatorData,
+	}
+	var counter uint32
+	var err error
+	if factor.FactorType == factors.FactorTypeU2F {
+		a.appId = factor.Profile.(api.FactorProfileU2F).AppId
+		counter, err = verifyU2FAssertion(factor.PublicKey, a)
+	} else {
+		a.appId = host
+		counter, err = verifyWebAuthnAssertion(factor.PublicKey, a)
+	}
+	if err == nil && counter <= factor.signCount {
+		err = fmt.Errorf("counter %d is not greater than %d", counter, factor.signCount)
+	}
+	if err != nil {
+		writeErrorWithCause(w, http.StatusForbidden, "E0000068", "Invalid Passcode/Answer", err.Error())
+		return
+	}
+	factor.signCount = counter
+	s.writeSuccess(w, t)
+}
+
+func (s *Server) verifyDuo(w http.ResponseWriter, t *transaction, factor *Factor) {
+	// Verifying the factor signs a request for Duo, after which verifying it again
+	// polls for Duo's response to be posted to the callback.
+	if t.status != api.StateMFAChallenge || t.factor != factor {
+		t.duoSignature = factor.Duo.signRequest(t.user.Login, factor)
+		t.duoVerified = false
+		s.challenge(w, t, factor)
+		return
+	}
+	if t.duoVerified {
+		s.writeSuccess(w, t)
+		return
+	}
+	s.writeTransactionWithResult(w, t, api.FactorResultWaiting)
+}
+
+// Accepts Duo's signed response as a form post, as the Duo iframe does.
+func (s *Server) duoCallback(w http.ResponseWriter, r *http.Request, factorId string) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.")
+		return
+	}
+	t, ok := s.transactions[r.PostForm.Get("stateToken")]
+	if !ok || time.Now().After(t.expiresAt) {
+		writeError(w, http.StatusForbidden, "E0000011", "Invalid token provided")
+		return
+	}
+	if t.status != api.StateMFAChallenge || t.factor == nil || t.factor.Id != factorId || r.PostForm.Get("id") != factorId {
+		writeError(w, http.StatusForbidden, "E0000079", "This operation is not allowed in the current authentication state.")
+		return
+	}
+	_, app, _ := strings.Cut(t.duoSignature, ":")
+	if !t.factor.Duo.verifyResponse(t.user.Login, app, r.PostForm.Get("sig_response")) {
+		writeError(w, http.StatusForbidden, "E0000068", "Invalid Passcode/Answer")
+		return
+	}
+	t.duoVerified = true
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+}
+
+// Moves the transaction into the MFA_CHALLENGE state for the factor.
+func (s *Server) challenge(w http.ResponseWriter, t *transaction, factor *Factor) {
+	t.status = api.StateMFAChallenge
+	t.factor = factor
+	if factor.FactorType == factors.FactorTypePush || factor.FactorType == factors.FactorTypeWeb {
+		s.writeTransactionWithResult(w, t, api.FactorResultWaiting)
+	} else {
+		s.writeTransaction(w, t)
+	}
+}
+
+// Completes the transaction and issues a session token.
+func (s *Server) writeSuccess(w http.ResponseWriter, t *transaction) {
+	delete(s.transactions, t.stateToken)
+
+	sessionToken := "20111" + randomId() + randomId()
+	s.sessionTokens[sessionToken] = t.user
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":       api.StateSuccess,
+		"sessionToken": sessionToken,
+		"expiresAt":    t.expiresAt.UTC().Format(time.RFC3339),
+		"_embedded": map[string]interface{}{
+			"user": s.userJSON(t.user),
+		},
+	})
+}
+
+// Looks up the transaction for the state token in the request body, which is decoded into request if set.
+// Writes an error and returns false if the transaction doesn't exist.
+func (s *Server) transaction(w http.ResponseWriter, r *http.Request, request *authnRequest) (*transaction, bool) {
+	if request == nil {
+		request = &authnRequest{}
+	}
+	if !decodeRequest(w, r, request) {
+		return nil, false
+	}
+
+	t, ok := s.transactions[request.StateToken]
+	if !ok || time.Now().After(t.expiresAt) {
+		delete(s.transactions, request.StateToken)
+		writeError(w, http.StatusForbidden, "E0000011", "Invalid token provided")
+		return nil, false
+	}
+	return t, true
+}
+
+func (s *Server) writeTransaction(w http.ResponseWriter, t *transaction) {
+	writeJSON(w, http.StatusOK, s.transactionJSON(t, ""))
+}
+
+func (s *Server) writeTransactionWithResult(w http.ResponseWriter, t *transaction, result api.FactorResult) {
+	writeJSON(w, http.StatusOK, s.transactionJSON(t, result))
+}
+
+func (s *Server) transactionJSON(t *transaction, result api.FactorResult) map[string]interface{} {
+	embedded := map[string]interface{}{
+		"user": s.userJSON(t.user),
+	}
+	links := map[string]interface{}{
+		"cancel": s.link("/api/v1/authn/cancel"),
+	}
+
+	switch t.status {
+	case api.StateMFARequired:
+		var factorsJSON []interface{}
+		for _, factor := range t.user.Factors {
+			factorsJSON = append(factorsJSON, s.factorJSON(factor, true))
+		}
+		embedded["factors"] = factorsJSON
+	case api.StateMFAChallenge:
+		factorJSON := s.factorJSON(t.factor, false)
+		switch t.factor.FactorType {
+		case factors.FactorTypeU2F:
+			factorJSON["_embedded"] = map[string]interface{}{
+				"challenge": map[string]interface{}{"nonce": t.challenge, "timeoutSeconds": 20},
+			}
+		case factors.FactorTypeWebAuthN:
+			factorJSON["_embedded"] = map[string]interface{}{
+				"challenge": map[string]interface{}{"challenge": t.challenge},
+			}
+		case factors.FactorTypeWeb:
+			factorJSON["_embedded"] = map[string]interface{}{
+				"verification": map[string]interface{}{
+					"host":      t.factor.Duo.Host(),
+					"signature": t.duoSignature,
+					"_links": map[string]interface{}{
+						"complete": s.link("/api/v1/authn/factors/" + t.factor.Id + "/lifecycle/duoCallback"),
+						"script":   map[string]interface{}{"href": t.factor.Duo.URL + "/frame/hosted/Duo-Web-v2.min.js"},
+					},
+				},
+			}
+		}
+		embedded["factor"] = factorJSON
+
+		verify := s.verifyPath(t.factor)
+		next := s.link(verify)
+		if t.factor.FactorType == factors.FactorTypePush || t.factor.FactorType == factors.FactorTypeWeb {
+			next["name"] = "poll"
+			links["poll"] = s.link(verify)
+		} else {
+			next["name"] = "verify"
+		}
+		links["next"] = next
+		links["prev"] = s.link("/api/v1/authn/previous")
+		if t.factor.FactorType == factors.FactorTypeSMS || t.factor.FactorType == factors.FactorTypeCall || t.factor.FactorType == factors.FactorTypePush {
+			resend := s.link(verify + "/resend")
+			resend["name"] = string(t.factor.FactorType)
+			links["resend"] = []interface{}{resend}
+		}
+	}
+
+	re := map[string]interface{}{
+		"stateToken": t.stateToken,
+		"status":     t.status,
+		"expiresAt":  t.expiresAt.UTC().Format(time.RFC3339),
+		"_embedded":  embedded,
+		"_links":     links,
+	}
+	if result != "" {
+		re["factorResult"] = result
+	}
+	return re
+}
+
+func (s *Server) factorJSON(factor *Factor, withLinks bool) map[string]interface{} {
+	re := map[string]interface{}{
+		"id":         factor.Id,
+		"factorType": factor.FactorType,
+		"provider":   factor.Provider,
+		"vendorName": factor.Provider,
+		"status":     factors.FactorStatusActive,
+	}
+	if factor.Profile != nil {
+		re["profile"] = factor.Profile
+	}
+	if withLinks {
+		re["_links"] = map[string]interface{}{
+			"verify": s.link(s.verifyPath(factor)),
+		}
+	}
+	return re
+}
+
+func (s *Server) userJSON(user *User) map[string]interface{} {
+	return map[string]interface{}{
+		"id": user.Id,
+		"profile": map[string]interface{}{
+			"login":     user.Login,
+			"firstName": user.FirstName,
+			"lastName":  user.LastName,
+		},
+	}
+}
+
+func (s *Server) verifyPath(factor *Factor) string {
+	return "/api/v1/authn/factors/" + factor.Id + "/verify"
+}
+
+func (s *Server) link(path string) map[string]interface{} {
+	return map[string]interface{}{
+		"href":  s.URL + path,
+		"hints": map[string]interface{}{"allow": []string{http.MethodPost}},
+	}
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, request interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+		writeError(w, http.StatusBadRequest, "E0000003", "The request body was not well-formed.")
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, code, summary string) {
+	writeJSON(w, status, apiError(code, summary))
+}
+
+// Writes an error with a cause explaining why the request failed.
+func writeErrorWithCause(w http.ResponseWriter, status int, code, summary, cause string) {
+	re := apiError(code, summary)
+	re.ErrorCauses = append(re.ErrorCauses, api.APIErrorCause{ErrorSummary: cause})
+	writeJSON(w, status, re)
+}
+
+func apiError(code, summary string) api.APIError {
+	return api.APIError{
+		ErrorCode:    code,
+		ErrorSummary: summary,
+		ErrorLink:    code,
+		ErrorId:      "oae" + randomId(),
+		ErrorCauses:  []api.APIErrorCause{},
+	}
+}
+
+func factorIdPrefix(factorType factors.FactorType) string {
+	switch factorType {
+	case factors.FactorTypeSMS:
+		return "sms"
+	case factors.FactorTypeCall:
+		return "clf"
+	case factors.FactorTypePush:
+		return "opf"
+	case factors.FactorTypeU2F, factors.FactorTypeWebAuthN:
+		return "fuf"
+	case factors.FactorTypeWeb:
+		return "dsf"
+	default:
+		return "uft"
+	}
+}
+
+const idAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// Returns a random alphanumeric id, in the style of Okta's ids.
+func randomId() string {
+	b := make([]byte, 17)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("oktatest: failed to read random bytes: %s", err))
+	}
+	for i := range b {
+		b[i] = idAlphabet[int(b[i])%len(idAlphabet)]
+	}
+	return string(b)
+}
@@ -0,0 +1,92 @@
+package oktatest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"testing"
+)
+
+func TestSessions(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.AddUser(&User{Login: "first@example.com", Password: "password"})
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+
+	var authn struct {
+		Status       string
+		SessionToken string
+	}
+	post(t, client, server.URL+"/api/v1/authn", `{"username":"first@example.com","password":"password"}`, http.StatusOK, &authn)
+	if authn.Status != "SUCCESS" || authn.SessionToken == "" {
+		t.Fatalf("expected a session token, got %#+v", authn)
+	}
+
+	var session struct {
+		Id    string
+		Login string
+	}
+	post(t, client, server.URL+"/api/v1/sessions", `{"sessionToken":"`+authn.SessionToken+`"}`, http.StatusOK, &session)
+	if session.Login != "first@example.com" || server.Session(session.Id) == nil {
+		t.Fatalf("expected an active session, got %#+v", session)
+	}
+
+	// Session tokens can only be used once
+	post(t, client, server.URL+"/api/v1/sessions", `{"sessionToken":"`+authn.SessionToken+`"}`, http.StatusUnauthorized, nil)
+
+	response, err := client.Get(server.URL + "/api/v1/sessions/me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("expected the session cookie to identify the session, got status %d", response.StatusCode)
+	}
+
+	request, err := http.NewRequest(http.MethodDelete, server.URL+"/api/v1/sessions/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err = client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent || server.Session(session.Id) != nil {
+		t.Errorf("expected the session to be closed, got status %d", response.StatusCode)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.AddUser(&User{Login: "first@example.com", Password: "password"})
+
+	server.RateLimit(1)
+	body := `{"username":"first@example.com","password":"password"}`
+	post(t, http.DefaultClient, server.URL+"/api/v1/authn", body, http.StatusTooManyRequests, nil)
+	post(t, http.DefaultClient, server.URL+"/api/v1/authn", body, http.StatusOK, nil)
+}
+
+func post(t *testing.T, client *http.Client, url, body string, status int, response interface{}) {
+	t.Helper()
+	resp, err := client.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("expected status %d from %s, got %d", status, url, resp.StatusCode)
+	}
+	if response != nil {
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package oktatest
+
+import (
+	"net/http"
+	"time"
+)
+
+// Serves the sessions API for the current user, identified by the session cookie.
+// https://developer.okta.com/docs/reference/api/sessions/
+func (s *Server) serveSessions(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/sessions":
+		s.createSession(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/sessions/me":
+		if session := s.requestSession(w, r); session != nil {
+			writeJSON(w, http.StatusOK, sessionJSON(session))
+		}
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/sessions/me/lifecycle/refresh":
+		if session := s.requestSession(w, r); session != nil {
+			session.ExpiresAt = time.Now().Add(sessionLifetime)
+			writeJSON(w, http.StatusOK, sessionJSON(session))
+		}
+	case r.Method == http.MethodDelete && r.URL.Path == "/api/v1/sessions/me":
+		if session := s.requestSession(w, r); session != nil {
+			delete(s.sessions, session.Id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "E0000022", "The endpoint does not support the provided HTTP method")
+	}
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		SessionToken string `json:"sessionToken"`
+	}
+	if !decodeRequest(w, r, &request) {
+		return
+	}
+
+	user, ok := s.sessionTokens[request.SessionToken]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "E0000005", "Invalid session")
+		return
+	}
+	// Session tokens can only be used once
+	delete(s.sessionTokens, request.SessionToken)
+
+	session := s.createSessionForUser(user)
+	http.SetCookie(w, &http.Cookie{Name: SessionCookieName, Value: session.Id, Path: "/", HttpOnly: true})
+	writeJSON(w, http.StatusOK, sessionJSON(session))
+}
+
+func (s *Server) createSessionForUser(user *User) *Session {
+	now := time.Now()
+	session := &Session{
+		Id:        "102" + randomId(),
+		User:      user,
+		CreatedAt: now,
+		ExpiresAt: now.Add(sessionLifetime),
+	}
+	s.sessions[session.Id] = session
+	return session
+}
+
+// Returns the session identified by the session cookie of the request.
+// Writes an error and returns nil if there isn't an active session.
+func (s *Server) requestSession(w http.ResponseWriter, r *http.Request) *Session {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err == nil {
+		if session := s.activeSession(cookie.Value); session != nil {
+			return session
+		}
+	}
+	writeError(w, http.StatusNotFound, "E0000007", "Not found: Resource not found: me (Session)")
+	return nil
+}
+
+func (s *Server) activeSession(id string) *Session {
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(session.ExpiresAt) {
+		delete(s.sessions, id)
+		return nil
+	}
+	return session
+}
+
+func sessionJSON(session *Session) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                       session.Id,
+		"userId":                   session.User.Id,
+		"login":                    session.User.Login,
+		"createdAt":                session.CreatedAt.UTC().Format(time.RFC3339),
+		"expiresAt":                session.ExpiresAt.UTC().Format(time.RFC3339),
+		"status":                   "ACTIVE",
+		"lastPasswordVerification": session.CreatedAt.UTC().Format(time.RFC3339),
+		"amr":                      []string{"pwd"},
+		"mfaActive":                len(session.User.Factors) > 0,
+	}
+}
@@ -0,0 +1,161 @@
+package oktatest
+
+import (
+	"crypto/ecdsa"
+
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+	"github.com/wearefair/okta-auth/totp"
+)
+
+// A user that can authenticate against the fake server.
+type User struct {
+	// Generated when the user is added if blank.
+	Id        string
+	Login     string
+	Password  string
+	FirstName string
+	LastName  string
+
+	// Authenticating as a locked out user results in the LOCKED_OUT state.
+	LockedOut bool
+	// Number of consecutive failed password attempts after which the user is locked out.
+	// Zero disables lockout.
+	LockoutAttempts int
+	// Authenticating as a user with an expired password results in the PASSWORD_EXPIRED state.
+	PasswordExpired bool
+
+	// Enrolled factors. If there are none the user authenticates with just their password.
+	Factors []*Factor
+
+	failedAttempts int
+}
+
+func (u *User) factor(id string) *Factor {
+	for _, factor := range u.Factors {
+		if factor.Id == id {
+			return factor
+		}
+	}
+	return nil
+}
+
+// How the user responds to a push notification.
+type PushResult string
+
+const (
+	// The user approves the push.
+	PushApprove = PushResult("APPROVE")
+	// The user rejects the push.
+	PushReject = PushResult("REJECT")
+	// The push expires before the user responds.
+	PushTimeout = PushResult("TIMEOUT")
+	// The user never responds, the push keeps waiting.
+	PushWait = PushResult("WAIT")
+)
+
+// A factor enrolled for a user.
+type Factor struct {
+	// Generated when the user is added if blank.
+	Id         string
+	FactorType factors.FactorType
+	Provider   string
+	// Nil if the factor has no profile.
+	Profile api.FactorProfile
+
+	// The code accepted for SMS, call and token factors, or the answer for question factors.
+	PassCode string
+	// For TOTP factors, validates codes instead of PassCode. Each time step can only
+	// be used once, codes for used steps fail with PASSCODE_REPLAYED.
+	TOTP *totp.TOTP
+	// The last time step a TOTP code was accepted for.
+	lastStep uint64
+
+	// How the user responds to a push notification.
+	Push PushResult
+	// Number of times polling returns WAITING before the push result. For Duo
+	// factors, the number of times Duo reports the push is still waiting.
+	PushWaitPolls int
+
+	// For Duo web factors, the Duo server the factor is verified with.
+	Duo *DuoServer
+	// For Duo web factors, the ids of the devices Duo's prompt lists, defaulting
+	// to phone1. Pushes and passcodes are only accepted for listed devices.
+	DuoDevices []string
+
+	// The public key of U2F and WebAuthn credentials, which signatures are verified with.
+	PublicKey *ecdsa.PublicKey
+	// The highest signature counter seen. Signatures with a lower counter are
+	// rejected as they may be from a cloned key.
+	signCount uint32
+}
+
+func SMSFactor(phoneNumber, passCode string) *Factor {
+	return &Factor{
+		FactorType: factors.FactorTypeSMS,
+		Provider:   "OKTA",
+		Profile:    api.FactorProfileSMS{PhoneNumber: phoneNumber},
+		PassCode:   passCode,
+	}
+}
+
+func CallFactor(phoneNumber, passCode string) *Factor {
+	return &Factor{
+		FactorType: factors.FactorTypeCall,
+		Provider:   "OKTA",
+		Profile:    api.FactorProfileCall{PhoneNumber: phoneNumber},
+		PassCode:   passCode,
+	}
+}
+
+func TOTPFactor(provider, credentialId, passCode string) *Factor {
+	return &Factor{
+		FactorType: factors.FactorTypeTokenSoftwareTOTP,
+		Provider:   provider,
+		Profile:    api.FactorProfileToken{CredentialId: credentialId},
+		PassCode:   passCode,
+	}
+}
+
+// Returns a TOTP factor accepting codes generated from the secret.
+func SoftwareTOTPFactor(provider, credentialId string, generator *totp.TOTP) *Factor {
+	return &Factor{
+		FactorType: factors.FactorTypeTokenSoftwareTOTP,
+		Provider:   provider,
+		Profile:    api.FactorProfileToken{CredentialId: credentialId},
+		TOTP:       generator,
+	}
+}
+
+func PushFactor(result PushResult) *Factor {
+	return &Factor{
+		FactorType: factors.FactorTypePush,
+		Provider:   "OKTA",
+		Push:       result,
+	}
+}
+
+// Returns a U2F factor for the authenticator's credential, scoped to the app id.
+func U2FFactor(appId string, authenticator *Authenticator) *Factor {
+	return &Factor{
+		FactorType: factors.FactorTypeU2F,
+		Provider:   "FIDO",
+		Profile: api.FactorProfileU2F{
+			CredentialId: authenticator.CredentialId,
+			AppId:        appId,
+			Version:      "U2F_V2",
+		},
+		PublicKey: authenticator.PublicKey(),
+	}
+}
+
+// Returns a WebAuthn factor for the authenticator's credential.
+// The relying party is the server the factor is added to.
+func WebAuthnFactor(authenticator *Authenticator) *Factor {
+	return &Factor{
+		FactorType: factors.FactorTypeWebAuthN,
+		Provider:   "FIDO",
+		Profile:    api.FactorProfileWebAuthN{CredentialId: authenticator.CredentialId, AuthenticatorName: authenticator.Name},
+		PublicKey:  authenticator.PublicKey(),
+	}
+}
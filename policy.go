@@ -0,0 +1,108 @@
+package okta
+
+import (
+	"strings"
+
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// Restricts the factors the user may verify, and chooses factors without asking
+// the user. The zero value allows every supported factor, and only chooses a
+// U2F or WebAuthn factor automatically, when its device is present.
+type FactorPolicy struct {
+	// Factors that are never offered to the user, ex: SMS and voice calls.
+	Deny []FactorMatch
+
+	// Factors to choose without asking the user, most preferred first. The
+	// first enrolled factor that matches is chosen, except that U2F and WebAuthn
+	// factors are only chosen when their device is present. If none are
+	// chosen, the user chooses from the allowed factors, which are listed in
+	// order of preference.
+	//
+	// When set, U2F and WebAuthn factors that don't match aren't chosen
+	// automatically.
+	Prefer []FactorMatch
+
+	// Choose the only allowed factor without asking the user.
+	AutoSelectSingle bool
+
+	// Choose the factor the user last verified without asking, if it is allowed.
+	// U2F and WebAuthn factors are only chosen when their device is present.
+	// Requires a ClientConfig.FactorStore.
+	AutoSelectLast bool
+
+	// When the user has a TOTP factor, prompt for its code while waiting for a
+	// push, and verify whichever is answered first. Entering a code cancels the
+	// push. Requires Prompts that implement CancelableCodePrompts.
+	RacePushWithTOTP bool
+}
+
+// Matches factors by type and provider, ex: {FactorTypePush, "OKTA"}.
+// Blank fields match any factor, and providers are matched case insensitively.
+type FactorMatch struct {
+	FactorType factors.FactorType
+	Provider   string
+}
+
+func (m FactorMatch) matches(factor api.Factor) bool {
+	if m.FactorType != "" && m.FactorType != factor.FactorType {
+		return false
+	}
+	return m.Provider == "" || strings.EqualFold(m.Provider, factor.Provider)
+}
+
+// Returns the factors that aren't denied, ordered by preference.
+func (p FactorPolicy) allowed(supported api.Factors) api.Factors {
+	var allowed api.Factors
+	for _, factor := range supported {
+		if !p.denies(factor) {
+			allowed = append(allowed, factor)
+		}
+	}
+	sorted := make(api.Factors, 0, len(allowed))
+	for _, match := range p.Prefer {
+		for _, factor := range allowed {
+			if match.matches(factor) && !containsFactor(sorted, factor) {
+				sorted = append(sorted, factor)
+			}
+		}
+	}
+	for _, factor := range allowed {
+		if !containsFactor(sorted, factor) {
+			sorted = append(sorted, factor)
+		}
+	}
+	return sorted
+}
+
+func (p FactorPolicy) denies(factor api.Factor) bool {
+	for _, match := range p.Deny {
+		if match.matches(factor) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the factor is chosen automatically when it is available.
+func (p FactorPolicy) prefers(factor api.Factor) bool {
+	if len(p.Prefer) == 0 {
+		return factor.FactorType == factors.FactorTypeU2F || factor.FactorType == factors.FactorTypeWebAuthN
+	}
+	for _, match := range p.Prefer {
+		if match.matches(factor) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFactor(facs api.Factors, factor api.Factor) bool {
+	for _, f := range facs {
+		if f.Id == factor.Id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package term
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// Display names of factor providers.
+// https://developer.okta.com/docs/reference/api/factors/#provider-type
+var providerNames = map[string]string{
+	"OKTA":     "Okta Verify",
+	"GOOGLE":   "Google Authenticator",
+	"RSA":      "RSA SecurID",
+	"SYMANTEC": "Symantec VIP",
+	"YUBICO":   "YubiKey",
+	"DUO":      "Duo Security",
+	"FIDO":     "FIDO",
+	"CUSTOM":   "Custom",
+}
+
+// Returns a description of the factor to show the user when choosing a factor.
+// Phone numbers are masked to their last four digits.
+func Describe(factor factors.Factor) string {
+	switch factor.FactorType {
+	case factors.FactorTypePush:
+		return "Okta Verify push notification"
+	case factors.FactorTypeSMS:
+		if factor.ProfileSMS != nil {
+			return "SMS to " + MaskPhoneNumber(factor.ProfileSMS.PhoneNumber)
+		}
+		return "SMS"
+	case factors.FactorTypeCall:
+		if factor.ProfileCall != nil {
+			description := "Voice call to " + MaskPhoneNumber(factor.ProfileCall.PhoneNumber)
+			if factor.ProfileCall.PhoneExtension != "" {
+				description += " ext. " + factor.ProfileCall.PhoneExtension
+			}
+			return description
+		}
+		return "Voice call"
+	case factors.FactorTypeTokenSoftwareTOTP:
+		return providerName(factor.Provider) + " code"
+	case factors.FactorTypeTokenHardware, factors.FactorTypeToken:
+		return providerName(factor.Provider) + " token"
+	case factors.FactorTypeU2F:
+		return "Security key (U2F)"
+	case factors.FactorTypeWebAuthN:
+		if factor.ProfileWebAuthN != nil && factor.ProfileWebAuthN.AuthenticatorName != "" {
+			return "Security key or biometric (" + factor.ProfileWebAuthN.AuthenticatorName + ")"
+		}
+		return "Security key or biometric (WebAuthn)"
+	case factors.FactorTypeQuestion:
+		return "Security question"
+	}
+	return fmt.Sprintf("%s (%s)", factor.FactorType, providerName(factor.Provider))
+}
+
+func providerName(provider string) string {
+	if name, ok := providerNames[strings.ToUpper(provider)]; ok {
+		return name
+	}
+	return provider
+}
+
+// Masks all but the last four digits of the phone number, keeping the country code.
+// Okta usually masks phone numbers already, ex: "+1 XXX-XXX-5555".
+func MaskPhoneNumber(phoneNumber string) string {
+	prefix := ""
+	if strings.HasPrefix(phoneNumber, "+") {
+		if i := strings.IndexAny(phoneNumber, " -("); i > 0 {
+			prefix, phoneNumber = phoneNumber[:i], phoneNumber[i:]
+		}
+	}
+
+	visible := 4
+	masked := []rune(phoneNumber)
+	for i := len(masked) - 1; i >= 0; i-- {
+		if !unicode.IsDigit(masked[i]) {
+			continue
+		}
+		if visible > 0 {
+			visible--
+			continue
+		}
+		masked[i] = 'X'
+	}
+	return prefix + string(masked)
+}
@@ -0,0 +1,13 @@
+// Implements Prompts for a terminal.
+//
+// Factors are listed with numbers to choose from, codes are read without echoing
+// them, and a spinner is shown while waiting for a push to be approved:
+//
+//	prompts := term.NewStdio()
+//	conf := okta.ClientConfig{OktaDomain: "example.okta.com"}
+//	prompts.Configure(&conf)
+//	client, err := okta.New(conf)
+//
+// Security keys aren't supported unless a SecurityKey is set, as talking to them
+// needs a HID library.
+package term
@@ -0,0 +1,138 @@
+package term
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"unicode/utf8"
+)
+
+// Control characters handled when editing a line on a terminal in raw mode.
+const (
+	ctrlC     = 0x03
+	ctrlD     = 0x04
+	backspace = 0x08
+	del       = 0x7f
+)
+
+// A chunk read from the input, or the error that ended it.
+type chunk struct {
+	b   []byte
+	err error
+}
+
+// Returns the chunks read from the input by a single background reader, so that a
+// prompt which is cancelled while reading doesn't lose the input to the next prompt.
+func (p *Prompts) chunks() <-chan chunk {
+	p.readerOnce.Do(func() {
+		p.input = make(chan chunk)
+		go func() {
+			buf := make([]byte, 256)
+			for {
+				n, err := p.in.Read(buf)
+				if n > 0 {
+					p.input <- chunk{b: append([]byte(nil), buf[:n]...)}
+				}
+				if err != nil {
+					p.input <- chunk{err: err}
+					return
+				}
+			}
+		}()
+	})
+	return p.input
+}
+
+// Returns the next byte of input, or ErrCancelled if interrupted first, or the
+// context's error if it is done first.
+func (p *Prompts) nextByte(ctx context.Context, interrupt <-chan os.Signal) (byte, error) {
+	for len(p.pending) == 0 {
+		if p.inputErr != nil {
+			return 0, p.inputErr
+		}
+		select {
+		case c := <-p.chunks():
+			p.pending, p.inputErr = c.b, c.err
+		case <-interrupt:
+			return 0, ErrCancelled
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	b := p.pending[0]
+	p.pending = p.pending[1:]
+	return b, nil
+}
+
+// Reads a line of input. Returns ErrCancelled if the user presses Ctrl-C.
+//
+// On a terminal the line is edited in raw mode, so Ctrl-C and backspace are handled
+// here and masked input is echoed as asterisks.
+func (p *Prompts) readLine(masked bool) (string, error) {
+	return p.readLineContext(context.Background(), masked)
+}
+
+// Reads a line of input until the context is done, returning its error. Input
+// that hasn't been read yet is left for the next prompt.
+func (p *Prompts) readLineContext(ctx context.Context, masked bool) (string, error) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	if p.raw {
+		restore, err := p.makeRaw()
+		if err != nil {
+			return "", err
+		}
+		defer restore()
+	}
+
+	var line []byte
+	for {
+		b, err := p.nextByte(ctx, interrupt)
+		if err == io.EOF && len(line) > 0 {
+			return string(line), nil
+		}
+		if err != nil {
+			if p.raw {
+				fmt.Fprint(p.out, "\r\n")
+			}
+			return "", err
+		}
+
+		switch {
+		case b == '\n' || (p.raw && b == '\r'):
+			if p.raw {
+				fmt.Fprint(p.out, "\r\n")
+			}
+			return string(line), nil
+		case !p.raw:
+			if b != '\r' {
+				line = append(line, b)
+			}
+		case b == ctrlC:
+			fmt.Fprint(p.out, "^C\r\n")
+			return "", ErrCancelled
+		case b == ctrlD && len(line) == 0:
+			fmt.Fprint(p.out, "\r\n")
+			return "", io.EOF
+		case b == backspace || b == del:
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+				fmt.Fprint(p.out, "\b \b")
+			}
+		case b < 0x20:
+			// Ignore other control characters
+		default:
+			line = append(line, b)
+			if !masked {
+				p.out.Write([]byte{b})
+			} else if utf8.RuneStart(b) {
+				fmt.Fprint(p.out, "*")
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package term
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+const spinnerInterval = 100 * time.Millisecond
+
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// Shows a message with an animation until stopped.
+type spinner struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Writes the message, and if animate is set redraws it with the next frame of the
+// animation on an interval.
+func startSpinner(out io.Writer, message string, animate bool) *spinner {
+	s := &spinner{stop: make(chan struct{}), done: make(chan struct{})}
+	if !animate {
+		fmt.Fprintln(out, message)
+		close(s.done)
+		return s
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+
+		for frame := 0; ; frame++ {
+			fmt.Fprintf(out, "\r%s %s", spinnerFrames[frame%len(spinnerFrames)], message)
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				// Clear the line so the next output starts on a clean line
+				fmt.Fprint(out, "\r\x1b[K")
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// Stops the animation, and waits for the line to be cleared.
+func (s *spinner) Stop() {
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	close(s.stop)
+	<-s.done
+}
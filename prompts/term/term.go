@@ -0,0 +1,363 @@
+package term
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+	"golang.org/x/term"
+)
+
+var (
+	// Returned when the user presses Ctrl-C. When entering a code this cancels the
+	// current factor, when choosing a factor it aborts the authentication.
+	ErrCancelled = errors.New("cancelled")
+	// Returned when there are no factors that can be used from the terminal.
+	ErrNoFactors = errors.New("no supported factors")
+)
+
+// Authenticates with a security key, for example using a U2F HID library.
+type SecurityKey interface {
+	CheckU2FPresence(request okta.VerifyU2FRequest) bool
+	VerifyU2F(ctx context.Context, request okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error)
+}
+
+// Prompts the user on a terminal.
+//
+// When the input is a terminal, codes are read without echoing them, and Ctrl-C
+// cancels the current factor. Use Configure to install the Prompts, so the spinner
+// shown while waiting for a push is stopped when the push is answered.
+type Prompts struct {
+	okta.NopObserver
+
+	// Used for U2F and WebAuthn factors. If nil, they aren't offered.
+	SecurityKey SecurityKey
+
+	in  io.Reader
+	out io.Writer
+	// Set if the input is a terminal, which is put in raw mode while reading.
+	raw     bool
+	makeRaw func() (restore func(), err error)
+	// Set if the output is a terminal, so can be animated.
+	animate bool
+
+	readerOnce sync.Once
+	input      chan chunk
+	pending    []byte
+	inputErr   error
+
+	mu      sync.Mutex
+	spinner *spinner
+}
+
+// Returns Prompts reading from in and writing to out.
+// Terminals are detected for inputs and outputs that are files.
+func New(in io.Reader, out io.Writer) *Prompts {
+	p := &Prompts{in: in, out: out}
+	if fd, ok := terminalFd(in); ok {
+		p.raw = true
+		p.makeRaw = func() (func(), error) {
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				return nil, err
+			}
+			return func() { term.Restore(fd, state) }, nil
+		}
+	}
+	_, p.animate = terminalFd(out)
+	return p
+}
+
+// Returns Prompts reading from stdin and writing to stderr, leaving stdout for
+// the program's output.
+func NewStdio() *Prompts {
+	return New(os.Stdin, os.Stderr)
+}
+
+// Configures the client config to prompt with p, and observe the authentication
+// to stop the spinner. Any existing Observer is preserved.
+func (p *Prompts) Configure(conf *okta.ClientConfig) {
+	conf.Prompts = p
+	if conf.Observer == nil {
+		conf.Observer = p
+	} else {
+		conf.Observer = okta.MultiObserver(conf.Observer, p)
+	}
+}
+
+func (p *Prompts) CheckU2FPresence(request okta.VerifyU2FRequest) bool {
+	if p.SecurityKey == nil {
+		return false
+	}
+	return p.SecurityKey.CheckU2FPresence(request)
+}
+
+// Lists the factors with numbers, and reads the number of the chosen factor.
+// An empty choice chooses the first factor.
+func (p *Prompts) ChooseFactor(choices []factors.Factor) (factors.Factor, error) {
+	p.stopSpinner()
+
+	var supported []factors.Factor
+	for _, choice := range choices {
+		if (choice.FactorType == factors.FactorTypeU2F || choice.FactorType == factors.FactorTypeWebAuthN) && p.SecurityKey == nil {
+			continue
+		}
+		supported = append(supported, choice)
+	}
+	if len(supported) == 0 {
+		return factors.Factor{}, ErrNoFactors
+	}
+
+	fmt.Fprintln(p.out, "Choose a factor:")
+	for i, choice := range supported {
+		fmt.Fprintf(p.out, "  %d. %s\n", i+1, Describe(choice))
+	}
+	for {
+		fmt.Fprintf(p.out, "Enter a number [1]: ")
+		line, err := p.readLine(false)
+		if err != nil {
+			return factors.Factor{}, err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return supported[0], nil
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(supported) {
+			return supported[n-1], nil
+		}
+		fmt.Fprintf(p.out, "Enter a number between 1 and %d.\n", len(supported))
+	}
+}
+
+// Lists how the Duo factor can be verified, and reads the number of the choice.
+// An empty choice sends a Duo Push.
+func (p *Prompts) ChooseDuoFactor(factor factors.Factor) (okta.DuoFactor, error) {
+	p.stopSpinner()
+
+	choices := []okta.DuoFactor{okta.DuoFactorPush, okta.DuoFactorPasscode}
+	fmt.Fprintf(p.out, "Verify with %s:\n", providerName(factor.Provider))
+	for i, choice := range choices {
+		fmt.Fprintf(p.out, "  %d. %s\n", i+1, choice)
+	}
+	for {
+		fmt.Fprintf(p.out, "Enter a number [1]: ")
+		line, err := p.readLine(false)
+		if err != nil {
+			return "", err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			return choices[0], nil
+		}
+		if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(choices) {
+			return choices[n-1], nil
+		}
+		fmt.Fprintf(p.out, "Enter a number between 1 and %d.\n", len(choices))
+	}
+}
+
+func (p *Prompts) PresentUserError(message string) {
+	p.stopSpinner()
+	fmt.Fprintf(p.out, "Error: %s\n", strings.TrimSpace(message))
+}
+
+// Shows the app the user is signing in to, if there is one.
+func (p *Prompts) PresentContext(promptContext okta.PromptContext) {
+	target := promptContext.TargetLabel
+	if target == "" {
+		target = promptContext.TargetName
+	}
+	if target == "" {
+		return
+	}
+	p.stopSpinner()
+	fmt.Fprintf(p.out, "Signing in to %s\n", target)
+}
+
+// Asks the user to touch their security key, and waits for it to respond.
+// Pressing Ctrl-C while waiting cancels the factor.
+func (p *Prompts) VerifyU2F(ctx context.Context, request okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+	p.stopSpinner()
+	if p.SecurityKey == nil {
+		return okta.VerifyU2FResponse{}, ErrNoFactors
+	}
+	fmt.Fprintln(p.out, "Touch your security key, or press Ctrl-C to cancel.")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		response okta.VerifyU2FResponse
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		response, err := p.SecurityKey.VerifyU2F(ctx, request)
+		done <- result{response, err}
+	}()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// On a terminal, Ctrl-C is read as input in raw mode. Anything else typed while
+	// waiting is discarded.
+	var input <-chan chunk
+	if p.raw {
+		restore, err := p.makeRaw()
+		if err != nil {
+			return okta.VerifyU2FResponse{}, err
+		}
+		defer restore()
+
+		pending := p.pending
+		p.pending = nil
+		if bytes.IndexByte(pending, ctrlC) >= 0 {
+			return okta.VerifyU2FResponse{}, ErrCancelled
+		}
+		if p.inputErr == nil {
+			input = p.chunks()
+		}
+	}
+
+	for {
+		select {
+		case r := <-done:
+			return r.response, r.err
+		case <-interrupt:
+			return okta.VerifyU2FResponse{}, ErrCancelled
+		case c := <-input:
+			if c.err != nil {
+				p.inputErr = c.err
+				input = nil
+			}
+			if bytes.IndexByte(c.b, ctrlC) >= 0 {
+				return okta.VerifyU2FResponse{}, ErrCancelled
+			}
+		}
+	}
+}
+
+// Reads a code for the factor without echoing it. Pressing Ctrl-C cancels the factor.
+func (p *Prompts) VerifyCode(factor factors.Factor) (string, error) {
+	p.stopSpinner()
+	return p.readCode(context.Background(), factor)
+}
+
+// Reads a code for the factor while waiting for a push, until the push is answered
+// and the context is cancelled. Pressing Ctrl-C cancels the push.
+func (p *Prompts) VerifyCodeContext(ctx context.Context, factor factors.Factor) (string, error) {
+	if p.stopSpinner() {
+		fmt.Fprintln(p.out, "Approve the push on your device, or enter a code instead.")
+	}
+	code, err := p.readCode(ctx, factor)
+	if err != nil && err == ctx.Err() && !p.raw {
+		// Finish the prompt's line, raw mode already has
+		fmt.Fprintln(p.out)
+	}
+	return code, err
+}
+
+func (p *Prompts) readCode(ctx context.Context, factor factors.Factor) (string, error) {
+	for {
+		fmt.Fprint(p.out, codePrompt(factor))
+		code, err := p.readLineContext(ctx, true)
+		if err != nil {
+			return "", err
+		}
+		if code = strings.TrimSpace(code); code != "" {
+			return code, nil
+		}
+	}
+}
+
+// Prints the prompt and reads a line, for input needed before authenticating such
+// as the username. Pressing Ctrl-C returns ErrCancelled.
+func (p *Prompts) ReadLine(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	line, err := p.readLine(false)
+	return strings.TrimSpace(line), err
+}
+
+// Prints the prompt and reads a line without echoing it, such as a password.
+// The line isn't trimmed. Pressing Ctrl-C returns ErrCancelled.
+func (p *Prompts) ReadSecret(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	return p.readLine(true)
+}
+
+// Shows a spinner until the push is answered.
+func (p *Prompts) VerifyPush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.spinner != nil {
+		p.spinner.Stop()
+	}
+	p.spinner = startSpinner(p.out, "Waiting for the push to be approved on your device...", p.animate)
+}
+
+func (p *Prompts) FactorResult(okta.FactorResultEvent) {
+	p.stopSpinner()
+}
+
+func (p *Prompts) AuthenticationFinished(okta.AuthenticationFinishedEvent) {
+	p.stopSpinner()
+}
+
+// Stops the spinner, returning true if it was running.
+func (p *Prompts) stopSpinner() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.spinner == nil {
+		return false
+	}
+	p.spinner.Stop()
+	p.spinner = nil
+	return true
+}
+
+func codePrompt(factor factors.Factor) string {
+	switch factor.FactorType {
+	case factors.FactorTypeSMS:
+		if factor.ProfileSMS != nil {
+			return fmt.Sprintf("Enter the code sent to %s: ", MaskPhoneNumber(factor.ProfileSMS.PhoneNumber))
+		}
+	case factors.FactorTypeCall:
+		if factor.ProfileCall != nil {
+			return fmt.Sprintf("Enter the code from the call to %s: ", MaskPhoneNumber(factor.ProfileCall.PhoneNumber))
+		}
+	case factors.FactorTypeTokenSoftwareTOTP:
+		return fmt.Sprintf("Enter the %s: ", Describe(factor))
+	case factors.FactorTypeTokenHardware, factors.FactorTypeToken:
+		return fmt.Sprintf("Enter the code from your %s: ", Describe(factor))
+	case factors.FactorTypeWeb:
+		return fmt.Sprintf("Enter a %s passcode: ", providerName(factor.Provider))
+	case factors.FactorTypeQuestion:
+		if factor.ProfileQuestion != nil {
+			return factor.ProfileQuestion.QuestionText + " "
+		}
+		return "Enter the answer to your security question: "
+	}
+	return "Enter the code: "
+}
+
+// Returns the file descriptor of the reader or writer if it is a terminal.
+func terminalFd(v interface{}) (int, bool) {
+	f, ok := v.(interface{ Fd() uintptr })
+	if !ok {
+		return 0, false
+	}
+	fd := int(f.Fd())
+	return fd, term.IsTerminal(fd)
+}
@@ -0,0 +1,417 @@
+package term
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+	"github.com/wearefair/okta-auth/oktatest"
+)
+
+var (
+	sms = factors.Factor{
+		Id:         "sms1",
+		FactorType: factors.FactorTypeSMS,
+		Provider:   "OKTA",
+		ProfileSMS: &factors.ProfileSMS{PhoneNumber: "+1 415-555-1234"},
+	}
+	push = factors.Factor{Id: "push1", FactorType: factors.FactorTypePush, Provider: "OKTA"}
+	u2f  = factors.Factor{Id: "u2f1", FactorType: factors.FactorTypeU2F, Provider: "FIDO"}
+)
+
+func TestAuthenticate(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{
+		Login:    "first@example.com",
+		Password: "password",
+		Factors:  []*oktatest.Factor{oktatest.SMSFactor("+1 XXX-XXX-5555", "123456")},
+	})
+
+	// The first code is wrong, so the factor is chosen again
+	out := &bytes.Buffer{}
+	conf := okta.ClientConfig{OktaDomain: server.URL}
+	New(strings.NewReader("1\n000000\n\n123456\n"), out).Configure(&conf)
+	client, err := okta.New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Authenticate("first@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Choose a factor:\n" +
+		"  1. SMS to +1 XXX-XXX-5555\n" +
+		"Enter a number [1]: Enter the code sent to +1 XXX-XXX-5555: " +
+		"Error: Invalid Passcode/Answer\n" +
+		"Choose a factor:\n" +
+		"  1. SMS to +1 XXX-XXX-5555\n" +
+		"Enter a number [1]: Enter the code sent to +1 XXX-XXX-5555: "
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, out)
+	}
+}
+
+func TestChooseFactor(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := New(strings.NewReader("9\nabc\n2\n"), out)
+
+	choice, err := p.ChooseFactor([]factors.Factor{sms, u2f, push})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if choice.Id != "push1" {
+		t.Errorf("expected the push factor, got %#+v", choice)
+	}
+
+	// U2F isn't offered without a security key
+	expected := "Choose a factor:\n" +
+		"  1. SMS to +1 XXX-XXX-1234\n" +
+		"  2. Okta Verify push notification\n" +
+		"Enter a number [1]: Enter a number between 1 and 2.\n" +
+		"Enter a number [1]: Enter a number between 1 and 2.\n" +
+		"Enter a number [1]: "
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, out)
+	}
+}
+
+func TestPresentContext(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := New(strings.NewReader(""), out)
+	p.PresentContext(okta.PromptContext{AllowRememberDevice: true})
+	p.PresentContext(okta.PromptContext{TargetName: "amazon_aws"})
+	p.PresentContext(okta.PromptContext{TargetName: "amazon_aws", TargetLabel: "AWS Prod"})
+
+	// Nothing is shown without a target
+	expected := "Signing in to amazon_aws\nSigning in to AWS Prod\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestChooseFactorDefault(t *testing.T) {
+	p := New(strings.NewReader("\r\n"), io.Discard)
+	choice, err := p.ChooseFactor([]factors.Factor{sms, push})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if choice.Id != "sms1" {
+		t.Errorf("expected the first factor, got %#+v", choice)
+	}
+}
+
+func TestChooseFactorEOF(t *testing.T) {
+	p := New(strings.NewReader(""), io.Discard)
+	if _, err := p.ChooseFactor([]factors.Factor{sms}); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestChooseDuoFactor(t *testing.T) {
+	duo := factors.Factor{Id: "duo1", FactorType: factors.FactorTypeWeb, Provider: "DUO"}
+	out := &bytes.Buffer{}
+	p := New(strings.NewReader("3\n2\n\n"), out)
+
+	choice, err := p.ChooseDuoFactor(duo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if choice != okta.DuoFactorPasscode {
+		t.Errorf("expected a passcode, got %q", choice)
+	}
+	if choice, _ := p.ChooseDuoFactor(duo); choice != okta.DuoFactorPush {
+		t.Errorf("expected a push by default, got %q", choice)
+	}
+
+	expected := "Verify with Duo Security:\n" +
+		"  1. Duo Push\n" +
+		"  2. Passcode\n" +
+		"Enter a number [1]: Enter a number between 1 and 2.\n" +
+		"Enter a number [1]: "
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Errorf("Expected:\n%s\nActual:\n%s", expected, out)
+	}
+	if prompt := codePrompt(duo); prompt != "Enter a Duo Security passcode: " {
+		t.Errorf("unexpected code prompt %q", prompt)
+	}
+}
+
+func TestVerifyCode(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := New(strings.NewReader("\n 123456 \n"), out)
+
+	code, err := p.VerifyCode(sms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "123456" {
+		t.Errorf("expected 123456, got %q", code)
+	}
+	// Empty codes are asked for again
+	expected := "Enter the code sent to +1 XXX-XXX-1234: Enter the code sent to +1 XXX-XXX-1234: "
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestVerifyCodeContext(t *testing.T) {
+	totp := factors.Factor{Id: "totp1", FactorType: factors.FactorTypeTokenSoftwareTOTP, Provider: "GOOGLE"}
+	out := &lockedBuffer{}
+	in, input := io.Pipe()
+	p := New(in, out)
+
+	// The push is approved before a code is entered
+	p.VerifyPush()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.VerifyCodeContext(ctx, totp)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected the prompt to be cancelled, got %v", err)
+	}
+	expected := "Waiting for the push to be approved on your device...\n" +
+		"Approve the push on your device, or enter a code instead.\n" +
+		"Enter the Google Authenticator code: \n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	// Input after the prompt was cancelled is read by the next prompt
+	go input.Write([]byte("first@example.com\n"))
+	if username, err := p.ReadLine("Username: "); err != nil || username != "first@example.com" {
+		t.Errorf("expected the username, got %q, %v", username, err)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := New(strings.NewReader(" first@example.com \n pass word \n"), out)
+
+	username, err := p.ReadLine("Username: ")
+	if err != nil || username != "first@example.com" {
+		t.Errorf("expected the trimmed username, got %q, %v", username, err)
+	}
+	password, err := p.ReadSecret("Password: ")
+	if err != nil || password != " pass word " {
+		t.Errorf("expected the untrimmed password, got %q, %v", password, err)
+	}
+	if out.String() != "Username: Password: " {
+		t.Errorf("unexpected output %q", out)
+	}
+}
+
+func TestRawInput(t *testing.T) {
+	testCases := []struct {
+		input    string
+		masked   bool
+		expected string
+		echo     string
+		err      error
+	}{
+		{input: "12\x7f3\r", masked: true, expected: "13", echo: "**\b \b*\r\n"},
+		{input: "ab\bc\r", masked: false, expected: "ac", echo: "ab\b \bc\r\n"},
+		{input: "\x7f1\r", masked: true, expected: "1", echo: "*\r\n"},
+		{input: "12\x03", masked: true, err: ErrCancelled, echo: "**^C\r\n"},
+		{input: "\x04", masked: true, err: io.EOF, echo: "\r\n"},
+		{input: "\x1b1\r", masked: false, expected: "1", echo: "1\r\n"},
+	}
+
+	for i, testCase := range testCases {
+		out := &bytes.Buffer{}
+		p := newRawPrompts(strings.NewReader(testCase.input), out)
+
+		line, err := p.readLine(testCase.masked)
+		if err != testCase.err {
+			t.Errorf("%0d: expected error %v, got %v", i, testCase.err, err)
+		}
+		if line != testCase.expected {
+			t.Errorf("%0d: expected %q, got %q", i, testCase.expected, line)
+		}
+		if out.String() != testCase.echo {
+			t.Errorf("%0d: expected echo %q, got %q", i, testCase.echo, out)
+		}
+		if p.restores != 1 {
+			t.Errorf("%0d: expected the terminal to be restored once, got %d", i, p.restores)
+		}
+	}
+}
+
+func TestVerifyU2F(t *testing.T) {
+	t.Run("returns the security key response", func(t *testing.T) {
+		p := New(strings.NewReader(""), io.Discard)
+		p.SecurityKey = &fakeSecurityKey{response: okta.VerifyU2FResponse{ClientData: "client-data"}}
+
+		response, err := p.VerifyU2F(context.Background(), okta.VerifyU2FRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.ClientData != "client-data" {
+			t.Errorf("expected the response, got %#+v", response)
+		}
+	})
+
+	t.Run("Ctrl-C cancels", func(t *testing.T) {
+		key := &fakeSecurityKey{wait: true, cancelled: make(chan struct{})}
+		p := newRawPrompts(strings.NewReader("\x03"), io.Discard)
+		p.SecurityKey = key
+
+		if _, err := p.VerifyU2F(context.Background(), okta.VerifyU2FRequest{}); err != ErrCancelled {
+			t.Errorf("expected ErrCancelled, got %v", err)
+		}
+		select {
+		case <-key.cancelled:
+		case <-time.After(time.Second):
+			t.Error("expected the security key context to be cancelled")
+		}
+	})
+
+	t.Run("without a security key", func(t *testing.T) {
+		p := New(strings.NewReader(""), io.Discard)
+		if p.CheckU2FPresence(okta.VerifyU2FRequest{}) {
+			t.Error("expected no security key to be present")
+		}
+		if _, err := p.VerifyU2F(context.Background(), okta.VerifyU2FRequest{}); err != ErrNoFactors {
+			t.Errorf("expected ErrNoFactors, got %v", err)
+		}
+	})
+}
+
+func TestVerifyPush(t *testing.T) {
+	out := &bytes.Buffer{}
+	p := New(strings.NewReader(""), out)
+
+	p.VerifyPush()
+	p.FactorResult(okta.FactorResultEvent{Factor: push})
+	p.PresentUserError("Failed to authenticate: rejected\n")
+
+	expected := "Waiting for the push to be approved on your device...\nError: Failed to authenticate: rejected\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+}
+
+func TestSpinner(t *testing.T) {
+	out := &lockedBuffer{}
+	s := startSpinner(out, "Waiting", true)
+	time.Sleep(spinnerInterval + spinnerInterval/2)
+	s.Stop()
+	s.Stop()
+
+	output := out.String()
+	if !strings.HasPrefix(output, "\r| Waiting\r/ Waiting") || !strings.HasSuffix(output, "\r\x1b[K") {
+		t.Errorf("unexpected spinner output %q", output)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	testCases := []struct {
+		factor   factors.Factor
+		expected string
+	}{
+		{sms, "SMS to +1 XXX-XXX-1234"},
+		{push, "Okta Verify push notification"},
+		{u2f, "Security key (U2F)"},
+		{factors.Factor{FactorType: factors.FactorTypeWebAuthN}, "Security key or biometric (WebAuthn)"},
+		{factors.Factor{FactorType: factors.FactorTypeWebAuthN, ProfileWebAuthN: &factors.ProfileWebAuthN{AuthenticatorName: "YubiKey 5 NFC"}}, "Security key or biometric (YubiKey 5 NFC)"},
+		{factors.Factor{FactorType: factors.FactorTypeCall, ProfileCall: &factors.ProfileCall{PhoneNumber: "+44 20 7946 0958", PhoneExtension: "12"}}, "Voice call to +44 XX XXXX 0958 ext. 12"},
+		{factors.Factor{FactorType: factors.FactorTypeTokenSoftwareTOTP, Provider: "GOOGLE"}, "Google Authenticator code"},
+		{factors.Factor{FactorType: factors.FactorTypeTokenSoftwareTOTP, Provider: "OKTA"}, "Okta Verify code"},
+		{factors.Factor{FactorType: factors.FactorTypeTokenHardware, Provider: "YUBICO"}, "YubiKey token"},
+		{factors.Factor{FactorType: factors.FactorTypeQuestion}, "Security question"},
+		{factors.Factor{FactorType: "web", Provider: "DUO"}, "web (Duo Security)"},
+	}
+
+	for i, testCase := range testCases {
+		actual := Describe(testCase.factor)
+		if actual != testCase.expected {
+			t.Errorf("%0d: expected %q, got %q", i, testCase.expected, actual)
+		}
+	}
+}
+
+func TestMaskPhoneNumber(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{"+1 XXX-XXX-5555", "+1 XXX-XXX-5555"},
+		{"+1 415-555-1234", "+1 XXX-XXX-1234"},
+		{"+14155551234", "+XXXXXXX1234"},
+		{"4155551234", "XXXXXX1234"},
+		{"123", "123"},
+		{"", ""},
+	}
+
+	for i, testCase := range testCases {
+		actual := MaskPhoneNumber(testCase.input)
+		if actual != testCase.expected {
+			t.Errorf("%0d: expected %q, got %q", i, testCase.expected, actual)
+		}
+	}
+}
+
+// Prompts that edit input as a terminal in raw mode would, counting the times the
+// terminal is restored.
+type rawPrompts struct {
+	*Prompts
+	restores int
+}
+
+func newRawPrompts(in io.Reader, out io.Writer) *rawPrompts {
+	p := &rawPrompts{Prompts: New(in, out)}
+	p.raw = true
+	p.makeRaw = func() (func(), error) {
+		return func() { p.restores++ }, nil
+	}
+	return p
+}
+
+type fakeSecurityKey struct {
+	response okta.VerifyU2FResponse
+	// If set, waits for the context to be cancelled.
+	wait      bool
+	cancelled chan struct{}
+}
+
+func (k *fakeSecurityKey) CheckU2FPresence(okta.VerifyU2FRequest) bool {
+	return true
+}
+
+func (k *fakeSecurityKey) VerifyU2F(ctx context.Context, request okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+	if !k.wait {
+		return k.response, nil
+	}
+	<-ctx.Done()
+	close(k.cancelled)
+	return okta.VerifyU2FResponse{}, errors.New("cancelled")
+}
+
+// A buffer that can be written by the spinner while the test reads it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,128 @@
+package okta
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Returned when the code prompt raced against a push fails, which cancels the push.
+var errRacedCodeCancelled = errors.New("Cancelled")
+
+// Returns the factor to prompt for a code with while waiting for the chosen push,
+// or a zero factor if they aren't raced.
+func (c *OktaClient) raceFactorFor(chosen api.Factor, allowed api.Factors) api.Factor {
+	if !c.factorPolicy.RacePushWithTOTP || chosen.FactorType != factors.FactorTypePush {
+		return api.Factor{}
+	}
+	if _, ok := c.prompts.(CancelableCodePrompts); !ok {
+		return api.Factor{}
+	}
+	for _, factor := range allowed {
+		if factor.FactorType == factors.FactorTypeTokenSoftwareTOTP {
+			return factor
+		}
+	}
+	return api.Factor{}
+}
+
+// The result of a code prompt run on another goroutine.
+type racedCode struct {
+	code string
+	err  error
+	wait time.Duration
+}
+
+// Prompts for a code for the factor while poll waits for the push, returning the
+// code if it is entered before the push is answered. If the push is answered
+// first the prompt is cancelled, and the result of poll is returned.
+//
+// Both have returned when this returns, so the flow is only ever used by one
+// goroutine and the next prompt doesn't overlap the cancelled one.
+func (c *OktaClient) racePushWithCode(flow *authFlow, factor api.Factor, poll func(context.Context) error) (string, error) {
+	pollCtx, cancelPoll := context.WithCancel(flow.ctx)
+	defer cancelPoll()
+	promptCtx, cancelPrompt := context.WithCancel(flow.ctx)
+	defer cancelPrompt()
+
+	polled := make(chan error, 1)
+	go func() { polled <- poll(pollCtx) }()
+	codes := c.verifyCodeAsync(flow, promptCtx, factor.Public())
+
+	select {
+	case err := <-polled:
+		cancelPrompt()
+		flow.promptWait += (<-codes).wait
+		return "", err
+	case result := <-codes:
+		flow.promptWait += result.wait
+		cancelPoll()
+		// The push may have been approved while the code was being entered
+		if err := <-polled; err == nil {
+			return "", nil
+		}
+		if result.err != nil {
+			c.logger.Debug("Code prompt raced against the push failed", errorLogAttr(result.err))
+			return "", errRacedCodeCancelled
+		}
+		return result.code, nil
+	}
+}
+
+// Calls VerifyCodeContext on another goroutine, in its own span like the other
+// prompts. The time spent waiting is returned with the result, rather than
+// updating the flow from the goroutine.
+func (c *OktaClient) verifyCodeAsync(flow *authFlow, ctx context.Context, factor factors.Factor) <-chan racedCode {
+	prompts := c.prompts.(CancelableCodePrompts)
+	_, span := c.tracer.Start(flow.ctx, "okta.prompt VerifyCodeContext", trace.WithAttributes(traceKeyPrompt.String("VerifyCodeContext")))
+	start := time.Now()
+
+	codes := make(chan racedCode, 1)
+	go func() {
+		code, err := prompts.VerifyCodeContext(ctx, factor)
+		span.End()
+		codes <- racedCode{code: code, err: err, wait: time.Since(start)}
+	}()
+	return codes
+}
+
+// Verifies the code entered while waiting for the push. The push is cancelled by
+// going back to the list of factors, from which the code's factor is verified.
+// If the code is wrong the user chooses a factor again.
+func (c *OktaClient) verifyRacedCode(flow *authFlow, transaction api.AuthenticationTransaction, factor api.Factor, code string) (string, error) {
+	c.observeFactorResult(flow, api.FactorResultCancelled)
+	required, apiError, err := c.sendLinkRequest(flow.ctx, transaction.Links, "prev", &api.FactorVerify{StateToken: transaction.StateToken})
+	if err != nil {
+		return "", err
+	}
+	if apiError != nil {
+		c.transactionLogger(transaction).Error("Got error trying to cancel the push", slog.String(LogKeyError, apiError.ErrorSummary))
+		return "", TerminalError(unexpectedErrorMessage)
+	}
+	c.observeTransition(flow, required)
+
+	c.transactionLogger(required).Debug("Verifying the code entered instead of the push", factorLogAttrs(factor)...)
+	c.observeFactorChosen(factor, false)
+	c.observeChallengeIssued(flow, factor)
+	newTransaction, apiError, err := c.sendLinkRequest(flow.ctx, factor.Links, "verify", &api.FactorVerifyCode{
+		FactorVerify: api.FactorVerify{
+			StateToken: required.StateToken,
+		},
+		PassCode: code,
+	})
+	if err != nil {
+		return "", err
+	}
+	if apiError != nil {
+		c.observeVerifyError(flow, apiError)
+		c.presentUserError(flow, apiError.ErrorSummary)
+		return c.handleAuthUserFlow(flow, required, false)
+	}
+	c.observeVerifiedTransaction(flow, newTransaction)
+	return c.handleAuthUserFlow(flow, newTransaction, false)
+}
@@ -0,0 +1,152 @@
+package okta
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const redactedValue = "[REDACTED]"
+
+// JSON field names that are scrubbed from request and response bodies before they
+// are written to the DebugLogger. Matching is case insensitive, and applies at any
+// depth in the document.
+var DefaultRedactedFields = []string{
+	// Credentials and tokens
+	"password",
+	"passCode",
+	"answer",
+	"stateToken",
+	"sessionToken",
+	"recoveryToken",
+	"activationToken",
+
+	// U2F and WebAuthn assertions
+	"clientData",
+	"signatureData",
+	"authenticatorData",
+
+	// User profile PII
+	"login",
+	"email",
+	"firstName",
+	"lastName",
+	"secondEmail",
+	"mobilePhone",
+}
+
+// HTTP headers that are scrubbed before they are written to the DebugLogger.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// Scrubs sensitive values from request and response data so they can be logged.
+type redactor struct {
+	fields  map[string]bool
+	headers map[string]bool
+}
+
+// Returns a redactor for the default fields and headers, and those added by the
+// config, less those the config opts out of.
+func newRedactor(conf ClientConfig) redactor {
+	r := redactor{fields: map[string]bool{}, headers: map[string]bool{}}
+	for _, fields := range [][]string{DefaultRedactedFields, conf.RedactedFields} {
+		for _, field := range fields {
+			r.fields[strings.ToLower(field)] = true
+		}
+	}
+	for _, field := range conf.UnredactedFields {
+		delete(r.fields, strings.ToLower(field))
+	}
+	for _, headers := range [][]string{DefaultRedactedHeaders, conf.RedactedHeaders} {
+		for _, header := range headers {
+			r.headers[http.CanonicalHeaderKey(header)] = true
+		}
+	}
+	for _, header := range conf.UnredactedHeaders {
+		delete(r.headers, http.CanonicalHeaderKey(header))
+	}
+	return r
+}
+
+// Returns the JSON body with the values of all sensitive fields replaced.
+// Bodies that can't be parsed as JSON are not returned at all, as there is no way to
+// know what they contain.
+func (r redactor) body(body []byte) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return fmt.Sprintf("[non-JSON body of %d bytes]", len(body))
+	}
+
+	redacted, err := json.Marshal(r.value(doc))
+	if err != nil {
+		return fmt.Sprintf("[unserializable body of %d bytes]", len(body))
+	}
+	return string(redacted)
+}
+
+// Serializes the value to JSON and redacts it.
+func (r redactor) object(value interface{}) string {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("[unserializable %T]", value)
+	}
+	return r.body(body)
+}
+
+func (r redactor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if r.fields[strings.ToLower(key)] {
+				v[key] = redactedValue
+			} else {
+				v[key] = r.value(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = r.value(child)
+		}
+	}
+	return value
+}
+
+// Returns a copy of the headers with the values of all sensitive headers replaced.
+func (r redactor) header(header http.Header) http.Header {
+	re := make(http.Header, len(header))
+	for key, values := range header {
+		if r.headers[http.CanonicalHeaderKey(key)] {
+			re[key] = []string{redactedValue}
+		} else {
+			re[key] = values
+		}
+	}
+	return re
+}
+
+// Removes the query from the URL of an error from sending a request, which can
+// hold a session token, ex: in an authorize request. The query is already left
+// out of request logs and spans.
+func redactURLError(err error) error {
+	urlError, ok := err.(*url.Error)
+	if !ok {
+		return err
+	}
+	redacted := *urlError
+	redacted.URL, _, _ = strings.Cut(urlError.URL, "?")
+	return &redacted
+}
@@ -0,0 +1,239 @@
+package okta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRedactorBody(t *testing.T) {
+	r := newRedactor(ClientConfig{})
+
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{
+			input:    `{"username":"first@example.com","password":"hunter2"}`,
+			expected: `{"password":"[REDACTED]","username":"first@example.com"}`,
+		},
+		{
+			input:    `{"stateToken":"abc","_embedded":{"user":{"profile":{"login":"first@example.com","firstName":"First"}}}}`,
+			expected: `{"_embedded":{"user":{"profile":{"firstName":"[REDACTED]","login":"[REDACTED]"}}},"stateToken":"[REDACTED]"}`,
+		},
+		{
+			input:    `[{"PASSCODE":"123456","expiresAt":1.50}]`,
+			expected: `[{"PASSCODE":"[REDACTED]","expiresAt":1.50}]`,
+		},
+		{
+			input:    `<html>password=hunter2</html>`,
+			expected: `[non-JSON body of 29 bytes]`,
+		},
+		{
+			input:    ``,
+			expected: ``,
+		},
+	}
+
+	for i, testCase := range testCases {
+		actual := r.body([]byte(testCase.input))
+		if actual != testCase.expected {
+			t.Errorf("%0d: Expected:\n    %s\nActual:\n    %s\n", i, testCase.expected, actual)
+		}
+	}
+}
+
+func TestRedactorConfigurable(t *testing.T) {
+	t.Run("added to the defaults", func(t *testing.T) {
+		r := newRedactor(ClientConfig{RedactedFields: []string{"username"}, RedactedHeaders: []string{"x-custom"}})
+
+		actual := r.body([]byte(`{"username":"first@example.com","password":"hunter2","stateToken":"state"}`))
+		expected := `{"password":"[REDACTED]","stateToken":"[REDACTED]","username":"[REDACTED]"}`
+		if actual != expected {
+			t.Errorf("Expected %s, got %s", expected, actual)
+		}
+
+		header := r.header(http.Header{"X-Custom": {"secret"}, "Cookie": {"sid=abc"}})
+		if header.Get("X-Custom") != redactedValue || header.Get("Cookie") != redactedValue {
+			t.Errorf("Expected X-Custom and Cookie to be redacted, got %v", header)
+		}
+	})
+
+	t.Run("defaults opted out of", func(t *testing.T) {
+		r := newRedactor(ClientConfig{UnredactedFields: []string{"LOGIN"}, UnredactedHeaders: []string{"cookie"}})
+
+		actual := r.body([]byte(`{"login":"first@example.com","password":"hunter2"}`))
+		expected := `{"login":"first@example.com","password":"[REDACTED]"}`
+		if actual != expected {
+			t.Errorf("Expected %s, got %s", expected, actual)
+		}
+
+		header := r.header(http.Header{"Authorization": {"SSWS token"}, "Cookie": {"sid=abc"}})
+		if header.Get("Cookie") != "sid=abc" {
+			t.Errorf("Expected Cookie to be left alone, got %q", header.Get("Cookie"))
+		}
+		if header.Get("Authorization") != redactedValue {
+			t.Errorf("Expected Authorization to be redacted, got %q", header.Get("Authorization"))
+		}
+	})
+}
+
+func TestRedactorHeader(t *testing.T) {
+	r := newRedactor(ClientConfig{})
+	original := http.Header{
+		"Authorization": {"SSWS token"},
+		"Cookie":        {"sid=abc"},
+		"Set-Cookie":    {"sid=abc; Path=/"},
+		"Content-Type":  {"application/json"},
+	}
+
+	header := r.header(original)
+	for _, key := range []string{"Authorization", "Cookie", "Set-Cookie"} {
+		if header.Get(key) != redactedValue {
+			t.Errorf("Expected %s to be redacted, got %q", key, header.Get(key))
+		}
+	}
+	if header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected Content-Type to be kept, got %q", header.Get("Content-Type"))
+	}
+	if original.Get("Cookie") != "sid=abc" {
+		t.Errorf("Expected original headers to be unmodified")
+	}
+}
+
+const secretPassword = "correct-horse-battery-staple"
+
+func TestPasswordNeverLogged(t *testing.T) {
+	t.Run("successful authentication", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Set-Cookie", "sid=secret-session-id")
+			fmt.Fprintf(w, `{"status":"SUCCESS","sessionToken":"secret-session-token","_embedded":{"user":{"profile":{"login":"first@example.com"}}}}`)
+		}))
+		defer server.Close()
+
+		logger := &recordingLogger{}
+		client, err := New(ClientConfig{OktaDomain: server.URL, Prompts: TestPrompts{}, DebugLogger: logger})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Authenticate("first@example.com", secretPassword); err != nil {
+			t.Fatal(err)
+		}
+		logger.assertNotContains(t, secretPassword, "secret-session-token", "secret-session-id")
+	})
+
+	t.Run("failed authentication", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, `{"errorCode":"E0000004","errorSummary":"Authentication failed"}`)
+		}))
+		defer server.Close()
+
+		logger := &recordingLogger{}
+		client, err := New(ClientConfig{OktaDomain: server.URL, Prompts: TestPrompts{}, DebugLogger: logger})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Authenticate("first@example.com", secretPassword); err == nil {
+			t.Fatal("expected error")
+		}
+		logger.assertNotContains(t, secretPassword)
+	})
+
+	t.Run("transport error", func(t *testing.T) {
+		logger := &recordingLogger{}
+		client, err := New(ClientConfig{
+			OktaDomain:   "test.okta.com",
+			Prompts:      TestPrompts{},
+			DebugLogger:  logger,
+			RoundTripper: failingRoundTripper{},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Authenticate("first@example.com", secretPassword); err == nil {
+			t.Fatal("expected error")
+		}
+		logger.assertNotContains(t, secretPassword)
+	})
+}
+
+func TestSessionTokenNeverLogged(t *testing.T) {
+	logger := &recordingLogger{}
+	recorder := tracetest.NewSpanRecorder()
+	client, err := New(ClientConfig{
+		OktaDomain:     "test.okta.com",
+		Prompts:        TestPrompts{},
+		DebugLogger:    logger,
+		RoundTripper:   failingRoundTripper{},
+		TracerProvider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The session token is in the authorize request's query
+	request := TokenRequest{ClientId: "cli", RedirectURI: "http://localhost:8080/callback", SessionToken: "secret-session-token"}
+	_, err = client.Tokens(context.Background(), request)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), "secret-session-token") {
+		t.Errorf("error contains the session token: %s", err)
+	}
+	logger.assertNotContains(t, "secret-session-token")
+	for _, span := range recorder.Ended() {
+		if strings.Contains(span.Status().Description, "secret-session-token") {
+			t.Errorf("span status contains the session token: %s", span.Status().Description)
+		}
+		for _, event := range span.Events() {
+			for _, attr := range event.Attributes {
+				if strings.Contains(attr.Value.Emit(), "secret-session-token") {
+					t.Errorf("span event contains the session token: %s", attr.Value.Emit())
+				}
+			}
+		}
+	}
+}
+
+type recordingLogger struct {
+	mu   sync.Mutex
+	logs []string
+}
+
+func (l *recordingLogger) Log(msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logs = append(l.logs, msg)
+}
+
+func (l *recordingLogger) assertNotContains(t *testing.T, secrets ...string) {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.logs) == 0 {
+		t.Fatal("expected debug logs to be written")
+	}
+	for _, log := range l.logs {
+		for _, secret := range secrets {
+			if strings.Contains(log, secret) {
+				t.Errorf("log contains secret %q: %s", secret, log)
+			}
+		}
+	}
+}
+
+type failingRoundTripper struct{}
+
+func (failingRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("connection refused")
+}
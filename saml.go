@@ -0,0 +1,81 @@
+package okta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+)
+
+// Requests a SAML assertion for an app the user is assigned to.
+type SAMLRequest struct {
+	// The app's embed link, from the app's General tab in the Okta admin console.
+	// Ex: https://example.okta.com/home/amazon_aws/0oa1abcdefghijklmnop/272
+	AppURL string
+
+	// Authorizes as the user the session token was issued to, see Authenticate.
+	SessionToken string
+	// Authorizes as the user of the session, see CreateSession. Ignored if SessionToken is set.
+	SessionId string
+}
+
+// Matches the hidden form input holding the assertion in the app's page.
+var (
+	samlInputPattern = regexp.MustCompile(`<input[^>]+name="SAMLResponse"[^>]*>`)
+	samlValuePattern = regexp.MustCompile(`value="([^"]*)"`)
+)
+
+// Returns the base64 encoded SAML response for the user of the session token or
+// session, which is posted to the service provider to sign in to the app. The
+// assertion isn't validated or decoded.
+// https://developer.okta.com/docs/guides/session-cookie/main/#retrieve-a-session-cookie-by-visiting-an-application-embed-link
+func (c *OktaClient) SAMLAssertion(ctx context.Context, request SAMLRequest) (string, error) {
+	if request.SessionToken == "" && request.SessionId == "" {
+		return "", errors.New("a session token or session id is required")
+	}
+	appURL, err := url.Parse(request.AppURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid app url: %s", err)
+	}
+
+	// Embed links redirect to the app's SSO endpoint, setting the session cookie
+	// from the session token on the way, so cookies are kept across the redirects
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return "", err
+	}
+	client := *c.httpClient
+	client.Jar = jar
+
+	header := http.Header{"Accept": {"text/html"}}
+	if request.SessionToken != "" {
+		query := appURL.Query()
+		query.Set("sessionToken", request.SessionToken)
+		appURL.RawQuery = query.Encode()
+	} else {
+		jar.SetCookies(appURL, []*http.Cookie{{Name: sessionCookieName, Value: request.SessionId, Path: "/"}})
+	}
+
+	response, body, err := c.sendOAuthRequest(ctx, &client, http.MethodGet, appURL.String(), header, "")
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("app request returned status %d", response.StatusCode)
+	}
+
+	input := samlInputPattern.Find(body)
+	if input == nil {
+		// Okta shows its sign in page when the session isn't valid
+		return "", errors.New("the app's page has no SAML response, check the app url and that the user is assigned to the app")
+	}
+	value := samlValuePattern.FindSubmatch(input)
+	if value == nil || len(value[1]) == 0 {
+		return "", errors.New("the app's page has an empty SAML response")
+	}
+	return html.UnescapeString(string(value[1])), nil
+}
@@ -0,0 +1,101 @@
+package okta
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/wearefair/okta-auth/api"
+)
+
+// Name of the cookie Okta identifies sessions by.
+const sessionCookieName = "sid"
+
+// Returned when a session doesn't exist, or has expired.
+var ErrSessionNotFound = errors.New("session not found or expired")
+
+// A session with Okta, created by exchanging a session token.
+// https://developer.okta.com/docs/reference/api/sessions/#session-object
+type Session struct {
+	// The session id, which is sent as the sid cookie to act as the user.
+	Id                       string    `json:"id"`
+	UserId                   string    `json:"userId"`
+	Login                    string    `json:"login"`
+	Status                   string    `json:"status"`
+	CreatedAt                time.Time `json:"createdAt"`
+	ExpiresAt                time.Time `json:"expiresAt"`
+	LastPasswordVerification time.Time `json:"lastPasswordVerification,omitempty"`
+	LastFactorVerification   time.Time `json:"lastFactorVerification,omitempty"`
+	// Authentication methods used to create the session, ex: "pwd", "mfa".
+	AMR       []string `json:"amr,omitempty"`
+	MFAActive bool     `json:"mfaActive"`
+}
+
+// Exchanges a session token returned by Authenticate for a session.
+// Session tokens can only be exchanged once, and expire after five minutes.
+func (c *OktaClient) CreateSession(ctx context.Context, sessionToken string) (*Session, error) {
+	return c.sendSessionRequest(ctx, http.MethodPost, "/api/v1/sessions", "", &api.CreateSessionRequest{
+		SessionToken: sessionToken,
+	})
+}
+
+// Returns the session with the given id.
+func (c *OktaClient) GetSession(ctx context.Context, sessionId string) (*Session, error) {
+	return c.sendSessionRequest(ctx, http.MethodGet, "/api/v1/sessions/me", sessionId, nil)
+}
+
+// Extends the session with the given id, returning it with its new expiry.
+func (c *OktaClient) RefreshSession(ctx context.Context, sessionId string) (*Session, error) {
+	return c.sendSessionRequest(ctx, http.MethodPost, "/api/v1/sessions/me/lifecycle/refresh", sessionId, nil)
+}
+
+// Closes the session with the given id, logging the user out.
+func (c *OktaClient) CloseSession(ctx context.Context, sessionId string) error {
+	_, err := c.sendSessionRequest(ctx, http.MethodDelete, "/api/v1/sessions/me", sessionId, nil)
+	return err
+}
+
+// Sends a request to the sessions API as the session with the given id, if set.
+// Returns the session in the response, or nil if the response is empty.
+func (c *OktaClient) sendSessionRequest(ctx context.Context, method, path, sessionId string, body interface{}) (*Session, error) {
+	url := c.rootURL + path
+	logger := c.requestLogger(method, url)
+
+	var header http.Header
+	if sessionId != "" {
+		header = http.Header{"Cookie": {(&http.Cookie{Name: sessionCookieName, Value: sessionId}).String()}}
+	}
+	status, responseBytes, err := c.sendRequest(ctx, method, url, header, body)
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.With(slog.Int(LogKeyStatusCode, status))
+
+	switch {
+	case status == http.StatusNoContent:
+		return nil, nil
+	case status == http.StatusOK:
+		session := &Session{}
+		if err := json.Unmarshal(responseBytes, session); err != nil {
+			logger.Error("Got error unmarshaling session", errorLogAttr(err))
+			return nil, TerminalError(unexpectedErrorMessage)
+		}
+		return session, nil
+	case status == http.StatusNotFound:
+		return nil, ErrSessionNotFound
+	case status >= 400 && status < 500:
+		apiError := &api.APIError{}
+		if err := json.Unmarshal(responseBytes, apiError); err != nil {
+			logger.Error("Got error unmarshaling api error", slog.String("body", c.redactor.body(responseBytes)), errorLogAttr(err))
+			return nil, TerminalError(unexpectedErrorMessage)
+		}
+		logger.Warn("Got api error", slog.String("error_code", apiError.ErrorCode), slog.String(LogKeyError, apiError.ErrorSummary))
+		return nil, apiError
+	}
+
+	logger.Error("Got unexpected server status code", slog.String("body", c.redactor.body(responseBytes)))
+	return nil, TerminalError(unexpectedErrorMessage)
+}
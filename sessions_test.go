@@ -0,0 +1,271 @@
+package okta_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+	"github.com/wearefair/okta-auth/oktatest"
+)
+
+func TestSessions(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	user := server.AddUser(&oktatest.User{Login: login, Password: password})
+
+	client := newClient(t, server, oktatest.NewPrompts(t))
+	ctx := context.Background()
+
+	sessionToken, err := client.Authenticate(login, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := client.CreateSession(ctx, sessionToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Id == "" || session.UserId != user.Id || session.Login != login || session.Status != "ACTIVE" {
+		t.Errorf("unexpected session %#+v", session)
+	}
+
+	t.Run("session tokens can only be exchanged once", func(t *testing.T) {
+		if _, err := client.CreateSession(ctx, sessionToken); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		actual, err := client.GetSession(ctx, session.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual.Id != session.Id {
+			t.Errorf("expected session %s, got %s", session.Id, actual.Id)
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		refreshed, err := client.RefreshSession(ctx, session.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refreshed.ExpiresAt.Before(session.ExpiresAt) {
+			t.Errorf("expected the expiry to be extended, got %s before %s", refreshed.ExpiresAt, session.ExpiresAt)
+		}
+	})
+
+	t.Run("close", func(t *testing.T) {
+		if err := client.CloseSession(ctx, session.Id); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.GetSession(ctx, session.Id); err != okta.ErrSessionNotFound {
+			t.Errorf("expected ErrSessionNotFound, got %v", err)
+		}
+		if err := client.CloseSession(ctx, session.Id); err != okta.ErrSessionNotFound {
+			t.Errorf("expected ErrSessionNotFound, got %v", err)
+		}
+	})
+}
+
+func TestListFactors(t *testing.T) {
+	authenticator := oktatest.NewAuthenticator()
+	authenticator.Name = "YubiKey 5 NFC"
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{
+		Login:    login,
+		Password: password,
+		Factors: []*oktatest.Factor{
+			oktatest.SMSFactor("+1 XXX-XXX-5555", "123456"),
+			oktatest.PushFactor(oktatest.PushApprove),
+			oktatest.WebAuthnFactor(authenticator),
+		},
+	})
+	server.AddUser(&oktatest.User{Login: "nomfa@example.com", Password: password})
+
+	// Listing factors never prompts
+	client := newClient(t, server, oktatest.NewPrompts(t))
+
+	listed, err := client.ListFactors(context.Background(), login, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 3 || listed[0].FactorType != factors.FactorTypeSMS || listed[1].FactorType != factors.FactorTypePush {
+		t.Fatalf("unexpected factors %#+v", listed)
+	}
+	if listed[0].VendorName != "OKTA" || listed[0].Status != factors.FactorStatusActive {
+		t.Errorf("expected the vendor and status, got %#+v", listed[0])
+	}
+	if profile := listed[2].ProfileWebAuthN; profile == nil || profile.CredentialId != authenticator.CredentialId || profile.AuthenticatorName != "YubiKey 5 NFC" {
+		t.Errorf("expected the WebAuthn profile, got %#+v", profile)
+	}
+	requests := server.Requests()
+	if requests[len(requests)-1] != "POST /api/v1/authn/cancel" {
+		t.Errorf("expected the transaction to be cancelled, got requests %v", requests)
+	}
+
+	listed, err = client.ListFactors(context.Background(), "nomfa@example.com", password)
+	if err != nil || len(listed) != 0 {
+		t.Errorf("expected no factors, got %v, %v", listed, err)
+	}
+
+	if _, err := client.ListFactors(context.Background(), login, "wrong"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestTokens(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{Login: login, Password: password})
+	server.AddClient(&oktatest.Client{Id: "cli", RedirectURIs: []string{"http://localhost:8080/callback"}})
+
+	client := newClient(t, server, oktatest.NewPrompts(t))
+	ctx := context.Background()
+	request := okta.TokenRequest{ClientId: "cli", RedirectURI: "http://localhost:8080/callback"}
+
+	t.Run("with a session token", func(t *testing.T) {
+		sessionToken, err := client.Authenticate(login, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := request
+		request.SessionToken = sessionToken
+		request.Scopes = []string{"openid", "offline_access"}
+
+		tokens, err := client.Tokens(ctx, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" || tokens.Expiry.IsZero() {
+			t.Errorf("unexpected tokens %#+v", tokens)
+		}
+		if claims := jwtClaims(t, tokens.IdToken); claims["preferred_username"] != login || claims["nonce"] == "" {
+			t.Errorf("unexpected id token claims %v", claims)
+		}
+
+		// The session token was used up
+		if _, err := client.Tokens(ctx, request); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("with a session id", func(t *testing.T) {
+		sessionToken, err := client.Authenticate(login, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session, err := client.CreateSession(ctx, sessionToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := request
+		request.SessionId = session.Id
+
+		tokens, err := client.Tokens(ctx, request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens.IdToken == "" || tokens.RefreshToken != "" {
+			t.Errorf("unexpected tokens %#+v", tokens)
+		}
+	})
+
+	t.Run("without a session", func(t *testing.T) {
+		request := request
+		request.SessionId = "unknown"
+		_, err := client.Tokens(ctx, request)
+		if oauthError, ok := err.(*okta.OAuthError); !ok || oauthError.Code != "login_required" {
+			t.Errorf("expected login_required, got %v", err)
+		}
+	})
+
+	t.Run("unregistered redirect uri", func(t *testing.T) {
+		request := request
+		request.SessionId = "unknown"
+		request.RedirectURI = "http://localhost:9999/callback"
+		if _, err := client.Tokens(ctx, request); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func jwtClaims(t *testing.T, token string) map[string]interface{} {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a JWT, got %q", token)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestSAMLAssertion(t *testing.T) {
+	server := oktatest.NewServer()
+	defer server.Close()
+	server.AddUser(&oktatest.User{Login: login, Password: password})
+	appURL := server.AddSAMLApp(&oktatest.SAMLApp{
+		Name:       "amazon_aws",
+		ACSURL:     "https://signin.aws.amazon.com/saml",
+		Attributes: map[string][]string{"https://aws.amazon.com/SAML/Attributes/RoleSessionName": {login}},
+	})
+
+	client := newClient(t, server, oktatest.NewPrompts(t))
+	ctx := context.Background()
+
+	assertLogin := func(t *testing.T, assertion string) {
+		t.Helper()
+		response, err := base64.StdEncoding.DecodeString(assertion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(response), "<saml2:NameID>"+login+"</saml2:NameID>") {
+			t.Errorf("expected an assertion for the user, got %s", response)
+		}
+	}
+
+	t.Run("with a session token", func(t *testing.T) {
+		sessionToken, err := client.Authenticate(login, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion, err := client.SAMLAssertion(ctx, okta.SAMLRequest{AppURL: appURL, SessionToken: sessionToken})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertLogin(t, assertion)
+	})
+
+	t.Run("with a session id", func(t *testing.T) {
+		sessionToken, err := client.Authenticate(login, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		session, err := client.CreateSession(ctx, sessionToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion, err := client.SAMLAssertion(ctx, okta.SAMLRequest{AppURL: appURL, SessionId: session.Id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertLogin(t, assertion)
+	})
+
+	t.Run("without a session", func(t *testing.T) {
+		if _, err := client.SAMLAssertion(ctx, okta.SAMLRequest{AppURL: appURL, SessionId: "unknown"}); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
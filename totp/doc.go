@@ -0,0 +1,12 @@
+// Generates RFC 6238 time-based one-time passwords, and answers Okta software
+// TOTP challenges with them for non-interactive automation.
+//
+// To authenticate with a TOTP factor enrolled with a known secret, configure the
+// client config with Prompts before creating the client:
+//
+//	prompts, err := totp.NewPrompts(os.Getenv("OKTA_TOTP_SECRET"), totp.Options{})
+//	...
+//	conf := okta.ClientConfig{OktaDomain: "example.okta.com"}
+//	prompts.Configure(&conf)
+//	client, err := okta.New(conf)
+package totp
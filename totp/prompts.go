@@ -0,0 +1,195 @@
+package totp
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/api"
+	"github.com/wearefair/okta-auth/factors"
+)
+
+// Number of times a replayed code is retried in the next window before giving up.
+const maxReplayRetries = 2
+
+var (
+	// Returned when a generated code is rejected, usually because the secret is
+	// wrong or the clock is too far off.
+	ErrCodeRejected = errors.New("totp: code was rejected")
+	// Returned when generated codes keep being rejected as already used.
+	ErrCodeReplayed = errors.New("totp: code was already used")
+	// Returned when there is no TOTP factor to choose, and no Fallback.
+	ErrNoFactor = errors.New("totp: no TOTP factor to choose")
+)
+
+// Answers software TOTP challenges with codes generated from a secret, for
+// authenticating without a user present.
+//
+// Prompts must be installed with Configure, which also observes the results of
+// verifying codes. If Okta reports a code was already used (PASSCODE_REPLAYED),
+// which happens when two clients share a secret, a code for the next time step is
+// sent once it is valid. Codes are never reused within one Prompts.
+type Prompts struct {
+	okta.NopObserver
+
+	TOTP *TOTP
+	// Only TOTP factors from this provider are chosen, ex: "GOOGLE" or "OKTA".
+	// If empty, the first TOTP factor is chosen.
+	Provider string
+	// Handles interactions other than TOTP challenges. If nil, only TOTP factors are used.
+	Fallback okta.Prompts
+
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+	// Waits for the duration. Defaults to time.Sleep.
+	Sleep func(time.Duration)
+
+	mu sync.Mutex
+	// The last time step a code was sent for, or zero.
+	lastStep uint64
+	// Number of codes sent, and the result of the last, in the current authentication.
+	attempts   int
+	lastResult api.FactorResult
+}
+
+// Returns Prompts answering with codes for the base32 encoded secret.
+func NewPrompts(secret string, opts Options) (*Prompts, error) {
+	t, err := NewFromBase32(secret, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Prompts{TOTP: t}, nil
+}
+
+// Configures the client config to answer prompts with p.
+// Any existing Prompts on the config are used as the Fallback if it isn't set,
+// and any existing Observer is preserved.
+func (p *Prompts) Configure(conf *okta.ClientConfig) {
+	if p.Fallback == nil && conf.Prompts != nil {
+		p.Fallback = conf.Prompts
+	}
+	conf.Prompts = p
+	if conf.Observer == nil {
+		conf.Observer = p
+	} else {
+		conf.Observer = okta.MultiObserver(conf.Observer, p)
+	}
+}
+
+func (p *Prompts) CheckU2FPresence(request okta.VerifyU2FRequest) bool {
+	if p.Fallback == nil {
+		return false
+	}
+	return p.Fallback.CheckU2FPresence(request)
+}
+
+// Chooses a TOTP factor. Returns an error if the last code sent in this authentication
+// was rejected, or has been replayed too many times.
+func (p *Prompts) ChooseFactor(choices []factors.Factor) (factors.Factor, error) {
+	p.mu.Lock()
+	attempts, lastResult := p.attempts, p.lastResult
+	p.mu.Unlock()
+
+	if attempts > 0 {
+		switch {
+		case lastResult != api.FactorResultPasscodeReplayed:
+			return factors.Factor{}, ErrCodeRejected
+		case attempts > maxReplayRetries:
+			return factors.Factor{}, ErrCodeReplayed
+		}
+	}
+
+	for _, choice := range choices {
+		if p.answers(choice) {
+			return choice, nil
+		}
+	}
+	if p.Fallback == nil {
+		return factors.Factor{}, ErrNoFactor
+	}
+	return p.Fallback.ChooseFactor(choices)
+}
+
+func (p *Prompts) PresentUserError(message string) {
+	if p.Fallback != nil {
+		p.Fallback.PresentUserError(message)
+	}
+}
+
+func (p *Prompts) VerifyU2F(ctx context.Context, request okta.VerifyU2FRequest) (okta.VerifyU2FResponse, error) {
+	if p.Fallback == nil {
+		return okta.VerifyU2FResponse{}, ErrNoFactor
+	}
+	return p.Fallback.VerifyU2F(ctx, request)
+}
+
+// Returns the code for the current time step. If a code has already been sent for
+// the step, waits for the next step and returns its code.
+func (p *Prompts) VerifyCode(factor factors.Factor) (string, error) {
+	if !p.answers(factor) {
+		if p.Fallback == nil {
+			return "", ErrNoFactor
+		}
+		return p.Fallback.VerifyCode(factor)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	step := p.TOTP.Step(p.now())
+	if step <= p.lastStep {
+		step = p.lastStep + 1
+		p.sleep(p.TOTP.StepStart(step).Sub(p.now()))
+	}
+	p.lastStep = step
+	p.attempts++
+	p.lastResult = ""
+	return p.TOTP.At(step), nil
+}
+
+func (p *Prompts) VerifyPush() {
+	if p.Fallback != nil {
+		p.Fallback.VerifyPush()
+	}
+}
+
+func (p *Prompts) FactorResult(e okta.FactorResultEvent) {
+	if !p.answers(e.Factor) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastResult = e.Result
+}
+
+func (p *Prompts) AuthenticationFinished(okta.AuthenticationFinishedEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts = 0
+	p.lastResult = ""
+}
+
+// Returns true if the factor is answered with generated codes.
+func (p *Prompts) answers(factor factors.Factor) bool {
+	return factor.FactorType == factors.FactorTypeTokenSoftwareTOTP && (p.Provider == "" || factor.Provider == p.Provider)
+}
+
+func (p *Prompts) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
+
+func (p *Prompts) sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if p.Sleep != nil {
+		p.Sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
@@ -0,0 +1,152 @@
+package totp_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	okta "github.com/wearefair/okta-auth"
+	"github.com/wearefair/okta-auth/factors"
+	"github.com/wearefair/okta-auth/oktatest"
+	"github.com/wearefair/okta-auth/totp"
+)
+
+const (
+	login    = "ci@example.com"
+	password = "correct-horse-battery-staple"
+	secret   = "JBSWY3DPEHPK3PXP"
+)
+
+func TestPrompts(t *testing.T) {
+	generator, err := totp.NewFromBase32(secret, totp.Options{Skew: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("answers with the current code", func(t *testing.T) {
+		clock := newClock()
+		server := newServer(clock, oktatest.SoftwareTOTPFactor("GOOGLE", login, generator))
+		defer server.Close()
+
+		if _, err := authenticate(t, server, newPrompts(t, clock, secret)); err != nil {
+			t.Fatal(err)
+		}
+		if len(clock.sleeps) != 0 {
+			t.Errorf("expected not to wait, got %v", clock.sleeps)
+		}
+	})
+
+	t.Run("waits for the next code when replayed", func(t *testing.T) {
+		clock := newClock()
+		server := newServer(clock, oktatest.SoftwareTOTPFactor("GOOGLE", login, generator))
+		defer server.Close()
+
+		// Another client sharing the secret has used the code for the current step.
+		if _, err := authenticate(t, server, newPrompts(t, clock, secret)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := authenticate(t, server, newPrompts(t, clock, secret)); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(clock.sleeps, []time.Duration{20 * time.Second}) {
+			t.Errorf("expected to wait for the next step, got %v", clock.sleeps)
+		}
+	})
+
+	t.Run("never reuses a code", func(t *testing.T) {
+		clock := newClock()
+		server := newServer(clock, oktatest.SoftwareTOTPFactor("GOOGLE", login, generator))
+		defer server.Close()
+
+		prompts := newPrompts(t, clock, secret)
+		for i := 0; i < 2; i++ {
+			if _, err := authenticate(t, server, prompts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(clock.sleeps, []time.Duration{20 * time.Second}) {
+			t.Errorf("expected to wait for the next step, got %v", clock.sleeps)
+		}
+		if requests := server.Requests(); len(requests) != 6 {
+			t.Errorf("expected no rejected codes, got requests %v", requests)
+		}
+	})
+
+	t.Run("gives up when the code is rejected", func(t *testing.T) {
+		clock := newClock()
+		server := newServer(clock, oktatest.SoftwareTOTPFactor("GOOGLE", login, generator))
+		defer server.Close()
+
+		if _, err := authenticate(t, server, newPrompts(t, clock, "GEZDGNBVGY3TQOJQ")); !errors.Is(err, totp.ErrCodeRejected) {
+			t.Errorf("expected ErrCodeRejected, got %v", err)
+		}
+	})
+
+	t.Run("uses the fallback for other factors", func(t *testing.T) {
+		clock := newClock()
+		server := newServer(clock,
+			oktatest.SoftwareTOTPFactor("OKTA", login, generator),
+			oktatest.SMSFactor("+1 XXX-XXX-5555", "123456"),
+		)
+		defer server.Close()
+
+		prompts := newPrompts(t, clock, secret)
+		prompts.Provider = "GOOGLE"
+		prompts.Fallback = oktatest.NewPrompts(t,
+			oktatest.ExpectChooseFactor(factors.FactorTypeSMS),
+			oktatest.ExpectVerifyCode("123456"),
+		)
+		if _, err := authenticate(t, server, prompts); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func newServer(clock *clock, factors ...*oktatest.Factor) *oktatest.Server {
+	server := oktatest.NewServer()
+	server.SetClock(clock.Now)
+	server.AddUser(&oktatest.User{Login: login, Password: password, Factors: factors})
+	return server
+}
+
+func newPrompts(t *testing.T, clock *clock, secret string) *totp.Prompts {
+	t.Helper()
+	prompts, err := totp.NewPrompts(secret, totp.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompts.Now = clock.Now
+	prompts.Sleep = clock.Sleep
+	return prompts
+}
+
+func authenticate(t *testing.T, server *oktatest.Server, prompts *totp.Prompts) (string, error) {
+	t.Helper()
+	conf := okta.ClientConfig{OktaDomain: server.URL}
+	prompts.Configure(&conf)
+	client, err := okta.New(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client.Authenticate(login, password)
+}
+
+// A fake clock starting 10 seconds into a time step, which sleeping advances.
+type clock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func newClock() *clock {
+	return &clock{now: time.Unix(1234567900, 0)}
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Sleep(d time.Duration) {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,200 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// The hash function used to generate codes.
+type Algorithm string
+
+const (
+	SHA1   = Algorithm("SHA1")
+	SHA256 = Algorithm("SHA256")
+	SHA512 = Algorithm("SHA512")
+)
+
+// Defaults used for zero Options, which are what Okta and most authenticator apps use.
+const (
+	DefaultAlgorithm = SHA1
+	DefaultDigits    = 6
+	DefaultPeriod    = 30 * time.Second
+)
+
+type Options struct {
+	// Defaults to SHA1.
+	Algorithm Algorithm
+	// Number of digits in each code, between 6 and 8. Defaults to 6.
+	Digits int
+	// How long each code is valid for. Defaults to 30 seconds.
+	Period time.Duration
+	// Number of periods before and after the current one that Validate accepts codes
+	// from, to allow for clock drift between the generator and validator.
+	Skew int
+}
+
+// Generates and validates time-based one-time passwords for a shared secret.
+// https://tools.ietf.org/html/rfc6238
+type TOTP struct {
+	secret []byte
+	opts   Options
+	hash   func() hash.Hash
+}
+
+// Returns a TOTP for the secret. Zero options are set to their defaults.
+func New(secret []byte, opts Options) (*TOTP, error) {
+	if opts.Algorithm == "" {
+		opts.Algorithm = DefaultAlgorithm
+	}
+	if opts.Digits == 0 {
+		opts.Digits = DefaultDigits
+	}
+	if opts.Period == 0 {
+		opts.Period = DefaultPeriod
+	}
+
+	h, err := opts.Algorithm.hash()
+	if err != nil {
+		return nil, err
+	}
+	if err := validateDigits(opts.Digits); err != nil {
+		return nil, err
+	}
+	if opts.Period < time.Second {
+		return nil, fmt.Errorf("totp: period must be at least a second, got %s", opts.Period)
+	}
+	if opts.Skew < 0 {
+		return nil, fmt.Errorf("totp: skew must not be negative, got %d", opts.Skew)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("totp: secret is empty")
+	}
+	return &TOTP{secret: secret, opts: opts, hash: h}, nil
+}
+
+// Returns a TOTP for a base32 encoded secret, as shown when enrolling an authenticator app.
+func NewFromBase32(secret string, opts Options) (*TOTP, error) {
+	decoded, err := DecodeSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return New(decoded, opts)
+}
+
+// Decodes a base32 secret. Case, spaces, dashes and padding are ignored, as secrets
+// are often displayed in groups for readability.
+func DecodeSecret(secret string) ([]byte, error) {
+	cleaned := strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '=':
+			return -1
+		}
+		return r
+	}, strings.ToUpper(secret))
+
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("totp: invalid base32 secret: %s", err)
+	}
+	return decoded, nil
+}
+
+// Returns the options, with defaults set.
+func (t *TOTP) Options() Options {
+	return t.opts
+}
+
+// Returns the time step at the given time, the number of periods since the unix epoch.
+func (t *TOTP) Step(now time.Time) uint64 {
+	return uint64(now.Unix()) / uint64(t.opts.Period/time.Second)
+}
+
+// Returns the time the step starts, after which its code is valid.
+func (t *TOTP) StepStart(step uint64) time.Time {
+	return time.Unix(int64(step*uint64(t.opts.Period/time.Second)), 0)
+}
+
+// Returns the code for the given time.
+func (t *TOTP) Code(now time.Time) string {
+	return t.At(t.Step(now))
+}
+
+// Returns the code for the given time step.
+func (t *TOTP) At(step uint64) string {
+	return hotp(t.hash, t.secret, step, t.opts.Digits)
+}
+
+// Checks the code against the steps within the skew of the given time.
+// Returns the step the code is for, so callers can reject codes for steps that
+// have already been used.
+func (t *TOTP) Validate(code string, now time.Time) (uint64, bool) {
+	current := t.Step(now)
+	for offset := -t.opts.Skew; offset <= t.opts.Skew; offset++ {
+		if offset < 0 && current < uint64(-offset) {
+			continue
+		}
+		step := current + uint64(int64(offset))
+		if subtle.ConstantTimeCompare([]byte(t.At(step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the HMAC-based one-time password for the counter.
+// https://tools.ietf.org/html/rfc4226
+func HOTP(secret []byte, counter uint64, digits int, algorithm Algorithm) (string, error) {
+	h, err := algorithm.hash()
+	if err != nil {
+		return "", err
+	}
+	if err := validateDigits(digits); err != nil {
+		return "", err
+	}
+	return hotp(h, secret, counter, digits), nil
+}
+
+func hotp(h func() hash.Hash, secret []byte, counter uint64, digits int) string {
+	mac := hmac.New(h, secret)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, the low 4 bits of the last byte are the offset of the 31 bit code.
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, code%modulo)
+}
+
+func validateDigits(digits int) error {
+	if digits < 6 || digits > 8 {
+		return fmt.Errorf("totp: digits must be between 6 and 8, got %d", digits)
+	}
+	return nil
+}
+
+func (a Algorithm) hash() (func() hash.Hash, error) {
+	switch Algorithm(strings.ToUpper(string(a))) {
+	case SHA1:
+		return sha1.New, nil
+	case SHA256:
+		return sha256.New, nil
+	case SHA512:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("totp: unsupported algorithm %q", a)
+}
@@ -0,0 +1,125 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// https://tools.ietf.org/html/rfc4226#appendix-D
+func TestHOTP(t *testing.T) {
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		actual, err := HOTP([]byte("12345678901234567890"), uint64(counter), 6, SHA1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != code {
+			t.Errorf("counter %d: expected %s, got %s", counter, code, actual)
+		}
+	}
+}
+
+// https://tools.ietf.org/html/rfc6238#appendix-B
+func TestTOTP(t *testing.T) {
+	secrets := map[Algorithm]string{
+		SHA1:   "12345678901234567890",
+		SHA256: "12345678901234567890123456789012",
+		SHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	testCases := []struct {
+		time      int64
+		algorithm Algorithm
+		expected  string
+	}{
+		{59, SHA1, "94287082"},
+		{59, SHA256, "46119246"},
+		{59, SHA512, "90693936"},
+		{1111111109, SHA1, "07081804"},
+		{1111111109, SHA256, "68084774"},
+		{1111111109, SHA512, "25091201"},
+		{1234567890, SHA1, "89005924"},
+		{1234567890, SHA256, "91819424"},
+		{1234567890, SHA512, "93441116"},
+		{20000000000, SHA1, "65353130"},
+		{20000000000, SHA256, "77737706"},
+		{20000000000, SHA512, "47863826"},
+	}
+
+	for i, testCase := range testCases {
+		generator, err := New([]byte(secrets[testCase.algorithm]), Options{Algorithm: testCase.algorithm, Digits: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := generator.Code(time.Unix(testCase.time, 0))
+		if actual != testCase.expected {
+			t.Errorf("%0d: expected %s, got %s", i, testCase.expected, actual)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	generator, err := New([]byte("12345678901234567890"), Options{Skew: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1234567890, 0)
+	current := generator.Step(now)
+
+	testCases := []struct {
+		step  uint64
+		valid bool
+	}{
+		{current - 2, false},
+		{current - 1, true},
+		{current, true},
+		{current + 1, true},
+		{current + 2, false},
+	}
+	for _, testCase := range testCases {
+		step, ok := generator.Validate(generator.At(testCase.step), now)
+		if ok != testCase.valid {
+			t.Errorf("step %d: expected valid to be %t", testCase.step, testCase.valid)
+		}
+		if ok && step != testCase.step {
+			t.Errorf("expected step %d, got %d", testCase.step, step)
+		}
+	}
+
+	if _, ok := generator.Validate("000000", time.Unix(0, 0)); ok {
+		t.Error("expected an invalid code at the epoch to be rejected")
+	}
+}
+
+func TestDecodeSecret(t *testing.T) {
+	for _, secret := range []string{"GEZDGNBVGY3TQOJQ", "gezd gnbv gy3t qojq", "GEZDG-NBVGY-3TQOJQ", "GEZDGNBVGY3TQOJQ===="} {
+		decoded, err := DecodeSecret(secret)
+		if err != nil {
+			t.Errorf("%q: %s", secret, err)
+			continue
+		}
+		if string(decoded) != "1234567890" {
+			t.Errorf("%q: expected 1234567890, got %q", secret, decoded)
+		}
+	}
+
+	if _, err := DecodeSecret("not base32!"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestNewValidatesOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Algorithm: "MD5"},
+		{Digits: 5},
+		{Digits: 9},
+		{Period: time.Millisecond},
+		{Skew: -1},
+	} {
+		if _, err := New([]byte("secret"), opts); err == nil {
+			t.Errorf("%#+v: expected error", opts)
+		}
+	}
+	if _, err := New(nil, Options{}); err == nil {
+		t.Error("expected error for an empty secret")
+	}
+}
@@ -0,0 +1,133 @@
+package okta
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/wearefair/okta-auth/api"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/wearefair/okta-auth"
+
+// Attributes set on spans, in addition to the HTTP semantic conventions.
+const (
+	traceKeyDomain            = attribute.Key("okta.domain")
+	traceKeyTransactionStatus = attribute.Key("okta.transaction.status")
+	traceKeyFactorType        = attribute.Key("okta.factor.type")
+	traceKeyFactorProvider    = attribute.Key("okta.factor.provider")
+	traceKeyFactorResult      = attribute.Key("okta.factor.result")
+	traceKeyRequestId         = attribute.Key("okta.request_id")
+	traceKeyPrompt            = attribute.Key("okta.prompt")
+	traceKeyPromptWait        = attribute.Key("okta.prompt.wait_ms")
+)
+
+func newTracer(conf ClientConfig) trace.Tracer {
+	provider := conf.TracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+func newPropagator(conf ClientConfig) propagation.TextMapPropagator {
+	if conf.Propagator != nil {
+		return conf.Propagator
+	}
+	return otel.GetTextMapPropagator()
+}
+
+// Starts a span for the new state of the transaction, ending the span of the previous state.
+// State spans are siblings under the span for the whole flow.
+func (c *OktaClient) startStateSpan(flow *authFlow, status api.TransactionState) {
+	if flow.stateSpan != nil {
+		flow.stateSpan.End()
+	}
+	flow.stateCtx, flow.stateSpan = c.tracer.Start(flow.rootCtx, "okta.state "+string(status),
+		trace.WithAttributes(traceKeyTransactionStatus.String(string(status))))
+	flow.ctx = flow.stateCtx
+}
+
+// Starts a span covering the verification of the challenged factor.
+func (c *OktaClient) startChallengeSpan(flow *authFlow) {
+	flow.ctx, flow.challengeSpan = c.tracer.Start(flow.stateCtx, "okta.factor.verify",
+		trace.WithAttributes(
+			traceKeyFactorType.String(string(flow.challengeFactor.FactorType)),
+			traceKeyFactorProvider.String(flow.challengeFactor.Provider),
+		))
+}
+
+func (c *OktaClient) endChallengeSpan(flow *authFlow, result api.FactorResult) {
+	if flow.challengeSpan == nil {
+		return
+	}
+	flow.challengeSpan.SetAttributes(traceKeyFactorResult.String(string(result)))
+	if result != api.FactorResultSuccess {
+		flow.challengeSpan.SetStatus(codes.Error, string(result))
+	}
+	flow.challengeSpan.End()
+	flow.challengeSpan = nil
+	flow.ctx = flow.stateCtx
+}
+
+// Ends all spans of the flow, recording the error if there is one.
+func (c *OktaClient) endFlowSpans(flow *authFlow, err error) {
+	if flow.challengeSpan != nil {
+		flow.challengeSpan.End()
+	}
+	if flow.stateSpan != nil {
+		flow.stateSpan.End()
+	}
+
+	flow.rootSpan.SetAttributes(traceKeyPromptWait.Int64(flow.promptWait.Milliseconds()))
+	if err != nil {
+		flow.rootSpan.RecordError(err)
+		flow.rootSpan.SetStatus(codes.Error, err.Error())
+	}
+	flow.rootSpan.End()
+}
+
+// Calls the prompt in its own span, so time spent waiting on the user is kept
+// separate from time spent waiting on Okta.
+func (c *OktaClient) prompt(flow *authFlow, name string, fn func()) {
+	_, span := c.tracer.Start(flow.ctx, "okta.prompt "+name, trace.WithAttributes(traceKeyPrompt.String(name)))
+	start := time.Now()
+	fn()
+	flow.promptWait += time.Since(start)
+	span.End()
+}
+
+// Starts a client span for the request, and injects the trace context into its headers.
+func (c *OktaClient) startRequestSpan(ctx context.Context, request *http.Request) (context.Context, trace.Span) {
+	ctx, span := c.tracer.Start(ctx, request.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(request.Method),
+			semconv.URLFull(request.URL.Scheme+"://"+request.URL.Host+request.URL.Path),
+			semconv.ServerAddress(request.URL.Hostname()),
+		))
+	c.propagator.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	return ctx, span
+}
+
+func endRequestSpan(span trace.Span, response *http.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(response.StatusCode),
+			traceKeyRequestId.String(response.Header.Get(oktaRequestIdHeader)),
+		)
+		if response.StatusCode >= 400 {
+			span.SetStatus(codes.Error, http.StatusText(response.StatusCode))
+		}
+	}
+	span.End()
+}
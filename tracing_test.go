@@ -0,0 +1,76 @@
+package okta
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	server := newSMSTestServer()
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	client, err := New(ClientConfig{
+		OktaDomain:     server.URL,
+		Prompts:        TestPrompts{},
+		TracerProvider: provider,
+		Propagator:     propagation.TraceContext{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "login")
+	if _, err := client.AuthenticateContext(ctx, "first@example.com", "password"); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	var httpSpans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "POST" {
+			httpSpans = append(httpSpans, span)
+		} else {
+			spans[span.Name()] = span
+		}
+	}
+
+	expectedParents := map[string]string{
+		"okta.Authenticate":        "login",
+		"okta.state MFA_REQUIRED":  "okta.Authenticate",
+		"okta.state MFA_CHALLENGE": "okta.Authenticate",
+		"okta.state SUCCESS":       "okta.Authenticate",
+		"okta.prompt ChooseFactor": "okta.state MFA_REQUIRED",
+		"okta.factor.verify":       "okta.state MFA_CHALLENGE",
+		"okta.prompt VerifyCode":   "okta.factor.verify",
+	}
+	for name, parentName := range expectedParents {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("expected span %q", name)
+			continue
+		}
+		if span.Parent().SpanID() != spans[parentName].SpanContext().SpanID() {
+			t.Errorf("expected span %q to have parent %q", name, parentName)
+		}
+	}
+
+	if len(httpSpans) != 3 {
+		t.Fatalf("expected 3 http spans, got %d", len(httpSpans))
+	}
+	for i, span := range httpSpans {
+		if span.Parent().TraceID() != parent.SpanContext().TraceID() {
+			t.Errorf("expected http span %d to be part of the trace", i)
+		}
+		expected := "00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String() + "-01"
+		if server.traceparents[i] != expected {
+			t.Errorf("expected request %d to have traceparent %q, got %q", i, expected, server.traceparents[i])
+		}
+	}
+}